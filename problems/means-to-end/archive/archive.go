@@ -0,0 +1,230 @@
+// Package archive reads and writes the price series of closed means to an end
+// sessions.
+//
+// Series are written as CSV with a timestamp,price header, or in a compact
+// binary format: the magic bytes "MTEA", a version byte and the number of
+// points as a uvarint, followed by a column of timestamps and a column of
+// prices. Each column holds the zigzag varint differences between consecutive
+// values, starting from 0, so that series sampled at regular intervals with
+// slowly moving prices take a byte or two per value.
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+)
+
+const (
+	FormatCSV    = "csv"
+	FormatBinary = "binary"
+)
+
+var binaryMagic = []byte("MTEA")
+
+const binaryVersion = 1
+
+type Point struct {
+	Timestamp int32
+	Price     int32
+}
+
+// File extension of archives in format
+func Extension(format string) string {
+	if format == FormatBinary {
+		return ".mtea"
+	}
+
+	return ".csv"
+}
+
+func ValidateFormat(format string) error {
+	switch format {
+	case FormatCSV, FormatBinary:
+		return nil
+	default:
+		return fmt.Errorf("unknown archive format %q, must be csv or binary", format)
+	}
+}
+
+func Write(w io.Writer, format string, points []Point) error {
+	if format == FormatBinary {
+		return writeBinary(w, points)
+	}
+
+	return writeCSV(w, points)
+}
+
+func writeCSV(w io.Writer, points []Point) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write([]string{"timestamp", "price"}); err != nil {
+		return err
+	}
+
+	for _, point := range points {
+		record := []string{strconv.Itoa(int(point.Timestamp)), strconv.Itoa(int(point.Price))}
+
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
+
+func writeBinary(w io.Writer, points []Point) error {
+	encoded := append([]byte{}, binaryMagic...)
+	encoded = append(encoded, binaryVersion)
+	encoded = binary.AppendUvarint(encoded, uint64(len(points)))
+
+	previous := int64(0)
+
+	for _, point := range points {
+		encoded = binary.AppendVarint(encoded, int64(point.Timestamp)-previous)
+		previous = int64(point.Timestamp)
+	}
+
+	previous = 0
+
+	for _, point := range points {
+		encoded = binary.AppendVarint(encoded, int64(point.Price)-previous)
+		previous = int64(point.Price)
+	}
+
+	_, err := w.Write(encoded)
+
+	return err
+}
+
+// Reads an archive in either format
+func Read(r io.Reader) ([]Point, error) {
+	reader := bufio.NewReader(r)
+	magic, err := reader.Peek(len(binaryMagic))
+
+	if err == nil && bytes.Equal(magic, binaryMagic) {
+		return readBinary(reader)
+	}
+
+	return readCSV(reader)
+}
+
+func ReadFile(path string) ([]Point, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	points, err := Read(file)
+
+	if err != nil {
+		return nil, fmt.Errorf("reading archive %s: %w", path, err)
+	}
+
+	return points, nil
+}
+
+func readCSV(r io.Reader) ([]Point, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+
+	records, err := reader.ReadAll()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, errors.New("missing header")
+	}
+
+	points := make([]Point, 0, len(records)-1)
+
+	for i, record := range records[1:] {
+		timestamp, timestampErr := strconv.ParseInt(record[0], 10, 32)
+		price, priceErr := strconv.ParseInt(record[1], 10, 32)
+
+		if timestampErr != nil || priceErr != nil {
+			return nil, fmt.Errorf("line %d: expected int32 timestamp and price, got %q", i+2, record)
+		}
+
+		points = append(points, Point{Timestamp: int32(timestamp), Price: int32(price)})
+	}
+
+	return points, nil
+}
+
+func readBinary(reader *bufio.Reader) ([]Point, error) {
+	header := make([]byte, len(binaryMagic)+1)
+
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+
+	if version := header[len(binaryMagic)]; version != binaryVersion {
+		return nil, fmt.Errorf("unsupported binary archive version %d", version)
+	}
+
+	count, err := binary.ReadUvarint(reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	timestamps, err := readColumn(reader, count)
+
+	if err != nil {
+		return nil, fmt.Errorf("timestamps: %w", err)
+	}
+
+	prices, err := readColumn(reader, count)
+
+	if err != nil {
+		return nil, fmt.Errorf("prices: %w", err)
+	}
+
+	points := make([]Point, count)
+
+	for i := range points {
+		points[i] = Point{Timestamp: timestamps[i], Price: prices[i]}
+	}
+
+	return points, nil
+}
+
+func readColumn(reader *bufio.Reader, count uint64) ([]int32, error) {
+	// Every value takes at least a byte, which bounds the allocation for
+	// corrupt counts
+	column := make([]int32, 0, min(count, uint64(reader.Size())))
+	value := int64(0)
+
+	for range count {
+		delta, err := binary.ReadVarint(reader)
+
+		if err != nil {
+			return nil, err
+		}
+
+		value += delta
+
+		if value < math.MinInt32 || value > math.MaxInt32 {
+			return nil, fmt.Errorf("value %d out of the int32 range", value)
+		}
+
+		column = append(column, int32(value))
+	}
+
+	return column, nil
+}
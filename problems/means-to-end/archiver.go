@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nivekithan/go-network/problems/means-to-end/archive"
+)
+
+// Archiver writes the series of every closed session to its own file in dir,
+// named after the time the session closed and its id
+type Archiver struct {
+	dir    string
+	format string
+}
+
+func NewArchiver(dir string, format string) (*Archiver, error) {
+	if err := archive.ValidateFormat(format); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &Archiver{dir: dir, format: format}, nil
+}
+
+func (a *Archiver) archiveSession(store PriceStore, connectionId string) error {
+	series, err := store.Series(context.Background())
+
+	if err != nil {
+		return err
+	}
+
+	if len(series) == 0 {
+		return nil
+	}
+
+	points := make([]archive.Point, len(series))
+
+	for i, point := range series {
+		points[i] = archive.Point{Timestamp: point.timestamp, Price: point.price}
+	}
+
+	name := fmt.Sprintf("%s-%s%s", time.Now().UTC().Format("20060102T150405Z"), connectionId, archive.Extension(a.format))
+
+	// Written under a temporary name so that readers never see a partial
+	// archive
+	file, err := os.CreateTemp(a.dir, ".archive-*")
+
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(file.Name())
+
+	if err := file.Chmod(0o644); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := archive.Write(file, a.format, points); err != nil {
+		file.Close()
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(file.Name(), filepath.Join(a.dir, name)); err != nil {
+		return err
+	}
+
+	log.Printf("Archived %d prices of session %s to %s\n", len(points), connectionId, name)
+
+	return nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// The handshake is only understood when the server runs with -assets. It
+// binds the connection to a named asset which every connection that knows
+// one of its tokens shares. The handshake must be the first command: A
+// followed by the int32 lengths of the name and the token, then the name and
+// the token themselves.
+const InstructionHandshake = "A"
+
+// Longest asset name or token a handshake may carry
+const maxCredentialLength = 255
+
+// What a token allows on an asset
+type AssetGrant struct {
+	Token  string `json:"token"`
+	Insert bool   `json:"insert"`
+	Query  bool   `json:"query"`
+}
+
+// AssetPolicy lists the named assets and the tokens which give access to
+// them. Feed handlers and query clients usually get separate tokens.
+//
+//	{
+//	  "assets": {
+//	    "BTC": [
+//	      {"token": "feed-secret", "insert": true},
+//	      {"token": "dashboard-secret", "query": true}
+//	    ]
+//	  }
+//	}
+type AssetPolicy struct {
+	Assets map[string][]AssetGrant `json:"assets"`
+}
+
+func LoadAssetPolicy(path string) (*AssetPolicy, error) {
+	content, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &AssetPolicy{}
+
+	if err := json.Unmarshal(content, policy); err != nil {
+		return nil, fmt.Errorf("parsing assets %s: %w", path, err)
+	}
+
+	return policy, nil
+}
+
+// Returns the grant of token on asset
+func (p *AssetPolicy) grant(asset string, token string) (AssetGrant, bool) {
+	for _, grant := range p.Assets[asset] {
+		if subtle.ConstantTimeCompare([]byte(grant.Token), []byte(token)) == 1 {
+			return grant, true
+		}
+	}
+
+	return AssetGrant{}, false
+}
+
+type HandshakeCommand struct {
+	Asset string
+	Token string
+
+	nameLength  int32
+	tokenLength int32
+}
+
+func (c *HandshakeCommand) Type() string {
+	return InstructionHandshake
+}
+
+// Reads the name and the token which follow the 9 bytes of an A command
+func (c *HandshakeCommand) readCredentials(r io.Reader) error {
+	for _, length := range []int32{c.nameLength, c.tokenLength} {
+		if length <= 0 || length > maxCredentialLength {
+			return fmt.Errorf("%w: length %d, must be between 1 and %d", errInvalidHandshake, length, maxCredentialLength)
+		}
+	}
+
+	credentials := make([]byte, c.nameLength+c.tokenLength)
+
+	if _, err := io.ReadFull(r, credentials); err != nil {
+		return fmt.Errorf("Error while reading handshake: %s", err)
+	}
+
+	c.Asset = string(credentials[:c.nameLength])
+	c.Token = string(credentials[c.nameLength:])
+
+	return nil
+}
+
+var (
+	errUnknownAsset     = errors.New("unknown asset or token")
+	errInsertNotAllowed = errors.New("token does not allow inserts")
+	errQueryNotAllowed  = errors.New("token does not allow queries")
+)
+
+// Assets holds the stores of the named assets, which outlive the connections
+// using them
+type Assets struct {
+	policy   *AssetPolicy
+	newStore StoreFactory
+
+	lock   sync.Mutex
+	stores map[string]PriceStore
+}
+
+func NewAssets(policy *AssetPolicy, newStore StoreFactory) *Assets {
+	return &Assets{policy: policy, newStore: newStore, stores: map[string]PriceStore{}}
+}
+
+// Returns the store of the asset restricted to what token allows
+func (a *Assets) open(asset string, token string) (PriceStore, error) {
+	grant, ok := a.policy.grant(asset, token)
+
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", errUnknownAsset, asset)
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	store, ok := a.stores[asset]
+
+	if !ok {
+		// Session ids never contain a slash, so asset rows can not be
+		// mistaken for the rows of a session
+		store = &LockedStore{store: a.newStore("asset/" + asset)}
+		a.stores[asset] = store
+	}
+
+	return &GrantedStore{store: store, grant: grant}, nil
+}
+
+// LockedStore serialises the connections which share a store
+type LockedStore struct {
+	lock  sync.Mutex
+	store PriceStore
+}
+
+func (s *LockedStore) Insert(ctx context.Context, points []PricePoint) (int, int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.store.Insert(ctx, points)
+}
+
+func (s *LockedStore) Contains(ctx context.Context, timestamp int32) (bool, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.store.Contains(ctx, timestamp)
+}
+
+func (s *LockedStore) Summary(ctx context.Context, minTime int32, maxTime int32) (PriceSum, int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.store.Summary(ctx, minTime, maxTime)
+}
+
+func (s *LockedStore) Extremes(ctx context.Context, minTime int32, maxTime int32) (int32, int32, int64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.store.Extremes(ctx, minTime, maxTime)
+}
+
+func (s *LockedStore) Variance(ctx context.Context, minTime int32, maxTime int32) (float64, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.store.Variance(ctx, minTime, maxTime)
+}
+
+func (s *LockedStore) PricesByRank(ctx context.Context, minTime int32, maxTime int32, offset int64, limit int64) ([]int32, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.store.PricesByRank(ctx, minTime, maxTime, offset, limit)
+}
+
+func (s *LockedStore) Series(ctx context.Context) ([]PricePoint, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.store.Series(ctx)
+}
+
+func (s *LockedStore) Drop(ctx context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.store.Drop(ctx)
+}
+
+// GrantedStore rejects the commands which the token of a connection does not
+// allow
+type GrantedStore struct {
+	store PriceStore
+	grant AssetGrant
+}
+
+func (s *GrantedStore) Insert(ctx context.Context, points []PricePoint) (int, int, error) {
+	if !s.grant.Insert {
+		return 0, 0, errInsertNotAllowed
+	}
+
+	return s.store.Insert(ctx, points)
+}
+
+// Only used to decide how an insert is applied, so it needs the insert grant
+func (s *GrantedStore) Contains(ctx context.Context, timestamp int32) (bool, error) {
+	if !s.grant.Insert {
+		return false, errInsertNotAllowed
+	}
+
+	return s.store.Contains(ctx, timestamp)
+}
+
+func (s *GrantedStore) Summary(ctx context.Context, minTime int32, maxTime int32) (PriceSum, int64, error) {
+	if !s.grant.Query {
+		return PriceSum{}, 0, errQueryNotAllowed
+	}
+
+	return s.store.Summary(ctx, minTime, maxTime)
+}
+
+func (s *GrantedStore) Extremes(ctx context.Context, minTime int32, maxTime int32) (int32, int32, int64, error) {
+	if !s.grant.Query {
+		return 0, 0, 0, errQueryNotAllowed
+	}
+
+	return s.store.Extremes(ctx, minTime, maxTime)
+}
+
+func (s *GrantedStore) Variance(ctx context.Context, minTime int32, maxTime int32) (float64, error) {
+	if !s.grant.Query {
+		return 0, errQueryNotAllowed
+	}
+
+	return s.store.Variance(ctx, minTime, maxTime)
+}
+
+func (s *GrantedStore) PricesByRank(ctx context.Context, minTime int32, maxTime int32, offset int64, limit int64) ([]int32, error) {
+	if !s.grant.Query {
+		return nil, errQueryNotAllowed
+	}
+
+	return s.store.PricesByRank(ctx, minTime, maxTime, offset, limit)
+}
+
+func (s *GrantedStore) Series(ctx context.Context) ([]PricePoint, error) {
+	if !s.grant.Query {
+		return nil, errQueryNotAllowed
+	}
+
+	return s.store.Series(ctx)
+}
+
+func (s *GrantedStore) Drop(ctx context.Context) error {
+	if !s.grant.Insert {
+		return errInsertNotAllowed
+	}
+
+	return s.store.Drop(ctx)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	"github.com/nivekithan/go-network/problems/means-to-end/archive"
+)
+
+type Config struct {
+	// Where the prices of a session are kept: memory or sqlite
+	store string
+
+	// What happens to a price for a timestamp which already has one: keep,
+	// reject or overwrite
+	duplicates string
+
+	// Whether the extension commands are understood. Without them only I
+	// and Q are valid
+	extensions bool
+
+	// Path of the JSON file listing the named assets and their tokens. The
+	// handshake is only understood when set
+	assetsPath string
+
+	// Loaded from assetsPath
+	assets *AssetPolicy
+
+	// How long the prices of a closed connection are kept before they are
+	// deleted
+	sessionGrace time.Duration
+
+	// Rows a single connection may add before it is disconnected. Unlimited
+	// when 0
+	maxConnectionRows int64
+
+	// Rows kept across every session and named asset. Unlimited when 0
+	maxRows int64
+
+	// Directory which the series of closed sessions are written to. Sessions
+	// are not archived when empty
+	archiveDir string
+
+	// Format of the archives: csv or binary
+	archiveFormat string
+
+	// Whether connections may ask for error frames with the E command
+	errorFrames bool
+
+	// Recoverable errors a connection with error frames enabled may make
+	// before it is closed
+	errorBudget int
+}
+
+func parseFlags() *Config {
+	config := &Config{}
+
+	flag.StringVar(&config.store, "store", StoreMemory, "where the prices of a session are kept: memory or sqlite")
+	flag.StringVar(&config.duplicates, "duplicates", DuplicatesKeep, "what happens to a price for a timestamp which already has one: keep, reject or overwrite")
+	flag.BoolVar(&config.extensions, "extensions", false, "understand the extension commands L, H, C, M, S, B and N besides I and Q")
+	flag.StringVar(&config.assetsPath, "assets", "", "path of the JSON file listing the named assets and their tokens. Connections can not share assets when empty")
+	flag.DurationVar(&config.sessionGrace, "session-grace", 0, "how long the prices of a closed connection are kept before they are deleted")
+	flag.Int64Var(&config.maxConnectionRows, "max-connection-rows", 0, "rows a single connection may add before it is disconnected. Overwritten prices take no row. Unlimited when 0")
+	flag.Int64Var(&config.maxRows, "max-rows", 0, "rows kept across every session and named asset. Inserts beyond it disconnect their connection. Unlimited when 0")
+	flag.StringVar(&config.archiveDir, "archive-dir", "", "directory which the series of closed sessions are written to. Sessions are not archived when empty")
+	flag.StringVar(&config.archiveFormat, "archive-format", archive.FormatCSV, "format of the archives: csv or binary")
+	flag.BoolVar(&config.errorFrames, "error-frames", false, "let connections ask for error frames with the E command")
+	flag.IntVar(&config.errorBudget, "error-budget", 0, "recoverable errors a connection with error frames enabled may make before it is closed")
+
+	flag.Parse()
+
+	return config
+}
@@ -9,6 +9,36 @@ import (
 	"context"
 )
 
+const countAssestPriceAtTimestamp = `-- name: CountAssestPriceAtTimestamp :one
+SELECT COUNT(*) FROM assest_price WHERE
+    assest_id = ?1 AND
+    timestamp = ?2
+`
+
+type CountAssestPriceAtTimestampParams struct {
+	AssestID  string
+	Timestamp int64
+}
+
+func (q *Queries) CountAssestPriceAtTimestamp(ctx context.Context, arg CountAssestPriceAtTimestampParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countAssestPriceAtTimestamp, arg.AssestID, arg.Timestamp)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const deleteAssestPrices = `-- name: DeleteAssestPrices :execrows
+DELETE FROM assest_price WHERE assest_id = ?1
+`
+
+func (q *Queries) DeleteAssestPrices(ctx context.Context, assestID string) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteAssestPrices, assestID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 const getAllAssestsPrice = `-- name: GetAllAssestsPrice :many
 SELECT id, assest_id, timestamp, price FROM assest_price
 `
@@ -41,6 +71,32 @@ func (q *Queries) GetAllAssestsPrice(ctx context.Context) ([]AssestPrice, error)
 	return items, nil
 }
 
+const getAssestPriceExtremesInTimeRange = `-- name: GetAssestPriceExtremesInTimeRange :one
+SELECT CAST(COALESCE(MIN(price), 0) AS INTEGER) AS low, CAST(COALESCE(MAX(price), 0) AS INTEGER) AS high, COUNT(*) AS count FROM assest_price WHERE
+    assest_id = ?1 AND
+    timestamp >= ?2 AND
+    timestamp <= ?3
+`
+
+type GetAssestPriceExtremesInTimeRangeParams struct {
+	AssestID     string
+	MinTimestamp int64
+	MaxTimestamp int64
+}
+
+type GetAssestPriceExtremesInTimeRangeRow struct {
+	Low   int64
+	High  int64
+	Count int64
+}
+
+func (q *Queries) GetAssestPriceExtremesInTimeRange(ctx context.Context, arg GetAssestPriceExtremesInTimeRangeParams) (GetAssestPriceExtremesInTimeRangeRow, error) {
+	row := q.db.QueryRowContext(ctx, getAssestPriceExtremesInTimeRange, arg.AssestID, arg.MinTimestamp, arg.MaxTimestamp)
+	var i GetAssestPriceExtremesInTimeRangeRow
+	err := row.Scan(&i.Low, &i.High, &i.Count)
+	return i, err
+}
+
 const getAssestPriceInTimeRange = `-- name: GetAssestPriceInTimeRange :many
 SELECT price FROM assest_price WHERE
     assest_id = ?1 AND
@@ -77,6 +133,141 @@ func (q *Queries) GetAssestPriceInTimeRange(ctx context.Context, arg GetAssestPr
 	return items, nil
 }
 
+const getAssestPriceSummaryInTimeRange = `-- name: GetAssestPriceSummaryInTimeRange :one
+SELECT
+    CAST(COALESCE(SUM(price >> 16), 0) AS INTEGER) AS high_total,
+    CAST(COALESCE(SUM(price & 65535), 0) AS INTEGER) AS low_total,
+    COUNT(*) AS count
+FROM assest_price WHERE
+    assest_id = ?1 AND
+    timestamp >= ?2 AND
+    timestamp <= ?3
+`
+
+type GetAssestPriceSummaryInTimeRangeParams struct {
+	AssestID     string
+	MinTimestamp int64
+	MaxTimestamp int64
+}
+
+type GetAssestPriceSummaryInTimeRangeRow struct {
+	HighTotal int64
+	LowTotal  int64
+	Count     int64
+}
+
+func (q *Queries) GetAssestPriceSummaryInTimeRange(ctx context.Context, arg GetAssestPriceSummaryInTimeRangeParams) (GetAssestPriceSummaryInTimeRangeRow, error) {
+	row := q.db.QueryRowContext(ctx, getAssestPriceSummaryInTimeRange, arg.AssestID, arg.MinTimestamp, arg.MaxTimestamp)
+	var i GetAssestPriceSummaryInTimeRangeRow
+	err := row.Scan(&i.HighTotal, &i.LowTotal, &i.Count)
+	return i, err
+}
+
+const getAssestPriceVarianceInTimeRange = `-- name: GetAssestPriceVarianceInTimeRange :one
+SELECT CAST(COALESCE(AVG((price - mean) * (price - mean)), 0) AS REAL) AS variance FROM assest_price, (
+    SELECT AVG(price) AS mean FROM assest_price WHERE
+        assest_id = ?1 AND
+        timestamp >= ?2 AND
+        timestamp <= ?3
+) WHERE
+    assest_id = ?1 AND
+    timestamp >= ?2 AND
+    timestamp <= ?3
+`
+
+type GetAssestPriceVarianceInTimeRangeParams struct {
+	AssestID     string
+	MinTimestamp int64
+	MaxTimestamp int64
+}
+
+func (q *Queries) GetAssestPriceVarianceInTimeRange(ctx context.Context, arg GetAssestPriceVarianceInTimeRangeParams) (float64, error) {
+	row := q.db.QueryRowContext(ctx, getAssestPriceVarianceInTimeRange, arg.AssestID, arg.MinTimestamp, arg.MaxTimestamp)
+	var variance float64
+	err := row.Scan(&variance)
+	return variance, err
+}
+
+const getAssestPricesByRankInTimeRange = `-- name: GetAssestPricesByRankInTimeRange :many
+SELECT price FROM assest_price WHERE
+    assest_id = ?1 AND
+    timestamp >= ?2 AND
+    timestamp <= ?3
+ORDER BY price
+LIMIT ?4 OFFSET ?5
+`
+
+type GetAssestPricesByRankInTimeRangeParams struct {
+	AssestID     string
+	MinTimestamp int64
+	MaxTimestamp int64
+	Limit        int64
+	Offset       int64
+}
+
+func (q *Queries) GetAssestPricesByRankInTimeRange(ctx context.Context, arg GetAssestPricesByRankInTimeRangeParams) ([]int64, error) {
+	rows, err := q.db.QueryContext(ctx, getAssestPricesByRankInTimeRange,
+		arg.AssestID,
+		arg.MinTimestamp,
+		arg.MaxTimestamp,
+		arg.Limit,
+		arg.Offset,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []int64
+	for rows.Next() {
+		var price int64
+		if err := rows.Scan(&price); err != nil {
+			return nil, err
+		}
+		items = append(items, price)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getAssestSeries = `-- name: GetAssestSeries :many
+SELECT timestamp, price FROM assest_price WHERE
+    assest_id = ?1
+ORDER BY timestamp, rowid
+`
+
+type GetAssestSeriesRow struct {
+	Timestamp int64
+	Price     int64
+}
+
+func (q *Queries) GetAssestSeries(ctx context.Context, assestID string) ([]GetAssestSeriesRow, error) {
+	rows, err := q.db.QueryContext(ctx, getAssestSeries, assestID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetAssestSeriesRow
+	for rows.Next() {
+		var i GetAssestSeriesRow
+		if err := rows.Scan(&i.Timestamp, &i.Price); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const insertAssestPrice = `-- name: InsertAssestPrice :exec
 
 INSERT INTO assest_price
@@ -101,3 +292,23 @@ func (q *Queries) InsertAssestPrice(ctx context.Context, arg InsertAssestPricePa
 	)
 	return err
 }
+
+const updateAssestPriceAtTimestamp = `-- name: UpdateAssestPriceAtTimestamp :execrows
+UPDATE assest_price SET price = ?1 WHERE
+    assest_id = ?2 AND
+    timestamp = ?3
+`
+
+type UpdateAssestPriceAtTimestampParams struct {
+	Price     int64
+	AssestID  string
+	Timestamp int64
+}
+
+func (q *Queries) UpdateAssestPriceAtTimestamp(ctx context.Context, arg UpdateAssestPriceAtTimestampParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, updateAssestPriceAtTimestamp, arg.Price, arg.AssestID, arg.Timestamp)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/nivekithan/go-network/problems/means-to-end/protocol"
+)
+
+var (
+	errInvalidCommand     = errors.New("invalid command instruction")
+	errInvalidBucketCount = errors.New("invalid bucket count")
+	errRangeTooLarge      = errors.New("range too large")
+	errInvalidHandshake   = errors.New("invalid handshake")
+)
+
+// Enables error frames for the rest of the connection
+type ErrorFramesCommand struct{}
+
+func (c *ErrorFramesCommand) Type() string {
+	return protocol.InstructionErrorFrames
+}
+
+// Error frame code of err and whether a connection with error frames enabled
+// may continue after it. Errors which leave the stream of commands intact are
+// recoverable. Failures of the server and of access checks are not, since a
+// token which denies a command keeps denying it and retrying would only use
+// up the error budget.
+func classifyError(err error) (uint16, bool) {
+	switch {
+	case errors.Is(err, errInvalidCommand):
+		return protocol.ErrInvalidCommand, true
+
+	case errors.Is(err, errInvalidBucketCount), errors.Is(err, errRangeTooLarge):
+		return protocol.ErrInvalidArgument, true
+
+	case errors.Is(err, errDuplicateTimestamp):
+		return protocol.ErrDuplicateTimestamp, true
+
+	case errors.Is(err, errInsertNotAllowed), errors.Is(err, errQueryNotAllowed):
+		return protocol.ErrPermissionDenied, false
+
+	case errors.Is(err, errRowQuotaExceeded), errors.Is(err, errMemoryCeilingReached):
+		return protocol.ErrLimitExceeded, false
+
+	case errors.Is(err, errInvalidHandshake), errors.Is(err, errUnknownAsset):
+		return protocol.ErrHandshake, false
+
+	default:
+		return protocol.ErrInternal, false
+	}
+}
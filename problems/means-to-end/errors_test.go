@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/nivekithan/go-network/problems/means-to-end/protocol"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err             error
+		wantCode        uint16
+		wantRecoverable bool
+	}{
+		{err: errInvalidCommand, wantCode: protocol.ErrInvalidCommand, wantRecoverable: true},
+		{err: errInvalidBucketCount, wantCode: protocol.ErrInvalidArgument, wantRecoverable: true},
+		{err: errRangeTooLarge, wantCode: protocol.ErrInvalidArgument, wantRecoverable: true},
+		{err: errDuplicateTimestamp, wantCode: protocol.ErrDuplicateTimestamp, wantRecoverable: true},
+		{err: errInsertNotAllowed, wantCode: protocol.ErrPermissionDenied, wantRecoverable: false},
+		{err: errQueryNotAllowed, wantCode: protocol.ErrPermissionDenied, wantRecoverable: false},
+		{err: errRowQuotaExceeded, wantCode: protocol.ErrLimitExceeded, wantRecoverable: false},
+		{err: errMemoryCeilingReached, wantCode: protocol.ErrLimitExceeded, wantRecoverable: false},
+		{err: errInvalidHandshake, wantCode: protocol.ErrHandshake, wantRecoverable: false},
+		{err: errUnknownAsset, wantCode: protocol.ErrHandshake, wantRecoverable: false},
+		{err: fmt.Errorf("disk full"), wantCode: protocol.ErrInternal, wantRecoverable: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.err.Error(), func(t *testing.T) {
+			// Errors are wrapped with their details before they are reported
+			code, recoverable := classifyError(fmt.Errorf("%w: details", test.err))
+
+			if code != test.wantCode || recoverable != test.wantRecoverable {
+				t.Errorf("classifyError() = %d, %t, want %d, %t", code, recoverable, test.wantCode, test.wantRecoverable)
+			}
+		})
+	}
+}
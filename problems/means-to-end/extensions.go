@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/nivekithan/go-network/problems/means-to-end/protocol"
+)
+
+// Extension commands are only understood when the server runs with
+// -extensions. Like Q they carry a mintime and a maxtime, except N which
+// carries a bucket count and an ignored int32.
+const (
+	InstructionMin         = "L"
+	InstructionMax         = "H"
+	InstructionCount       = "C"
+	InstructionMedian      = "M"
+	InstructionStdDev      = "S"
+	InstructionBuckets     = "B"
+	InstructionBucketCount = "N"
+)
+
+var extensionInstructions = []string{
+	InstructionMin,
+	InstructionMax,
+	InstructionCount,
+	InstructionMedian,
+	InstructionStdDev,
+	InstructionBuckets,
+	InstructionBucketCount,
+}
+
+// Most buckets a B command may ask for
+const maxBuckets = 1024
+
+type TimeRange struct {
+	MinTime int32
+	MaxTime int32
+}
+
+func (r TimeRange) isEmpty() bool {
+	return r.MinTime > r.MaxTime
+}
+
+// ExtensionCommand is a query which is answered with its own response
+type ExtensionCommand interface {
+	protocol.Command
+
+	Answer(ctx context.Context, store PriceStore) ([]byte, error)
+}
+
+// Lowest price in the range as an int32, 0 when there is none
+type MinCommand struct{ TimeRange }
+
+// Highest price in the range as an int32, 0 when there is none
+type MaxCommand struct{ TimeRange }
+
+// Number of prices in the range as a uint32
+type CountCommand struct{ TimeRange }
+
+// Median price in the range as an int32. The median of an even number of
+// prices is the mean of the middle two, rounded like Q.
+type MedianCommand struct{ TimeRange }
+
+// Population standard deviation of the prices in the range, rounded to the
+// nearest integer, as a uint32
+type StdDevCommand struct{ TimeRange }
+
+// Splits the range into Buckets sub-ranges of equal length and answers with
+// the mean of each as int32s, in order. Buckets is the count of the last N
+// command of the connection, 1 before there is one
+type BucketsCommand struct {
+	TimeRange
+	Buckets int32
+}
+
+// Sets the number of buckets of the following B commands. Has no response
+type BucketCountCommand struct {
+	Buckets int32
+}
+
+func (c *MinCommand) Type() string         { return InstructionMin }
+func (c *MaxCommand) Type() string         { return InstructionMax }
+func (c *CountCommand) Type() string       { return InstructionCount }
+func (c *MedianCommand) Type() string      { return InstructionMedian }
+func (c *StdDevCommand) Type() string      { return InstructionStdDev }
+func (c *BucketsCommand) Type() string     { return InstructionBuckets }
+func (c *BucketCountCommand) Type() string { return InstructionBucketCount }
+
+func parseExtensionCommand(instruction string, first int32, second int32) (protocol.Command, error) {
+	timeRange := TimeRange{MinTime: first, MaxTime: second}
+
+	switch instruction {
+	case InstructionMin:
+		return &MinCommand{timeRange}, nil
+	case InstructionMax:
+		return &MaxCommand{timeRange}, nil
+	case InstructionCount:
+		return &CountCommand{timeRange}, nil
+	case InstructionMedian:
+		return &MedianCommand{timeRange}, nil
+	case InstructionStdDev:
+		return &StdDevCommand{timeRange}, nil
+	case InstructionBuckets:
+		return &BucketsCommand{TimeRange: timeRange, Buckets: 1}, nil
+	}
+
+	if first <= 0 || first > maxBuckets {
+		return nil, fmt.Errorf("%w %d, must be between 1 and %d", errInvalidBucketCount, first, maxBuckets)
+	}
+
+	return &BucketCountCommand{Buckets: first}, nil
+}
+
+func (c *MinCommand) Answer(ctx context.Context, store PriceStore) ([]byte, error) {
+	if c.isEmpty() {
+		return encodeResponse(int32(0)), nil
+	}
+
+	low, _, _, err := store.Extremes(ctx, c.MinTime, c.MaxTime)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeResponse(low), nil
+}
+
+func (c *MaxCommand) Answer(ctx context.Context, store PriceStore) ([]byte, error) {
+	if c.isEmpty() {
+		return encodeResponse(int32(0)), nil
+	}
+
+	_, high, _, err := store.Extremes(ctx, c.MinTime, c.MaxTime)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeResponse(high), nil
+}
+
+func (c *CountCommand) Answer(ctx context.Context, store PriceStore) ([]byte, error) {
+	if c.isEmpty() {
+		return encodeResponse(uint32(0)), nil
+	}
+
+	_, count, err := store.Summary(ctx, c.MinTime, c.MaxTime)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return encodeResponse(uint32(min(count, math.MaxUint32))), nil
+}
+
+func (c *MedianCommand) Answer(ctx context.Context, store PriceStore) ([]byte, error) {
+	if c.isEmpty() {
+		return encodeResponse(int32(0)), nil
+	}
+
+	_, _, count, err := store.Extremes(ctx, c.MinTime, c.MaxTime)
+
+	if err != nil || count == 0 {
+		return encodeResponse(int32(0)), err
+	}
+
+	// The middle price, or the middle two of an even count
+	middle, err := store.PricesByRank(ctx, c.MinTime, c.MaxTime, (count-1)/2, 2-count%2)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(middle) == 1 {
+		return encodeResponse(middle[0]), nil
+	}
+
+	sum := NewPriceSum(int64(middle[0]) + int64(middle[1]))
+
+	return encodeResponse(findMean(sum, 2)), nil
+}
+
+func (c *StdDevCommand) Answer(ctx context.Context, store PriceStore) ([]byte, error) {
+	if c.isEmpty() {
+		return encodeResponse(uint32(0)), nil
+	}
+
+	variance, err := store.Variance(ctx, c.MinTime, c.MaxTime)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Half the spread of int32 prices rounds up to 2^31, which only fits
+	// unsigned
+	return encodeResponse(uint32(math.Round(math.Sqrt(variance)))), nil
+}
+
+func (c *BucketsCommand) Answer(ctx context.Context, store PriceStore) ([]byte, error) {
+	response := []byte{}
+
+	for _, bucket := range c.split() {
+		sum, count := PriceSum{}, int64(0)
+
+		if !bucket.isEmpty() {
+			var err error
+			sum, count, err = store.Summary(ctx, bucket.MinTime, bucket.MaxTime)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		response = append(response, encodeResponse(findMean(sum, count))...)
+	}
+
+	return response, nil
+}
+
+// Sub-ranges of the command's range. When the range is shorter than the
+// number of buckets, some of them are empty
+func (c *BucketsCommand) split() []TimeRange {
+	buckets := make([]TimeRange, c.Buckets)
+
+	if c.isEmpty() {
+		for i := range buckets {
+			buckets[i] = c.TimeRange
+		}
+
+		return buckets
+	}
+
+	length := int64(c.MaxTime) - int64(c.MinTime) + 1
+
+	for i := range buckets {
+		start := int64(c.MinTime) + int64(i)*length/int64(c.Buckets)
+		end := int64(c.MinTime) + int64(i+1)*length/int64(c.Buckets) - 1
+
+		// end can be below math.MinInt32, so emptiness is checked before
+		// narrowing
+		if end < start {
+			buckets[i] = TimeRange{MinTime: math.MaxInt32, MaxTime: math.MinInt32}
+			continue
+		}
+
+		buckets[i] = TimeRange{MinTime: int32(start), MaxTime: int32(end)}
+	}
+
+	return buckets
+}
+
+func encodeResponse[T int32 | uint32](value T) []byte {
+	return binary.BigEndian.AppendUint32(nil, uint32(value))
+}
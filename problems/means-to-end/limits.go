@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errRowQuotaExceeded     = errors.New("connection row quota exceeded")
+	errMemoryCeilingReached = errors.New("server row ceiling reached")
+)
+
+// RowLimits bounds the memory used by prices. Every insert which adds a row
+// takes it from the quota of its connection and from the ceiling shared by
+// the whole server. Rows of a session are given back once it is dropped.
+// Named assets are kept for as long as the server runs, so their rows hold
+// the ceiling until it exits.
+type RowLimits struct {
+	// Rows a single connection may add. Unlimited when 0
+	perConnection int64
+
+	// Rows kept across every session and named asset. Unlimited when 0
+	ceiling int64
+
+	stored atomic.Int64
+}
+
+func NewRowLimits(perConnection int64, ceiling int64) *RowLimits {
+	return &RowLimits{perConnection: perConnection, ceiling: ceiling}
+}
+
+// Takes a row for the next insert of a connection which holds rows rows so
+// far
+func (l *RowLimits) reserve(rows int64) error {
+	if l.perConnection > 0 && rows >= l.perConnection {
+		return fmt.Errorf("%w: %d rows", errRowQuotaExceeded, l.perConnection)
+	}
+
+	// Rows are counted without a ceiling too, so that releasing them always
+	// balances
+	stored := l.stored.Add(1)
+
+	if l.ceiling > 0 && stored > l.ceiling {
+		l.stored.Add(-1)
+		return fmt.Errorf("%w: %d rows", errMemoryCeilingReached, l.ceiling)
+	}
+
+	return nil
+}
+
+// Gives back rows taken by reserve
+func (l *RowLimits) release(rows int64) {
+	l.stored.Add(-rows)
+}
+
+// Deletes the prices of a closed session once grace has passed and gives
+// its rows back
+func (l *RowLimits) dropSession(store PriceStore, connectionId string, rows int64, grace time.Duration) {
+	drop := func() {
+		if err := store.Drop(context.Background()); err != nil {
+			log.Printf("error: dropping session %s: %v", connectionId, err)
+			return
+		}
+
+		l.release(rows)
+		log.Printf("Dropped %d rows of session %s\n", rows, connectionId)
+	}
+
+	if grace <= 0 {
+		drop()
+		return
+	}
+
+	time.AfterFunc(grace, drop)
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/nivekithan/go-network/problems/means-to-end/protocol"
+)
+
+func insert(timestamp int32, price int32) []byte {
+	message := protocol.Encode(protocol.InstructionInsert, timestamp, price)
+	return message[:]
+}
+
+func query(minTime int32, maxTime int32) []byte {
+	message := protocol.Encode(protocol.InstructionQuery, minTime, maxTime)
+	return message[:]
+}
+
+func handshake(asset string, token string) []byte {
+	message := protocol.Encode(InstructionHandshake, int32(len(asset)), int32(len(token)))
+	return append(append(message[:], asset...), token...)
+}
+
+// Sends commands over a connection of its own and returns the means the
+// server answered with before the connection ended
+func runConnection(t *testing.T, limits *RowLimits, assets *Assets, config *Config, commands ...[]byte) []int32 {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		server, err := listener.Accept()
+
+		if err != nil {
+			return
+		}
+
+		handleConn(server, func(string) PriceStore { return NewMemoryStore(config.duplicates) }, assets, limits, nil, config)
+	}()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer client.Close()
+
+	// The server may close the connection before reading every command
+	go func() {
+		for _, command := range commands {
+			if _, err := client.Write(command); err != nil {
+				return
+			}
+		}
+
+		client.(*net.TCPConn).CloseWrite()
+	}()
+
+	means := []int32{}
+
+	for {
+		var mean int32
+
+		if err := binary.Read(client, binary.BigEndian, &mean); err != nil {
+			if err != io.EOF && !errors.Is(err, syscall.ECONNRESET) {
+				t.Fatal(err)
+			}
+
+			break
+		}
+
+		means = append(means, mean)
+	}
+
+	<-done
+
+	return means
+}
+
+func TestRowLimits(t *testing.T) {
+	tests := []struct {
+		name          string
+		duplicates    string
+		perConnection int64
+		commands      [][]byte
+		wantMeans     []int32
+	}{
+		{
+			name:          "within quota",
+			duplicates:    DuplicatesKeep,
+			perConnection: 2,
+			commands:      [][]byte{insert(1, 10), insert(2, 20), query(1, 2)},
+			wantMeans:     []int32{15},
+		},
+		{
+			name:          "over quota",
+			duplicates:    DuplicatesKeep,
+			perConnection: 2,
+			commands:      [][]byte{insert(1, 10), insert(2, 20), insert(3, 30), query(1, 3)},
+			wantMeans:     []int32{},
+		},
+		{
+			name:          "duplicates kept take a row",
+			duplicates:    DuplicatesKeep,
+			perConnection: 2,
+			commands:      [][]byte{insert(1, 10), insert(1, 20), insert(1, 30), query(1, 1)},
+			wantMeans:     []int32{},
+		},
+		{
+			name:          "overwrites take no row",
+			duplicates:    DuplicatesOverwrite,
+			perConnection: 2,
+			commands:      [][]byte{insert(1, 10), insert(2, 20), insert(1, 30), insert(2, 40), insert(1, 50), query(1, 2)},
+			wantMeans:     []int32{45},
+		},
+		{
+			name:          "overwrites after a query take no row",
+			duplicates:    DuplicatesOverwrite,
+			perConnection: 2,
+			commands:      [][]byte{insert(1, 10), insert(2, 20), query(1, 2), insert(1, 30), query(1, 2)},
+			wantMeans:     []int32{15, 25},
+		},
+		{
+			name:          "new timestamps take a row when overwriting",
+			duplicates:    DuplicatesOverwrite,
+			perConnection: 2,
+			commands:      [][]byte{insert(1, 10), insert(1, 20), insert(2, 30), insert(3, 40), query(1, 3)},
+			wantMeans:     []int32{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limits := NewRowLimits(test.perConnection, 0)
+			config := &Config{duplicates: test.duplicates}
+
+			means := runConnection(t, limits, nil, config, test.commands...)
+
+			if len(means) != len(test.wantMeans) {
+				t.Fatalf("means = %v, want %v", means, test.wantMeans)
+			}
+
+			for i := range means {
+				if means[i] != test.wantMeans[i] {
+					t.Fatalf("means = %v, want %v", means, test.wantMeans)
+				}
+			}
+
+			if stored := limits.stored.Load(); stored != 0 {
+				t.Errorf("%d rows stored after the session was dropped", stored)
+			}
+		})
+	}
+}
+
+func TestRowCeilingCountsNamedAssets(t *testing.T) {
+	limits := NewRowLimits(0, 3)
+	policy := &AssetPolicy{Assets: map[string][]AssetGrant{"BTC": {{Token: "secret", Insert: true, Query: true}}}}
+	config := &Config{duplicates: DuplicatesKeep, assets: policy}
+	assets := NewAssets(policy, func(string) PriceStore { return NewMemoryStore(DuplicatesKeep) })
+
+	means := runConnection(t, limits, assets, config, handshake("BTC", "secret"), insert(1, 10), insert(2, 20), query(1, 2))
+
+	if len(means) != 1 || means[0] != 15 {
+		t.Fatalf("means of the asset = %v, want [15]", means)
+	}
+
+	// Reconnecting to the asset does not give its rows back
+	means = runConnection(t, limits, assets, config, handshake("BTC", "secret"), insert(3, 30), insert(4, 40), query(1, 4))
+
+	if len(means) != 0 {
+		t.Fatalf("asset beyond the ceiling answered %v", means)
+	}
+
+	means = runConnection(t, limits, assets, config, insert(1, 10), query(1, 1))
+
+	if len(means) != 0 {
+		t.Fatalf("session beyond the ceiling answered %v", means)
+	}
+
+	if stored := limits.stored.Load(); stored != 3 {
+		t.Errorf("%d rows stored, want the 3 rows of the asset", stored)
+	}
+}
@@ -1,32 +1,165 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
 	"context"
 	"crypto/rand"
-	"database/sql"
-	"encoding/binary"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"slices"
 
-	_ "embed"
-
-	"github.com/nivekithan/go-network/problems/means-to-end/db"
-	_ "modernc.org/sqlite"
+	"github.com/nivekithan/go-network/problems/means-to-end/protocol"
 )
 
-func handleConn(conn net.Conn, queries *db.Queries) {
+// Most inserts which are applied together
+const maxInsertBatch = 1024
+
+func handleConn(conn net.Conn, newStore StoreFactory, assets *Assets, limits *RowLimits, archiver *Archiver, config *Config) {
 	defer conn.Close()
 	connectionId := rand.Text()
 
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+
+	// Created on the first command unless a handshake binds the connection
+	// to a named asset
+	var store PriceStore
+	isSession := false
+
+	// Rows added by this connection, together with the batched inserts which
+	// hold a row until they are applied
+	rows := int64(0)
+
+	defer func() {
+		if !isSession {
+			return
+		}
+
+		if archiver != nil {
+			if err := archiver.archiveSession(store, connectionId); err != nil {
+				log.Printf("error: archiving session %s: %v", connectionId, err)
+			}
+		}
+
+		limits.dropSession(store, connectionId, rows, config.sessionGrace)
+	}()
+
 	ctx := context.Background()
 
+	// Consecutive inserts which have been read but not applied yet. They are
+	// applied together before anything else reads the store
+	batch := []PricePoint{}
+
+	// Set by the E command. Responses are then tagged and errors are
+	// reported to the client before the connection is closed
+	errorFrames := false
+	errorsLeft := config.errorBudget
+
+	// Set by the N command and used by the following B commands
+	bucketCount := int32(1)
+
+	// Reports err of a command and returns whether the connection may
+	// continue
+	reportError := func(instruction string, err error) bool {
+		code, recoverable := classifyError(err)
+
+		if errorFrames {
+			protocol.WriteErrorFrame(writer, instruction, code, err.Error())
+		}
+
+		if recoverable && errorFrames && errorsLeft > 0 {
+			errorsLeft--
+			log.Printf("error: %v, connection id: %s. %d errors left\n", err, connectionId, errorsLeft)
+			return true
+		}
+
+		log.Printf("error: %v. Closing connection: %s\n", err, connectionId)
+		return false
+	}
+
+	startResponse := func() error {
+		if errorFrames {
+			return writer.WriteByte(protocol.ResponseResult)
+		}
+
+		return nil
+	}
+
+	// Applies the batched inserts and returns whether the connection may
+	// continue. An insert which fails is reported and the ones after it are
+	// still applied
+	applyInserts := func() bool {
+		pending := batch
+		batch = batch[:0]
+
+		giveBack := func(unused int64) {
+			limits.release(unused)
+			rows -= unused
+		}
+
+		for len(pending) > 0 {
+			applied, added, err := store.Insert(ctx, pending)
+
+			// Overwritten prices take no row
+			giveBack(int64(applied - added))
+
+			if err == nil {
+				return true
+			}
+
+			// Neither does the failed insert
+			giveBack(1)
+			pending = pending[applied+1:]
+
+			if !reportError(protocol.InstructionInsert, err) {
+				giveBack(int64(len(pending)))
+				return false
+			}
+		}
+
+		return true
+	}
+
+	// Applies point right away when it overwrites a price, which takes no
+	// row. Returns whether it did
+	overwrite := func(point PricePoint) (bool, error) {
+		exists, err := store.Contains(ctx, point.timestamp)
+
+		if err != nil || !exists {
+			return false, err
+		}
+
+		_, _, err = store.Insert(ctx, []PricePoint{point})
+
+		return true, err
+	}
+
+	// Inserts read before an invalid command or a closed connection are
+	// still applied, and responses already computed are still sent
+	defer func() {
+		applyInserts()
+		writer.Flush()
+	}()
+
 	for {
-		var encodedCommand [9]byte
+		var encodedCommand [protocol.MessageSize]byte
 
-		if _, err := io.ReadFull(conn, encodedCommand[:]); err != nil {
+		// Responses are only written out once every command which has already
+		// arrived has been handled
+		if reader.Buffered() < len(encodedCommand) {
+			if !applyInserts() {
+				return
+			}
+
+			if err := writer.Flush(); err != nil {
+				log.Println("error: ", err)
+				return
+			}
+		}
+
+		if _, err := io.ReadFull(reader, encodedCommand[:]); err != nil {
 			if err != io.EOF {
 				log.Printf("error reading command: %v, connection id: %s \n", err, connectionId)
 				return
@@ -36,158 +169,220 @@ func handleConn(conn net.Conn, queries *db.Queries) {
 			return
 		}
 
-		command, err := parseCommand(encodedCommand, connectionId)
+		command, err := parseCommand(encodedCommand, config)
 
 		if err != nil {
-			log.Println("error:", err)
+			if reportError(string(encodedCommand[0:1]), err) {
+				continue
+			}
+
 			return
 		}
 
-		switch parsedCommand := command.(type) {
-		case *InsertCommand:
-			if err := queries.InsertAssestPrice(ctx, db.InsertAssestPriceParams{
-				ID:        rand.Text(),
-				AssestID:  connectionId,
-				Timestamp: int64(parsedCommand.Timestamp),
-				Price:     int64(parsedCommand.Price),
-			}); err != nil {
-				log.Println("error: ", err)
+		if _, ok := command.(*ErrorFramesCommand); ok {
+			errorFrames = true
+			continue
+		}
+
+		if buckets, ok := command.(*BucketCountCommand); ok {
+			bucketCount = buckets.Buckets
+			continue
+		}
+
+		if handshake, ok := command.(*HandshakeCommand); ok {
+			if store != nil {
+				reportError(command.Type(), fmt.Errorf("%w: sent after the first command", errInvalidHandshake))
 				return
 			}
 
-		case *QueryCommand:
-			prices, err := queries.GetAssestPriceInTimeRange(ctx, db.GetAssestPriceInTimeRangeParams{
-				AssestID:     connectionId,
-				MinTimestamp: int64(parsedCommand.MinTime),
-				MaxTimestamp: int64(parsedCommand.MaxTime),
-			})
+			if err := handshake.readCredentials(reader); err != nil {
+				reportError(command.Type(), err)
+				return
+			}
 
-			if err != nil {
-				log.Println("error: ", err)
+			if store, err = assets.open(handshake.Asset, handshake.Token); err != nil {
+				reportError(command.Type(), err)
 				return
 			}
 
-			mean := findMean(prices)
+			log.Printf("Connection %s bound to asset %q\n", connectionId, handshake.Asset)
+			continue
+		}
 
-			log.Printf("mean: %d", mean)
+		if store == nil {
+			store = newStore(connectionId)
+			isSession = true
+		}
 
-			var result [4]byte
+		if buckets, ok := command.(*BucketsCommand); ok {
+			buckets.Buckets = bucketCount
+		}
 
-			_, err = binary.Encode(result[:], binary.BigEndian, int32(mean))
+		if insert, ok := command.(*protocol.InsertCommand); ok {
+			point := PricePoint{timestamp: insert.Timestamp, price: insert.Price}
+			err := limits.reserve(rows)
+
+			// At the limit, the batch is applied to give back the rows of
+			// its overwrites, and an insert which overwrites a price is
+			// applied without taking a row
+			if err != nil && config.duplicates == DuplicatesOverwrite {
+				if !applyInserts() {
+					return
+				}
+
+				if err = limits.reserve(rows); err != nil {
+					overwritten, overwriteErr := overwrite(point)
+
+					if overwriteErr != nil {
+						if reportError(command.Type(), overwriteErr) {
+							continue
+						}
+
+						return
+					}
+
+					if overwritten {
+						continue
+					}
+				}
+			}
 
 			if err != nil {
-				log.Println("error: ", err)
+				reportError(command.Type(), err)
 				return
 			}
 
-			_, err = conn.Write(result[:])
+			batch = append(batch, point)
+			rows++
 
-			if err != nil {
-				log.Println("error: ", err)
-				return
+			if len(batch) < maxInsertBatch {
+				continue
 			}
 		}
 
-	}
-}
+		if !applyInserts() {
+			return
+		}
 
-func findMean(prices []int64) int {
-	if len(prices) == 0 {
-		return 0
-	}
+		switch parsedCommand := command.(type) {
+		case *protocol.QueryCommand:
+			sum, count := PriceSum{}, int64(0)
 
-	sum := int(0)
+			// An empty period has a mean of 0 and is not looked up
+			if parsedCommand.MinTime <= parsedCommand.MaxTime {
+				sum, count, err = store.Summary(ctx, parsedCommand.MinTime, parsedCommand.MaxTime)
 
-	for _, curPrice := range prices {
-		sum += int(curPrice)
-	}
+				if err != nil {
+					if reportError(command.Type(), err) {
+						continue
+					}
 
-	mean := sum / len(prices)
+					return
+				}
+			}
 
-	return mean
-}
+			mean := findMean(sum, count)
 
-type Command interface {
-	Type() string
-}
+			if err := startResponse(); err != nil {
+				log.Println("error: ", err)
+				return
+			}
 
-type InsertCommand struct {
-	Timestamp int32
-	Price     int32
-}
+			if err := protocol.WriteMean(writer, mean); err != nil {
+				log.Println("error: ", err)
+				return
+			}
 
-func (c *InsertCommand) Type() string {
-	return "I"
-}
+		case ExtensionCommand:
+			response, err := parsedCommand.Answer(ctx, store)
 
-type QueryCommand struct {
-	MinTime int32
-	MaxTime int32
-}
+			if err != nil {
+				if reportError(command.Type(), err) {
+					continue
+				}
 
-func (c *QueryCommand) Type() string {
-	return "Q"
-}
+				return
+			}
 
-func parseCommand(command [9]byte, connectionId string) (Command, error) {
+			if err := startResponse(); err != nil {
+				log.Println("error: ", err)
+				return
+			}
 
-	commandInstruction := string(command[0:1])
+			if _, err := writer.Write(response); err != nil {
+				log.Println("error: ", err)
+				return
+			}
+		}
 
-	if commandInstruction != "I" && commandInstruction != "Q" {
-		return nil, fmt.Errorf("Invalid command instruction %s. Closing connection: %s", commandInstruction, connectionId)
 	}
+}
 
-	log.Printf("Got command: %s from connection: %s\n", commandInstruction, connectionId)
+func parseCommand(command [protocol.MessageSize]byte, config *Config) (protocol.Command, error) {
 
-	var firstInt32 int32
-	firstInt32Reader := bytes.NewReader(command[1:])
+	commandInstruction, firstInt32, secondInt32 := protocol.Decode(command)
+	isExtension := config.extensions && slices.Contains(extensionInstructions, commandInstruction)
+	isHandshake := config.assets != nil && commandInstruction == InstructionHandshake
 
-	if err := binary.Read(firstInt32Reader, binary.BigEndian, &firstInt32); err != nil {
-		return nil, fmt.Errorf("Error while reading firstInt32: %s", err)
+	if config.errorFrames && commandInstruction == protocol.InstructionErrorFrames {
+		return &ErrorFramesCommand{}, nil
 	}
 
-	log.Printf("firstInt32: %d\n", firstInt32)
-
-	var secondInt32 int32
-
-	if err := binary.Read(firstInt32Reader, binary.BigEndian, &secondInt32); err != nil {
-		return nil, fmt.Errorf("Error while reading secondInt32: %s", err)
+	if commandInstruction != protocol.InstructionInsert && commandInstruction != protocol.InstructionQuery && !isExtension && !isHandshake {
+		return nil, fmt.Errorf("%w %q", errInvalidCommand, commandInstruction)
 	}
 
-	log.Printf("secondInt32: %d\n", secondInt32)
-
-	if commandInstruction == "I" {
-		return &InsertCommand{
+	if commandInstruction == protocol.InstructionInsert {
+		return &protocol.InsertCommand{
 			Timestamp: firstInt32,
 			Price:     secondInt32,
 		}, nil
 	}
 
-	return &QueryCommand{
+	if isHandshake {
+		return &HandshakeCommand{nameLength: firstInt32, tokenLength: secondInt32}, nil
+	}
+
+	if isExtension {
+		return parseExtensionCommand(commandInstruction, firstInt32, secondInt32)
+	}
+
+	return &protocol.QueryCommand{
 		MinTime: firstInt32,
 		MaxTime: secondInt32,
 	}, nil
 }
 
-//go:embed sql/schema.sql
-var ddl string
+func run(config *Config) error {
 
-func run() error {
-
-	ctx := context.Background()
-	sqliteDb, err := sql.Open("sqlite", "file::memory:?cache=shared")
+	newStore, err := newStoreFactory(context.Background(), config.store, config.duplicates)
 
 	if err != nil {
 		return err
 	}
 
-	log.Println(ddl)
+	var assets *Assets
 
-	if _, err := sqliteDb.ExecContext(ctx, ddl); err != nil {
-		return err
+	if config.assetsPath != "" {
+		policy, err := LoadAssetPolicy(config.assetsPath)
+
+		if err != nil {
+			return err
+		}
+
+		config.assets = policy
+		assets = NewAssets(policy, newStore)
 	}
 
-	queries := db.New(sqliteDb)
+	limits := NewRowLimits(config.maxConnectionRows, config.maxRows)
+
+	var archiver *Archiver
+
+	if config.archiveDir != "" {
+		if archiver, err = NewArchiver(config.archiveDir, config.archiveFormat); err != nil {
+			return err
+		}
+	}
 
 	listener, err := net.Listen("tcp", ":8000")
 
@@ -204,13 +399,13 @@ func run() error {
 			return err
 		}
 
-		go handleConn(conn, queries)
+		go handleConn(conn, newStore, assets, limits, archiver, config)
 	}
 
 }
 
 func main() {
-	if err := run(); err != nil {
+	if err := run(parseFlags()); err != nil {
 		log.Fatal(err)
 	}
 }
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"math"
+	"math/big"
+)
+
+// PriceSum is a sum of prices which is kept in an int64 until it overflows and
+// in a big.Int afterwards
+type PriceSum struct {
+	small int64
+
+	// Non nil once the sum does not fit in an int64
+	big *big.Int
+}
+
+func NewPriceSum(sum int64) PriceSum {
+	return PriceSum{small: sum}
+}
+
+// Sum of prices summed separately as their high bits, price >> 16, and their
+// low 16 bits, price & 0xFFFF. SQLite fails SUM instead of wrapping around
+// once it leaves the int64 range, which neither part can do for int32 prices
+// within the size of a database
+func NewSplitPriceSum(high int64, low int64) PriceSum {
+	sum := PriceSum{}
+
+	if high >= math.MinInt64>>16 && high <= math.MaxInt64>>16 {
+		sum.Add(high << 16)
+	} else {
+		sum.big = new(big.Int).Lsh(big.NewInt(high), 16)
+	}
+
+	sum.Add(low)
+
+	return sum
+}
+
+func (s *PriceSum) Add(price int64) {
+	if s.big != nil {
+		s.big.Add(s.big, big.NewInt(price))
+		return
+	}
+
+	sum := s.small + price
+
+	// Adding two numbers of the same sign overflowed if the sign changed
+	if (price > 0 && sum < s.small) || (price < 0 && sum > s.small) {
+		s.big = big.NewInt(s.small)
+		s.big.Add(s.big, big.NewInt(price))
+		return
+	}
+
+	s.small = sum
+}
+
+// Mean of count prices summing to sum, rounded to the nearest integer. Halves
+// are rounded away from zero, so the mean of 1 and 2 is 2 and the mean of -1
+// and -2 is -2. The mean of no prices is 0.
+//
+// The mean of int32 prices always fits in an int32.
+func findMean(sum PriceSum, count int64) int32 {
+	if count == 0 {
+		return 0
+	}
+
+	if sum.big != nil {
+		return findBigMean(sum.big, count)
+	}
+
+	mean := sum.small / count
+	remainder := sum.small % count
+
+	// |remainder| >= count / 2 without overflowing
+	if remainder < 0 && -remainder >= count+remainder {
+		mean--
+	} else if remainder > 0 && remainder >= count-remainder {
+		mean++
+	}
+
+	return int32(mean)
+}
+
+func findBigMean(sum *big.Int, count int64) int32 {
+	divisor := big.NewInt(count)
+
+	// QuoRem truncates towards zero like the int64 division
+	mean, remainder := new(big.Int).QuoRem(sum, divisor, new(big.Int))
+
+	doubled := new(big.Int).Abs(remainder)
+	doubled.Lsh(doubled, 1)
+
+	if doubled.Cmp(divisor) >= 0 {
+		mean.Add(mean, big.NewInt(int64(sum.Sign())))
+	}
+
+	return int32(mean.Int64())
+}
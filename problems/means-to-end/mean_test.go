@@ -0,0 +1,125 @@
+package main
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestFindMean(t *testing.T) {
+	tests := []struct {
+		name   string
+		prices []int64
+		want   int32
+	}{
+		{name: "no prices", prices: nil, want: 0},
+		{name: "single price", prices: []int64{-5}, want: -5},
+		{name: "exact", prices: []int64{1, 2, 3}, want: 2},
+		{name: "rounds down", prices: []int64{1, 1, 2}, want: 1},
+		{name: "rounds up", prices: []int64{1, 2, 2}, want: 2},
+		{name: "half rounds away from zero", prices: []int64{1, 2}, want: 2},
+		{name: "negative half rounds away from zero", prices: []int64{-1, -2}, want: -2},
+		{name: "negative rounds towards zero", prices: []int64{-1, -1, -2}, want: -1},
+		{name: "negative rounds away from zero", prices: []int64{-1, -2, -2}, want: -2},
+		{name: "mixed signs", prices: []int64{3, -2}, want: 1},
+		{name: "mixed signs negative half", prices: []int64{2, -3}, want: -1},
+		{name: "largest prices", prices: []int64{math.MaxInt32, math.MaxInt32, math.MaxInt32}, want: math.MaxInt32},
+		{name: "smallest prices", prices: []int64{math.MinInt32, math.MinInt32, math.MinInt32}, want: math.MinInt32},
+		{name: "extremes", prices: []int64{math.MinInt32, math.MaxInt32}, want: -1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sum := PriceSum{}
+
+			for _, price := range test.prices {
+				sum.Add(price)
+			}
+
+			if got := findMean(sum, int64(len(test.prices))); got != test.want {
+				t.Errorf("findMean(%v) = %d, want %d", test.prices, got, test.want)
+			}
+		})
+	}
+}
+
+// Sums this large need more prices than fit in memory, so they start from an
+// int64 which is close to overflowing
+func TestFindMeanOverflowingSum(t *testing.T) {
+	tests := []struct {
+		name  string
+		start int64
+		added []int64
+		count int64
+		want  int32
+	}{
+		{name: "fits", start: math.MaxInt64 - 1, added: []int64{1}, count: 1 << 33, want: 1 << 30},
+		{name: "positive", start: math.MaxInt64, added: []int64{math.MaxInt32}, count: 1 << 33, want: 1 << 30},
+		{name: "negative", start: math.MinInt64, added: []int64{math.MinInt32}, count: 1 << 33, want: -(1 << 30)},
+		{name: "positive half", start: math.MaxInt64, added: []int64{1, 1 << 32}, count: 1 << 33, want: 1<<30 + 1},
+		{name: "negative half", start: math.MinInt64, added: []int64{-(1 << 32)}, count: 1 << 33, want: -(1<<30 + 1)},
+		{name: "back into int64", start: math.MaxInt64, added: []int64{1, -2}, count: 1 << 33, want: 1 << 30},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sum := NewPriceSum(test.start)
+			want := big.NewInt(test.start)
+
+			for _, price := range test.added {
+				sum.Add(price)
+				want.Add(want, big.NewInt(price))
+			}
+
+			if got := sum.value(); got.Cmp(want) != 0 {
+				t.Errorf("sum = %v, want %v", got, want)
+			}
+
+			if got := findMean(sum, test.count); got != test.want {
+				t.Errorf("findMean(%v, %d) = %d, want %d", want, test.count, got, test.want)
+			}
+		})
+	}
+}
+
+// Value of the sum whichever representation it is in
+func (s PriceSum) value() *big.Int {
+	if s.big != nil {
+		return s.big
+	}
+
+	return big.NewInt(s.small)
+}
+
+func TestSplitPriceSum(t *testing.T) {
+	tests := []struct {
+		name   string
+		prices []int64
+	}{
+		{name: "no prices", prices: nil},
+		{name: "mixed signs", prices: []int64{70000, -70000, -1, 65535, 65536}},
+		{name: "largest prices", prices: []int64{math.MaxInt32, math.MaxInt32, math.MaxInt32}},
+		{name: "smallest prices", prices: []int64{math.MinInt32, math.MinInt32, math.MinInt32}},
+		{name: "beyond int64", prices: []int64{math.MaxInt64, math.MaxInt64, 2}},
+		{name: "below int64", prices: []int64{math.MinInt64, math.MinInt64, -2}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			high := int64(0)
+			low := int64(0)
+			want := big.NewInt(0)
+
+			// As the summary query of the SQLite store sums them
+			for _, price := range test.prices {
+				high += price >> 16
+				low += price & 0xFFFF
+				want.Add(want, big.NewInt(price))
+			}
+
+			if got := NewSplitPriceSum(high, low).value(); got.Cmp(want) != 0 {
+				t.Errorf("sum = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+// Package meansclient implements the client side of the means to an end
+// protocol.
+//
+// Inserts are buffered and only sent once the client is flushed or a mean is
+// asked for. To pipeline queries, send several with SendMean, Flush once and
+// read their means with ReadMean in the order they were sent.
+//
+// Servers running with -error-frames report errors to clients which call
+// EnableErrorFrames, as *protocol.ErrorFrame errors from Mean and ReadMean.
+package meansclient
+
+import (
+	"bufio"
+	"errors"
+	"net"
+
+	"github.com/nivekithan/go-network/problems/means-to-end/protocol"
+)
+
+type Client struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	writer *bufio.Writer
+
+	// Queries sent whose mean has not been read yet
+	pending int
+
+	errorFrames bool
+}
+
+func Dial(addr string) (*Client, error) {
+	conn, err := net.Dial("tcp", addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return New(conn), nil
+}
+
+// New wraps an established connection to the server
+func New(conn net.Conn) *Client {
+	return &Client{conn: conn, reader: bufio.NewReader(conn), writer: bufio.NewWriter(conn)}
+}
+
+func (c *Client) Insert(timestamp int32, price int32) error {
+	message := (&protocol.InsertCommand{Timestamp: timestamp, Price: price}).Encode()
+
+	_, err := c.writer.Write(message[:])
+
+	return err
+}
+
+// Mean of the prices with minTime <= timestamp <= maxTime. Queries sent with
+// SendMean must have been read before.
+func (c *Client) Mean(minTime int32, maxTime int32) (int32, error) {
+	if err := c.SendMean(minTime, maxTime); err != nil {
+		return 0, err
+	}
+
+	if err := c.Flush(); err != nil {
+		return 0, err
+	}
+
+	return c.ReadMean()
+}
+
+// Queues a query without waiting for its mean
+func (c *Client) SendMean(minTime int32, maxTime int32) error {
+	message := (&protocol.QueryCommand{MinTime: minTime, MaxTime: maxTime}).Encode()
+
+	if _, err := c.writer.Write(message[:]); err != nil {
+		return err
+	}
+
+	c.pending++
+
+	return nil
+}
+
+// Asks the server to report errors instead of only closing the connection.
+// Must be sent before any query whose mean has not been read.
+func (c *Client) EnableErrorFrames() error {
+	message := protocol.Encode(protocol.InstructionErrorFrames, 0, 0)
+
+	if _, err := c.writer.Write(message[:]); err != nil {
+		return err
+	}
+
+	c.errorFrames = true
+
+	return nil
+}
+
+// Reads the mean of the oldest query sent with SendMean. An error frame of an
+// insert does not answer the query, so its mean is read by the next call.
+func (c *Client) ReadMean() (int32, error) {
+	if c.errorFrames {
+		if err := protocol.ReadResponseTag(c.reader); err != nil {
+			var frame *protocol.ErrorFrame
+
+			if errors.As(err, &frame) && frame.Instruction == protocol.InstructionQuery {
+				c.pending--
+			}
+
+			return 0, err
+		}
+	}
+
+	mean, err := protocol.ReadMean(c.reader)
+
+	if err != nil {
+		return 0, err
+	}
+
+	c.pending--
+
+	return mean, nil
+}
+
+// Number of queries sent whose mean has not been read yet
+func (c *Client) Pending() int {
+	return c.pending
+}
+
+// Sends every buffered insert and query
+func (c *Client) Flush() error {
+	return c.writer.Flush()
+}
+
+// Flushes the buffered messages and closes the connection
+func (c *Client) Close() error {
+	flushErr := c.Flush()
+
+	if err := c.conn.Close(); err != nil {
+		return err
+	}
+
+	return flushErr
+}
@@ -0,0 +1,214 @@
+package meansclient
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/nivekithan/go-network/problems/means-to-end/protocol"
+)
+
+// Messages of the protocol's example session
+var (
+	insert1 = []byte{0x49, 0x00, 0x00, 0x30, 0x39, 0x00, 0x00, 0x00, 0x65}
+	insert2 = []byte{0x49, 0x00, 0x00, 0x30, 0x3a, 0x00, 0x00, 0x00, 0x66}
+	query   = []byte{0x51, 0x00, 0x00, 0x30, 0x00, 0x00, 0x00, 0x40, 0x00}
+
+	enableErrorFrames = []byte{0x45, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+)
+
+// Connects a client to serve, which runs as the server side of an in process
+// pipe. The pipe is closed and serve waited for once the test ends
+func pipe(t *testing.T, serve func(server net.Conn)) *Client {
+	t.Helper()
+
+	conn, server := net.Pipe()
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		defer server.Close()
+
+		serve(server)
+	}()
+
+	t.Cleanup(func() {
+		conn.Close()
+		<-done
+	})
+
+	return New(conn)
+}
+
+// Reads the messages sent by the client and reports whether they are want
+func expect(t *testing.T, server net.Conn, want ...[]byte) bool {
+	wantBytes := bytes.Join(want, nil)
+	got := make([]byte, len(wantBytes))
+
+	if _, err := io.ReadFull(server, got); err != nil {
+		t.Errorf("reading %d bytes: %v", len(wantBytes), err)
+		return false
+	}
+
+	if !bytes.Equal(got, wantBytes) {
+		t.Errorf("client sent % x, want % x", got, wantBytes)
+		return false
+	}
+
+	return true
+}
+
+func respond(t *testing.T, server net.Conn, response func(w io.Writer) error) {
+	if err := response(server); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMean(t *testing.T) {
+	client := pipe(t, func(server net.Conn) {
+		if !expect(t, server, insert1, insert2, query) {
+			return
+		}
+
+		respond(t, server, func(w io.Writer) error { return protocol.WriteMean(w, 101) })
+	})
+
+	if err := client.Insert(12345, 101); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Insert(12346, 102); err != nil {
+		t.Fatal(err)
+	}
+
+	mean, err := client.Mean(12288, 16384)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mean != 101 {
+		t.Errorf("Mean() = %d, want 101", mean)
+	}
+}
+
+func TestPipelinedMeans(t *testing.T) {
+	client := pipe(t, func(server net.Conn) {
+		if !expect(t, server, query, query) {
+			return
+		}
+
+		respond(t, server, func(w io.Writer) error { return protocol.WriteMean(w, -1) })
+		respond(t, server, func(w io.Writer) error { return protocol.WriteMean(w, 2) })
+	})
+
+	for range 2 {
+		if err := client.SendMean(12288, 16384); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if pending := client.Pending(); pending != 2 {
+		t.Fatalf("Pending() = %d, want 2", pending)
+	}
+
+	if err := client.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, want := range []int32{-1, 2} {
+		mean, err := client.ReadMean()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if mean != want {
+			t.Errorf("ReadMean() = %d, want %d", mean, want)
+		}
+
+		if pending := client.Pending(); pending != 1-i {
+			t.Errorf("Pending() = %d, want %d", pending, 1-i)
+		}
+	}
+}
+
+func TestErrorFrames(t *testing.T) {
+	client := pipe(t, func(server net.Conn) {
+		if !expect(t, server, enableErrorFrames, insert1, query) {
+			return
+		}
+
+		respond(t, server, func(w io.Writer) error {
+			return protocol.WriteErrorFrame(w, protocol.InstructionInsert, protocol.ErrDuplicateTimestamp, "duplicate")
+		})
+		respond(t, server, func(w io.Writer) error { _, err := w.Write([]byte{protocol.ResponseResult}); return err })
+		respond(t, server, func(w io.Writer) error { return protocol.WriteMean(w, 101) })
+
+		if !expect(t, server, query) {
+			return
+		}
+
+		respond(t, server, func(w io.Writer) error {
+			return protocol.WriteErrorFrame(w, protocol.InstructionQuery, protocol.ErrInvalidArgument, "range")
+		})
+	})
+
+	if err := client.EnableErrorFrames(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Insert(12345, 101); err != nil {
+		t.Fatal(err)
+	}
+
+	// The error of the insert comes first and leaves the query pending
+	_, err := client.Mean(12288, 16384)
+
+	var frame *protocol.ErrorFrame
+
+	if !errors.As(err, &frame) || frame.Instruction != protocol.InstructionInsert || frame.Code != protocol.ErrDuplicateTimestamp || frame.Message != "duplicate" {
+		t.Fatalf("Mean() error = %v, want the error frame of the insert", err)
+	}
+
+	if pending := client.Pending(); pending != 1 {
+		t.Fatalf("Pending() = %d after the error of an insert, want 1", pending)
+	}
+
+	mean, err := client.ReadMean()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mean != 101 {
+		t.Errorf("ReadMean() = %d, want 101", mean)
+	}
+
+	// The error of a query answers it
+	_, err = client.Mean(12288, 16384)
+
+	if !errors.As(err, &frame) || frame.Instruction != protocol.InstructionQuery || frame.Code != protocol.ErrInvalidArgument {
+		t.Fatalf("Mean() error = %v, want the error frame of the query", err)
+	}
+
+	if pending := client.Pending(); pending != 0 {
+		t.Errorf("Pending() = %d after the error of a query, want 0", pending)
+	}
+}
+
+func TestCloseFlushesInserts(t *testing.T) {
+	client := pipe(t, func(server net.Conn) {
+		expect(t, server, insert1)
+	})
+
+	if err := client.Insert(12345, 101); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
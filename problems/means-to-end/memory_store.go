@@ -0,0 +1,335 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+)
+
+type PricePoint struct {
+	timestamp int32
+	price     int32
+}
+
+// Most prices PricesByRank sorts, which bounds the median of an M command.
+// SQLite sorts the range itself and only returns the ranked prices, so it has
+// no limit
+const maxRankedPrices = 1 << 20
+
+// MemoryStore keeps prices in sorted runs whose sizes at least halve from the
+// oldest run to the newest, so there are at most log2(n) + 1 of them. Every
+// run sums its prices with a Fenwick tree. A new price becomes a run of its
+// own, which is merged with the runs before it while they are not larger.
+// Every price is merged O(log n) times, so inserts cost O(log n) amortised
+// in any timestamp order, overwrites O(log² n) and range sums O(log² n).
+type MemoryStore struct {
+	duplicates string
+
+	// Oldest first. Every run is older than the runs after it
+	runs []*priceRun
+
+	// Every inserted timestamp. Only tracked when duplicates are not kept
+	timestamps map[int32]struct{}
+}
+
+// priceRun is a run of points sorted by timestamp, with prices of the same
+// timestamp in the order they were inserted
+type priceRun struct {
+	points []PricePoint
+
+	// Fenwick tree of the prices. tree[i] is the sum of the prices of
+	// points[i-i&-i : i]. Sums wrap around on overflow
+	tree []int64
+}
+
+func NewMemoryStore(duplicates string) *MemoryStore {
+	return &MemoryStore{
+		duplicates: duplicates,
+		timestamps: map[int32]struct{}{},
+	}
+}
+
+func (s *MemoryStore) Insert(ctx context.Context, points []PricePoint) (int, int, error) {
+	added := 0
+
+	for i, point := range points {
+		isNew, err := s.insert(point.timestamp, point.price)
+
+		if err != nil {
+			return i, added, err
+		}
+
+		if isNew {
+			added++
+		}
+	}
+
+	return len(points), added, nil
+}
+
+// Returns whether the price was added rather than overwriting one
+func (s *MemoryStore) insert(timestamp int32, price int32) (bool, error) {
+	if s.duplicates != DuplicatesKeep {
+		if _, ok := s.timestamps[timestamp]; ok {
+			if s.duplicates == DuplicatesReject {
+				return false, fmt.Errorf("%w: %d", errDuplicateTimestamp, timestamp)
+			}
+
+			s.overwrite(timestamp, price)
+			return false, nil
+		}
+
+		s.timestamps[timestamp] = struct{}{}
+	}
+
+	s.runs = append(s.runs, newPriceRun([]PricePoint{{timestamp: timestamp, price: price}}))
+
+	for len(s.runs) > 1 && len(s.runs[len(s.runs)-2].points) <= len(s.runs[len(s.runs)-1].points) {
+		older, newer := s.runs[len(s.runs)-2], s.runs[len(s.runs)-1]
+		s.runs = append(s.runs[:len(s.runs)-2], mergeRuns(older, newer))
+	}
+
+	return true, nil
+}
+
+func (s *MemoryStore) Contains(ctx context.Context, timestamp int32) (bool, error) {
+	for _, run := range s.runs {
+		if low, high := run.bounds(timestamp, timestamp); low < high {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// Prices are only summed one by one when there are too many of them for the
+// difference of two wrapped sums to be exact
+const maxPrefixSumCount = 1 << 32
+
+func (s *MemoryStore) Summary(ctx context.Context, minTime int32, maxTime int32) (PriceSum, int64, error) {
+	wrapped := int64(0)
+	count := int64(0)
+
+	for _, run := range s.runs {
+		low, high := run.bounds(minTime, maxTime)
+
+		if low >= high {
+			continue
+		}
+
+		wrapped += run.prefixSum(high) - run.prefixSum(low)
+		count += int64(high - low)
+	}
+
+	// The wrapped sum is exact as long as the sum fits in an int64, which
+	// holds for fewer than 2^32 int32 prices
+	if count < maxPrefixSumCount {
+		return NewPriceSum(wrapped), count, nil
+	}
+
+	sum := PriceSum{}
+
+	for _, points := range s.spans(minTime, maxTime) {
+		for _, point := range points {
+			sum.Add(int64(point.price))
+		}
+	}
+
+	return sum, count, nil
+}
+
+func (s *MemoryStore) Extremes(ctx context.Context, minTime int32, maxTime int32) (int32, int32, int64, error) {
+	low, high := int32(math.MaxInt32), int32(math.MinInt32)
+	count := int64(0)
+
+	for _, points := range s.spans(minTime, maxTime) {
+		for _, point := range points {
+			low = min(low, point.price)
+			high = max(high, point.price)
+		}
+
+		count += int64(len(points))
+	}
+
+	if count == 0 {
+		return 0, 0, 0, nil
+	}
+
+	return low, high, count, nil
+}
+
+// Computed in two passes over the range, the first of which finds the mean
+func (s *MemoryStore) Variance(ctx context.Context, minTime int32, maxTime int32) (float64, error) {
+	spans := s.spans(minTime, maxTime)
+	mean := 0.0
+	count := 0
+
+	for _, points := range spans {
+		for _, point := range points {
+			mean += float64(point.price)
+		}
+
+		count += len(points)
+	}
+
+	if count == 0 {
+		return 0, nil
+	}
+
+	mean /= float64(count)
+
+	variance := 0.0
+
+	for _, points := range spans {
+		for _, point := range points {
+			variance += (float64(point.price) - mean) * (float64(point.price) - mean)
+		}
+	}
+
+	return variance / float64(count), nil
+}
+
+// The range is copied and sorted, so ranges of more than maxRankedPrices are
+// rejected rather than held in memory
+func (s *MemoryStore) PricesByRank(ctx context.Context, minTime int32, maxTime int32, offset int64, limit int64) ([]int32, error) {
+	spans := s.spans(minTime, maxTime)
+	count := 0
+
+	for _, points := range spans {
+		count += len(points)
+	}
+
+	if count > maxRankedPrices {
+		return nil, fmt.Errorf("%w: ranking %d prices, at most %d", errRangeTooLarge, count, maxRankedPrices)
+	}
+
+	prices := make([]int32, 0, count)
+
+	for _, points := range spans {
+		for _, point := range points {
+			prices = append(prices, point.price)
+		}
+	}
+
+	slices.Sort(prices)
+
+	start := min(offset, int64(len(prices)))
+	end := min(start+limit, int64(len(prices)))
+
+	return prices[start:end], nil
+}
+
+// Older runs come first, so the stable sort keeps prices of the same
+// timestamp in the order they were inserted
+func (s *MemoryStore) Series(ctx context.Context) ([]PricePoint, error) {
+	series := []PricePoint{}
+
+	for _, run := range s.runs {
+		series = append(series, run.points...)
+	}
+
+	slices.SortStableFunc(series, func(a, b PricePoint) int {
+		return cmp.Compare(a.timestamp, b.timestamp)
+	})
+
+	return series, nil
+}
+
+func (s *MemoryStore) Drop(ctx context.Context) error {
+	s.runs = nil
+	s.timestamps = map[int32]struct{}{}
+
+	return nil
+}
+
+// Points of every run with minTime <= timestamp <= maxTime
+func (s *MemoryStore) spans(minTime int32, maxTime int32) [][]PricePoint {
+	spans := [][]PricePoint{}
+
+	for _, run := range s.runs {
+		if low, high := run.bounds(minTime, maxTime); low < high {
+			spans = append(spans, run.points[low:high])
+		}
+	}
+
+	return spans
+}
+
+// Only called when duplicates are not kept, so a single point has the
+// timestamp
+func (s *MemoryStore) overwrite(timestamp int32, price int32) {
+	for _, run := range s.runs {
+		if low, high := run.bounds(timestamp, timestamp); low < high {
+			run.add(low, int64(price)-int64(run.points[low].price))
+			run.points[low].price = price
+			return
+		}
+	}
+}
+
+func newPriceRun(points []PricePoint) *priceRun {
+	run := &priceRun{points: points, tree: make([]int64, len(points)+1)}
+
+	// Every node adds itself to its parent, which builds the tree in O(n)
+	for i, point := range points {
+		run.tree[i+1] += int64(point.price)
+
+		if parent := i + 1 + (i+1)&-(i+1); parent <= len(points) {
+			run.tree[parent] += run.tree[i+1]
+		}
+	}
+
+	return run
+}
+
+// Merges two runs into one. Points of older come first among points of the
+// same timestamp
+func mergeRuns(older *priceRun, newer *priceRun) *priceRun {
+	points := make([]PricePoint, 0, len(older.points)+len(newer.points))
+	i, j := 0, 0
+
+	for i < len(older.points) && j < len(newer.points) {
+		if older.points[i].timestamp <= newer.points[j].timestamp {
+			points = append(points, older.points[i])
+			i++
+		} else {
+			points = append(points, newer.points[j])
+			j++
+		}
+	}
+
+	points = append(points, older.points[i:]...)
+	points = append(points, newer.points[j:]...)
+
+	return newPriceRun(points)
+}
+
+// Positions of the first point with minTime <= timestamp and of the first
+// with timestamp > maxTime
+func (r *priceRun) bounds(minTime int32, maxTime int32) (int, int) {
+	low := sort.Search(len(r.points), func(i int) bool { return r.points[i].timestamp >= minTime })
+	high := sort.Search(len(r.points), func(i int) bool { return r.points[i].timestamp > maxTime })
+
+	return low, high
+}
+
+// Sum of the prices of points[:end]
+func (r *priceRun) prefixSum(end int) int64 {
+	sum := int64(0)
+
+	for i := end; i > 0; i -= i & -i {
+		sum += r.tree[i]
+	}
+
+	return sum
+}
+
+// Adds delta to the price of points[position] in the tree
+func (r *priceRun) add(position int, delta int64) {
+	for i := position + 1; i < len(r.tree); i += i & -i {
+		r.tree[i] += delta
+	}
+}
@@ -0,0 +1,176 @@
+// Package protocol encodes the messages of the means to an end protocol,
+// shared by the server and meansclient.
+//
+// Every command is 9 bytes: a single instruction byte followed by two
+// big-endian int32s. A query is answered with a big-endian int32 mean.
+package protocol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+const MessageSize = 9
+
+const (
+	InstructionInsert = "I"
+	InstructionQuery  = "Q"
+)
+
+type Command interface {
+	Type() string
+}
+
+type InsertCommand struct {
+	Timestamp int32
+	Price     int32
+}
+
+func (c *InsertCommand) Type() string {
+	return InstructionInsert
+}
+
+func (c *InsertCommand) Encode() [MessageSize]byte {
+	return Encode(InstructionInsert, c.Timestamp, c.Price)
+}
+
+type QueryCommand struct {
+	MinTime int32
+	MaxTime int32
+}
+
+func (c *QueryCommand) Type() string {
+	return InstructionQuery
+}
+
+func (c *QueryCommand) Encode() [MessageSize]byte {
+	return Encode(InstructionQuery, c.MinTime, c.MaxTime)
+}
+
+func Encode(instruction string, first int32, second int32) [MessageSize]byte {
+	var message [MessageSize]byte
+
+	message[0] = instruction[0]
+	binary.BigEndian.PutUint32(message[1:5], uint32(first))
+	binary.BigEndian.PutUint32(message[5:9], uint32(second))
+
+	return message
+}
+
+// Splits a message into its instruction and its two int32s
+func Decode(message [MessageSize]byte) (instruction string, first int32, second int32) {
+	instruction = string(message[0:1])
+	first = int32(binary.BigEndian.Uint32(message[1:5]))
+	second = int32(binary.BigEndian.Uint32(message[5:9]))
+
+	return instruction, first, second
+}
+
+func WriteMean(w io.Writer, mean int32) error {
+	return binary.Write(w, binary.BigEndian, mean)
+}
+
+func ReadMean(r io.Reader) (int32, error) {
+	var mean int32
+
+	err := binary.Read(r, binary.BigEndian, &mean)
+
+	return mean, err
+}
+
+// Sent by a client to receive error frames. Once enabled, every response
+// starts with a tag byte: ResponseResult followed by the usual response, or
+// ResponseError followed by the instruction byte of the failed command, a
+// uint16 error code, the uint16 length of the message and the message. Inserts
+// have no response, so their error frames come before the response of the
+// next query.
+const InstructionErrorFrames = "E"
+
+const (
+	ResponseResult byte = 'R'
+	ResponseError  byte = 'E'
+)
+
+// Codes of error frames
+const (
+	// The instruction byte is unknown or not enabled
+	ErrInvalidCommand uint16 = 1
+	// A field of the command is out of range
+	ErrInvalidArgument uint16 = 2
+	// A price already exists at the timestamp and duplicates are rejected
+	ErrDuplicateTimestamp uint16 = 3
+	// The asset token does not allow the command
+	ErrPermissionDenied uint16 = 4
+	// The connection or the server holds too many prices
+	ErrLimitExceeded uint16 = 5
+	// The handshake is invalid or names an unknown asset or token
+	ErrHandshake uint16 = 6
+	// The server failed to handle the command
+	ErrInternal uint16 = 7
+)
+
+// ErrorFrame is an error reported by the server
+type ErrorFrame struct {
+	// Instruction of the command which failed
+	Instruction string
+	Code        uint16
+	Message     string
+}
+
+func (e *ErrorFrame) Error() string {
+	return fmt.Sprintf("server error %d on %q: %s", e.Code, e.Instruction, e.Message)
+}
+
+// Longest message an error frame carries
+const maxErrorMessage = math.MaxUint16
+
+func WriteErrorFrame(w io.Writer, instruction string, code uint16, message string) error {
+	message = message[:min(len(message), maxErrorMessage)]
+
+	frame := []byte{ResponseError, instruction[0]}
+	frame = binary.BigEndian.AppendUint16(frame, code)
+	frame = binary.BigEndian.AppendUint16(frame, uint16(len(message)))
+	frame = append(frame, message...)
+
+	_, err := w.Write(frame)
+
+	return err
+}
+
+// Reads the tag of a response. An error frame is returned as an *ErrorFrame
+func ReadResponseTag(r io.Reader) error {
+	var tag [1]byte
+
+	if _, err := io.ReadFull(r, tag[:]); err != nil {
+		return err
+	}
+
+	switch tag[0] {
+	case ResponseResult:
+		return nil
+
+	case ResponseError:
+		var header [5]byte
+
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return err
+		}
+
+		message := make([]byte, binary.BigEndian.Uint16(header[3:5]))
+
+		if _, err := io.ReadFull(r, message); err != nil {
+			return err
+		}
+
+		return &ErrorFrame{
+			Instruction: string(header[0:1]),
+			Code:        binary.BigEndian.Uint16(header[1:3]),
+			Message:     string(message),
+		}
+
+	default:
+		return fmt.Errorf("unknown response tag %q", tag[0])
+	}
+}
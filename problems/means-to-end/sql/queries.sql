@@ -14,3 +14,56 @@ SELECT price FROM assest_price WHERE
     assest_id = @assest_id AND
     timestamp >= @minTimestamp AND
     timestamp <= @maxTimestamp;
+
+-- name: GetAssestPriceSummaryInTimeRange :one
+SELECT
+    CAST(COALESCE(SUM(price >> 16), 0) AS INTEGER) AS high_total,
+    CAST(COALESCE(SUM(price & 65535), 0) AS INTEGER) AS low_total,
+    COUNT(*) AS count
+FROM assest_price WHERE
+    assest_id = @assest_id AND
+    timestamp >= @minTimestamp AND
+    timestamp <= @maxTimestamp;
+
+-- name: CountAssestPriceAtTimestamp :one
+SELECT COUNT(*) FROM assest_price WHERE
+    assest_id = @assest_id AND
+    timestamp = @timestamp;
+
+-- name: UpdateAssestPriceAtTimestamp :execrows
+UPDATE assest_price SET price = @price WHERE
+    assest_id = @assest_id AND
+    timestamp = @timestamp;
+
+-- name: DeleteAssestPrices :execrows
+DELETE FROM assest_price WHERE assest_id = @assest_id;
+
+-- name: GetAssestSeries :many
+SELECT timestamp, price FROM assest_price WHERE
+    assest_id = @assest_id
+ORDER BY timestamp, rowid;
+
+-- name: GetAssestPriceExtremesInTimeRange :one
+SELECT CAST(COALESCE(MIN(price), 0) AS INTEGER) AS low, CAST(COALESCE(MAX(price), 0) AS INTEGER) AS high, COUNT(*) AS count FROM assest_price WHERE
+    assest_id = @assest_id AND
+    timestamp >= @minTimestamp AND
+    timestamp <= @maxTimestamp;
+
+-- name: GetAssestPriceVarianceInTimeRange :one
+SELECT CAST(COALESCE(AVG((price - mean) * (price - mean)), 0) AS REAL) AS variance FROM assest_price, (
+    SELECT AVG(price) AS mean FROM assest_price WHERE
+        assest_id = @assest_id AND
+        timestamp >= @minTimestamp AND
+        timestamp <= @maxTimestamp
+) WHERE
+    assest_id = @assest_id AND
+    timestamp >= @minTimestamp AND
+    timestamp <= @maxTimestamp;
+
+-- name: GetAssestPricesByRankInTimeRange :many
+SELECT price FROM assest_price WHERE
+    assest_id = @assest_id AND
+    timestamp >= @minTimestamp AND
+    timestamp <= @maxTimestamp
+ORDER BY price
+LIMIT @limit OFFSET @offset;
@@ -4,3 +4,5 @@ CREATE TABLE assest_price (
     timestamp int not null,
     price int not null
 );
+
+CREATE INDEX assest_price_assest_timestamp ON assest_price (assest_id, timestamp);
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"log"
+
+	_ "embed"
+
+	"github.com/nivekithan/go-network/problems/means-to-end/db"
+	_ "modernc.org/sqlite"
+)
+
+//go:embed sql/schema.sql
+var ddl string
+
+// Opens the in memory database shared by every SQLite store and creates its
+// tables
+func openDatabase(ctx context.Context) (*sql.DB, error) {
+	sqliteDb, err := sql.Open("sqlite", "file::memory:?cache=shared")
+
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println(ddl)
+
+	if _, err := sqliteDb.ExecContext(ctx, ddl); err != nil {
+		return nil, err
+	}
+
+	// Transactions of different connections would otherwise fail on the
+	// table locks of the shared cache instead of waiting for each other
+	sqliteDb.SetMaxOpenConns(1)
+
+	return sqliteDb, nil
+}
+
+// SQLiteStore keeps the prices of a session in the shared database, keyed by
+// the session id
+type SQLiteStore struct {
+	sqliteDb   *sql.DB
+	queries    *db.Queries
+	assetId    string
+	duplicates string
+}
+
+func NewSQLiteStore(sqliteDb *sql.DB, assetId string, duplicates string) *SQLiteStore {
+	return &SQLiteStore{sqliteDb: sqliteDb, queries: db.New(sqliteDb), assetId: assetId, duplicates: duplicates}
+}
+
+// Inserts every point in a single transaction. The points before one which
+// fails are still committed
+func (s *SQLiteStore) Insert(ctx context.Context, points []PricePoint) (int, int, error) {
+	tx, err := s.sqliteDb.BeginTx(ctx, nil)
+
+	if err != nil {
+		return 0, 0, err
+	}
+
+	queries := s.queries.WithTx(tx)
+	added := 0
+
+	for i, point := range points {
+		isNew, err := s.insert(ctx, queries, point.timestamp, point.price)
+
+		if err != nil {
+			if commitErr := tx.Commit(); commitErr != nil {
+				return 0, 0, commitErr
+			}
+
+			return i, added, err
+		}
+
+		if isNew {
+			added++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+
+	return len(points), added, nil
+}
+
+// Returns whether the price was added rather than overwriting one
+func (s *SQLiteStore) insert(ctx context.Context, queries *db.Queries, timestamp int32, price int32) (bool, error) {
+	if s.duplicates != DuplicatesKeep {
+		existing, err := queries.CountAssestPriceAtTimestamp(ctx, db.CountAssestPriceAtTimestampParams{
+			AssestID:  s.assetId,
+			Timestamp: int64(timestamp),
+		})
+
+		if err != nil {
+			return false, err
+		}
+
+		if existing > 0 && s.duplicates == DuplicatesReject {
+			return false, fmt.Errorf("%w: %d", errDuplicateTimestamp, timestamp)
+		}
+
+		if existing > 0 {
+			_, err := queries.UpdateAssestPriceAtTimestamp(ctx, db.UpdateAssestPriceAtTimestampParams{
+				Price:     int64(price),
+				AssestID:  s.assetId,
+				Timestamp: int64(timestamp),
+			})
+
+			return false, err
+		}
+	}
+
+	err := queries.InsertAssestPrice(ctx, db.InsertAssestPriceParams{
+		ID:        rand.Text(),
+		AssestID:  s.assetId,
+		Timestamp: int64(timestamp),
+		Price:     int64(price),
+	})
+
+	return err == nil, err
+}
+
+func (s *SQLiteStore) Contains(ctx context.Context, timestamp int32) (bool, error) {
+	existing, err := s.queries.CountAssestPriceAtTimestamp(ctx, db.CountAssestPriceAtTimestampParams{
+		AssestID:  s.assetId,
+		Timestamp: int64(timestamp),
+	})
+
+	return existing > 0, err
+}
+
+func (s *SQLiteStore) Summary(ctx context.Context, minTime int32, maxTime int32) (PriceSum, int64, error) {
+	summary, err := s.queries.GetAssestPriceSummaryInTimeRange(ctx, db.GetAssestPriceSummaryInTimeRangeParams{
+		AssestID:     s.assetId,
+		MinTimestamp: int64(minTime),
+		MaxTimestamp: int64(maxTime),
+	})
+
+	if err != nil {
+		return PriceSum{}, 0, err
+	}
+
+	return NewSplitPriceSum(summary.HighTotal, summary.LowTotal), summary.Count, nil
+}
+
+func (s *SQLiteStore) Extremes(ctx context.Context, minTime int32, maxTime int32) (int32, int32, int64, error) {
+	extremes, err := s.queries.GetAssestPriceExtremesInTimeRange(ctx, db.GetAssestPriceExtremesInTimeRangeParams{
+		AssestID:     s.assetId,
+		MinTimestamp: int64(minTime),
+		MaxTimestamp: int64(maxTime),
+	})
+
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	return int32(extremes.Low), int32(extremes.High), extremes.Count, nil
+}
+
+func (s *SQLiteStore) Variance(ctx context.Context, minTime int32, maxTime int32) (float64, error) {
+	return s.queries.GetAssestPriceVarianceInTimeRange(ctx, db.GetAssestPriceVarianceInTimeRangeParams{
+		AssestID:     s.assetId,
+		MinTimestamp: int64(minTime),
+		MaxTimestamp: int64(maxTime),
+	})
+}
+
+func (s *SQLiteStore) PricesByRank(ctx context.Context, minTime int32, maxTime int32, offset int64, limit int64) ([]int32, error) {
+	rows, err := s.queries.GetAssestPricesByRankInTimeRange(ctx, db.GetAssestPricesByRankInTimeRangeParams{
+		AssestID:     s.assetId,
+		MinTimestamp: int64(minTime),
+		MaxTimestamp: int64(maxTime),
+		Limit:        limit,
+		Offset:       offset,
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	prices := make([]int32, len(rows))
+
+	for i, price := range rows {
+		prices[i] = int32(price)
+	}
+
+	return prices, nil
+}
+
+func (s *SQLiteStore) Series(ctx context.Context) ([]PricePoint, error) {
+	rows, err := s.queries.GetAssestSeries(ctx, s.assetId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	points := make([]PricePoint, len(rows))
+
+	for i, row := range rows {
+		points[i] = PricePoint{timestamp: int32(row.Timestamp), price: int32(row.Price)}
+	}
+
+	return points, nil
+}
+
+func (s *SQLiteStore) Drop(ctx context.Context) error {
+	_, err := s.queries.DeleteAssestPrices(ctx, s.assetId)
+
+	return err
+}
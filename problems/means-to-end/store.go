@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// PriceStore holds the prices inserted by a single session
+type PriceStore interface {
+	// Inserts points in order and stops at the first which fails. Returns
+	// how many were inserted and how many of them added a row rather than
+	// overwriting a price
+	Insert(ctx context.Context, points []PricePoint) (inserted int, added int, err error)
+
+	// Whether a price was inserted at timestamp
+	Contains(ctx context.Context, timestamp int32) (bool, error)
+
+	// Sum and number of the prices with minTime <= timestamp <= maxTime
+	Summary(ctx context.Context, minTime int32, maxTime int32) (sum PriceSum, count int64, err error)
+
+	// Lowest and highest of the prices with minTime <= timestamp <= maxTime
+	// and their number. Both are 0 when there is none
+	Extremes(ctx context.Context, minTime int32, maxTime int32) (low int32, high int32, count int64, err error)
+
+	// Population variance of the prices with minTime <= timestamp <= maxTime,
+	// 0 when there is none
+	Variance(ctx context.Context, minTime int32, maxTime int32) (float64, error)
+
+	// Prices with minTime <= timestamp <= maxTime sorted by price, skipping
+	// the first offset and returning at most limit
+	PricesByRank(ctx context.Context, minTime int32, maxTime int32, offset int64, limit int64) ([]int32, error)
+
+	// Every price in timestamp order. Prices with the same timestamp are in
+	// the order they were inserted
+	Series(ctx context.Context) ([]PricePoint, error)
+
+	// Deletes every price of the store
+	Drop(ctx context.Context) error
+}
+
+// What happens when a price is inserted for a timestamp which already has one
+const (
+	// Both prices count towards means
+	DuplicatesKeep = "keep"
+	// The insert fails and the connection is closed
+	DuplicatesReject = "reject"
+	// The new price replaces the old one
+	DuplicatesOverwrite = "overwrite"
+)
+
+var errDuplicateTimestamp = errors.New("duplicate timestamp")
+
+// Creates the store of a new session
+type StoreFactory func(sessionId string) PriceStore
+
+const (
+	StoreMemory = "memory"
+	StoreSQLite = "sqlite"
+)
+
+// The SQLite database is only opened for the sqlite store
+func newStoreFactory(ctx context.Context, kind string, duplicates string) (StoreFactory, error) {
+	switch duplicates {
+	case DuplicatesKeep, DuplicatesReject, DuplicatesOverwrite:
+	default:
+		return nil, fmt.Errorf("unknown duplicates policy %q, must be keep, reject or overwrite", duplicates)
+	}
+
+	switch kind {
+	case StoreMemory:
+		return func(sessionId string) PriceStore {
+			return NewMemoryStore(duplicates)
+		}, nil
+
+	case StoreSQLite:
+		sqliteDb, err := openDatabase(ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return func(sessionId string) PriceStore {
+			return NewSQLiteStore(sqliteDb, sessionId, duplicates)
+		}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown store %q, must be memory or sqlite", kind)
+	}
+}
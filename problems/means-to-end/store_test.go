@@ -0,0 +1,522 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/nivekithan/go-network/problems/means-to-end/db"
+)
+
+var (
+	testDatabaseOnce sync.Once
+	testDatabase     *sql.DB
+	testDatabaseErr  error
+)
+
+// The database is shared by every SQLite store of the process and its schema
+// can only be created once
+func openTestDatabase(t testing.TB) *sql.DB {
+	t.Helper()
+
+	testDatabaseOnce.Do(func() {
+		testDatabase, testDatabaseErr = openDatabase(context.Background())
+	})
+
+	if testDatabaseErr != nil {
+		t.Fatal(testDatabaseErr)
+	}
+
+	return testDatabase
+}
+
+// Every store runs the same tests. newStore creates an empty store
+var testStores = []struct {
+	name     string
+	newStore func(t testing.TB, duplicates string) PriceStore
+}{
+	{
+		name: StoreMemory,
+		newStore: func(t testing.TB, duplicates string) PriceStore {
+			return NewMemoryStore(duplicates)
+		},
+	},
+	{
+		name: StoreSQLite,
+		newStore: func(t testing.TB, duplicates string) PriceStore {
+			// Test names are unique, so are the assets
+			return NewSQLiteStore(openTestDatabase(t), t.Name(), duplicates)
+		},
+	},
+}
+
+type testQuery struct {
+	minTime   int32
+	maxTime   int32
+	wantCount int64
+	wantMean  int32
+}
+
+func TestStoreMeans(t *testing.T) {
+	tests := []struct {
+		name       string
+		duplicates string
+		points     []PricePoint
+
+		// Inserted points before the one which fails
+		wantInserted int
+		wantErr      error
+
+		queries []testQuery
+	}{
+		{
+			name:         "protocol example",
+			duplicates:   DuplicatesKeep,
+			points:       []PricePoint{{12345, 101}, {12346, 102}, {12347, 100}, {40960, 5}},
+			wantInserted: 4,
+			queries:      []testQuery{{minTime: 12288, maxTime: 16384, wantCount: 3, wantMean: 101}},
+		},
+		{
+			name:         "no prices",
+			duplicates:   DuplicatesKeep,
+			wantInserted: 0,
+			queries:      []testQuery{{minTime: math.MinInt32, maxTime: math.MaxInt32, wantCount: 0, wantMean: 0}},
+		},
+		{
+			name:         "empty range",
+			duplicates:   DuplicatesKeep,
+			points:       []PricePoint{{10, 1}, {20, 2}},
+			wantInserted: 2,
+			queries: []testQuery{
+				{minTime: 11, maxTime: 19, wantCount: 0, wantMean: 0},
+				{minTime: 21, maxTime: 30, wantCount: 0, wantMean: 0},
+			},
+		},
+		{
+			name:         "min after max",
+			duplicates:   DuplicatesKeep,
+			points:       []PricePoint{{10, 1}, {20, 2}},
+			wantInserted: 2,
+			queries:      []testQuery{{minTime: 20, maxTime: 10, wantCount: 0, wantMean: 0}},
+		},
+		{
+			name:         "inclusive bounds",
+			duplicates:   DuplicatesKeep,
+			points:       []PricePoint{{10, 1}, {20, 2}, {30, 6}},
+			wantInserted: 3,
+			queries: []testQuery{
+				{minTime: 10, maxTime: 20, wantCount: 2, wantMean: 2},
+				{minTime: 20, maxTime: 30, wantCount: 2, wantMean: 4},
+				{minTime: 10, maxTime: 10, wantCount: 1, wantMean: 1},
+			},
+		},
+		{
+			name:         "out of order",
+			duplicates:   DuplicatesKeep,
+			points:       []PricePoint{{30, 6}, {10, 1}, {20, 2}, {-5, 3}},
+			wantInserted: 4,
+			queries: []testQuery{
+				{minTime: math.MinInt32, maxTime: math.MaxInt32, wantCount: 4, wantMean: 3},
+				{minTime: -10, maxTime: 15, wantCount: 2, wantMean: 2},
+			},
+		},
+		{
+			name:         "negative means",
+			duplicates:   DuplicatesKeep,
+			points:       []PricePoint{{1, -1}, {2, -2}, {3, -1}},
+			wantInserted: 3,
+			queries: []testQuery{
+				{minTime: 1, maxTime: 2, wantCount: 2, wantMean: -2},
+				{minTime: 1, maxTime: 3, wantCount: 3, wantMean: -1},
+			},
+		},
+		{
+			name:         "extreme prices",
+			duplicates:   DuplicatesKeep,
+			points:       []PricePoint{{1, math.MaxInt32}, {2, math.MaxInt32}, {3, math.MinInt32}, {4, math.MinInt32}},
+			wantInserted: 4,
+			queries: []testQuery{
+				{minTime: 1, maxTime: 2, wantCount: 2, wantMean: math.MaxInt32},
+				{minTime: 3, maxTime: 4, wantCount: 2, wantMean: math.MinInt32},
+				{minTime: 2, maxTime: 3, wantCount: 2, wantMean: -1},
+			},
+		},
+		{
+			name:         "duplicates kept",
+			duplicates:   DuplicatesKeep,
+			points:       []PricePoint{{1, 10}, {1, 21}},
+			wantInserted: 2,
+			queries:      []testQuery{{minTime: 1, maxTime: 1, wantCount: 2, wantMean: 16}},
+		},
+		{
+			name:         "duplicates rejected",
+			duplicates:   DuplicatesReject,
+			points:       []PricePoint{{1, 10}, {2, 30}, {1, 21}, {3, 50}},
+			wantInserted: 2,
+			wantErr:      errDuplicateTimestamp,
+			queries:      []testQuery{{minTime: 1, maxTime: 3, wantCount: 2, wantMean: 20}},
+		},
+		{
+			name:         "duplicates overwritten",
+			duplicates:   DuplicatesOverwrite,
+			points:       []PricePoint{{1, 10}, {2, 30}, {1, 20}},
+			wantInserted: 3,
+			queries: []testQuery{
+				{minTime: 1, maxTime: 1, wantCount: 1, wantMean: 20},
+				{minTime: 1, maxTime: 2, wantCount: 2, wantMean: 25},
+			},
+		},
+	}
+
+	for _, testStore := range testStores {
+		for _, test := range tests {
+			t.Run(testStore.name+"/"+test.name, func(t *testing.T) {
+				ctx := context.Background()
+				store := testStore.newStore(t, test.duplicates)
+
+				inserted, _, err := store.Insert(ctx, test.points)
+
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("Insert() error = %v, want %v", err, test.wantErr)
+				}
+
+				if inserted != test.wantInserted {
+					t.Fatalf("Insert() = %d, want %d", inserted, test.wantInserted)
+				}
+
+				for _, query := range test.queries {
+					sum, count, err := store.Summary(ctx, query.minTime, query.maxTime)
+
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if count != query.wantCount {
+						t.Errorf("Summary(%d, %d) count = %d, want %d", query.minTime, query.maxTime, count, query.wantCount)
+					}
+
+					if mean := findMean(sum, count); mean != query.wantMean {
+						t.Errorf("mean of %d..%d = %d, want %d", query.minTime, query.maxTime, mean, query.wantMean)
+					}
+				}
+			})
+		}
+	}
+}
+
+// A plain SUM of these prices leaves the int64 range, which SQLite fails
+// instead of wrapping around. Prices are int32, so prices that large are
+// stored directly in the table rather than inserted
+func TestSQLiteStoreOverflowingSum(t *testing.T) {
+	ctx := context.Background()
+	sqliteDb := openTestDatabase(t)
+	store := NewSQLiteStore(sqliteDb, t.Name(), DuplicatesKeep)
+	queries := db.New(sqliteDb)
+
+	for i, price := range []int64{math.MaxInt64, math.MaxInt64, 2} {
+		if err := queries.InsertAssestPrice(ctx, db.InsertAssestPriceParams{
+			ID:        t.Name() + string(rune('a'+i)),
+			AssestID:  t.Name(),
+			Timestamp: int64(i),
+			Price:     price,
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sum, count, err := store.Summary(ctx, 0, 2)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 3 {
+		t.Errorf("count = %d, want 3", count)
+	}
+
+	want := NewPriceSum(math.MaxInt64)
+	want.Add(math.MaxInt64)
+	want.Add(2)
+
+	if sum.value().Cmp(want.value()) != 0 {
+		t.Errorf("sum = %v, want %v", sum.value(), want.value())
+	}
+}
+
+func TestStoreExtensions(t *testing.T) {
+	points := []PricePoint{{10, 5}, {20, -3}, {30, 8}, {40, 8}, {50, 1}, {60, math.MaxInt32}}
+	all := TimeRange{MinTime: math.MinInt32, MaxTime: math.MaxInt32}
+	empty := TimeRange{MinTime: 41, MaxTime: 49}
+	reversed := TimeRange{MinTime: 50, MaxTime: 10}
+
+	tests := []struct {
+		name    string
+		command ExtensionCommand
+		want    []int64
+	}{
+		{name: "min", command: &MinCommand{TimeRange{10, 50}}, want: []int64{-3}},
+		{name: "min of empty range", command: &MinCommand{empty}, want: []int64{0}},
+		{name: "min after max", command: &MinCommand{reversed}, want: []int64{0}},
+		{name: "max", command: &MaxCommand{all}, want: []int64{math.MaxInt32}},
+		{name: "max of empty range", command: &MaxCommand{empty}, want: []int64{0}},
+		{name: "count", command: &CountCommand{TimeRange{20, 40}}, want: []int64{3}},
+		{name: "count after max", command: &CountCommand{reversed}, want: []int64{0}},
+		{name: "odd median", command: &MedianCommand{TimeRange{10, 50}}, want: []int64{5}},
+		{name: "even median", command: &MedianCommand{TimeRange{10, 40}}, want: []int64{7}},
+		{name: "negative median", command: &MedianCommand{TimeRange{20, 20}}, want: []int64{-3}},
+		{name: "median of empty range", command: &MedianCommand{empty}, want: []int64{0}},
+		{name: "stddev", command: &StdDevCommand{TimeRange{30, 40}}, want: []int64{0}},
+		{name: "rounded stddev", command: &StdDevCommand{TimeRange{10, 30}}, want: []int64{5}},
+		{name: "stddev of empty range", command: &StdDevCommand{empty}, want: []int64{0}},
+		{name: "one bucket", command: &BucketsCommand{TimeRange{10, 50}, 1}, want: []int64{4}},
+		{name: "buckets", command: &BucketsCommand{TimeRange{10, 59}, 5}, want: []int64{5, -3, 8, 8, 1}},
+		{name: "empty buckets", command: &BucketsCommand{TimeRange{10, 11}, 3}, want: []int64{0, 5, 0}},
+		{name: "buckets after max", command: &BucketsCommand{reversed, 2}, want: []int64{0, 0}},
+	}
+
+	for _, testStore := range testStores {
+		for _, test := range tests {
+			t.Run(testStore.name+"/"+test.name, func(t *testing.T) {
+				ctx := context.Background()
+				store := testStore.newStore(t, DuplicatesKeep)
+
+				if _, _, err := store.Insert(ctx, points); err != nil {
+					t.Fatal(err)
+				}
+
+				got, err := test.command.Answer(ctx, store)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				want := []byte{}
+
+				for _, value := range test.want {
+					want = append(want, encodeResponse(int32(value))...)
+				}
+
+				if !bytes.Equal(got, want) {
+					t.Errorf("Answer() = %v, want %v", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestMedianOfTooManyPrices(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore(DuplicatesKeep)
+	points := make([]PricePoint, maxRankedPrices+1)
+
+	if _, _, err := store.Insert(ctx, points); err != nil {
+		t.Fatal(err)
+	}
+
+	command := &MedianCommand{TimeRange{MinTime: 0, MaxTime: 0}}
+
+	if _, err := command.Answer(ctx, store); !errors.Is(err, errRangeTooLarge) {
+		t.Fatalf("Answer() error = %v, want %v", err, errRangeTooLarge)
+	}
+}
+
+func TestStoreSeries(t *testing.T) {
+	for _, testStore := range testStores {
+		t.Run(testStore.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := testStore.newStore(t, DuplicatesOverwrite)
+
+			if _, _, err := store.Insert(ctx, []PricePoint{{30, 3}, {10, 1}, {20, 2}, {10, 4}}); err != nil {
+				t.Fatal(err)
+			}
+
+			series, err := store.Series(ctx)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if want := []PricePoint{{10, 4}, {20, 2}, {30, 3}}; !slices.Equal(series, want) {
+				t.Errorf("Series() = %v, want %v", series, want)
+			}
+
+			for timestamp, want := range map[int32]bool{10: true, 15: false, 30: true} {
+				if contains, err := store.Contains(ctx, timestamp); err != nil || contains != want {
+					t.Errorf("Contains(%d) = %t, %v, want %t", timestamp, contains, err, want)
+				}
+			}
+
+			if err := store.Drop(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			if series, err := store.Series(ctx); err != nil || len(series) != 0 {
+				t.Errorf("Series() after Drop() = %v, %v, want none", series, err)
+			}
+		})
+	}
+}
+
+// Inserts and overwrites prices at random timestamps, checking every store
+// against sums of the inserted prices after each batch
+func TestStoresRandomInserts(t *testing.T) {
+	for _, duplicates := range []string{DuplicatesKeep, DuplicatesOverwrite} {
+		for _, testStore := range testStores {
+			t.Run(testStore.name+"/"+duplicates, func(t *testing.T) {
+				ctx := context.Background()
+				store := testStore.newStore(t, duplicates)
+				rng := rand.New(rand.NewPCG(1, 2))
+
+				// Prices by timestamp in insertion order
+				prices := map[int32][]int32{}
+
+				for range 40 {
+					batch := []PricePoint{}
+
+					for range 50 {
+						point := PricePoint{timestamp: rng.Int32N(1000), price: rng.Int32() - math.MaxInt32/2}
+
+						if duplicates == DuplicatesOverwrite {
+							prices[point.timestamp] = []int32{point.price}
+						} else {
+							prices[point.timestamp] = append(prices[point.timestamp], point.price)
+						}
+
+						batch = append(batch, point)
+					}
+
+					if _, _, err := store.Insert(ctx, batch); err != nil {
+						t.Fatal(err)
+					}
+
+					minTime := rng.Int32N(1000)
+					maxTime := minTime + rng.Int32N(1000-minTime)
+
+					want := PriceSum{}
+					wantCount := int64(0)
+
+					for timestamp, timestampPrices := range prices {
+						if timestamp < minTime || timestamp > maxTime {
+							continue
+						}
+
+						for _, price := range timestampPrices {
+							want.Add(int64(price))
+							wantCount++
+						}
+					}
+
+					sum, count, err := store.Summary(ctx, minTime, maxTime)
+
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					if count != wantCount || sum.value().Cmp(want.value()) != 0 {
+						t.Fatalf("Summary(%d, %d) = %v, %d, want %v, %d", minTime, maxTime, sum.value(), count, want.value(), wantCount)
+					}
+				}
+
+				series, err := store.Series(ctx)
+
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				wantSeries := []PricePoint{}
+
+				for timestamp := range int32(1000) {
+					for _, price := range prices[timestamp] {
+						wantSeries = append(wantSeries, PricePoint{timestamp: timestamp, price: price})
+					}
+				}
+
+				if !slices.Equal(series, wantSeries) {
+					t.Errorf("Series() = %v, want %v", series, wantSeries)
+				}
+			})
+		}
+	}
+}
+
+// Inserts b.N prices in timestamp order, querying the mean of the last
+// thousand after every thousand
+func BenchmarkStores(b *testing.B) {
+	for _, testStore := range testStores {
+		b.Run(testStore.name, func(b *testing.B) {
+			ctx := context.Background()
+			store := testStore.newStore(b, DuplicatesKeep)
+			batch := []PricePoint{}
+
+			// Every round of the benchmark uses the same SQLite asset
+			if err := store.Drop(ctx); err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+
+			for i := range b.N {
+				batch = append(batch, PricePoint{timestamp: int32(i), price: int32(i % 100)})
+
+				if len(batch) < 1000 && i < b.N-1 {
+					continue
+				}
+
+				if _, _, err := store.Insert(ctx, batch); err != nil {
+					b.Fatal(err)
+				}
+
+				if _, _, err := store.Summary(ctx, batch[0].timestamp, batch[len(batch)-1].timestamp); err != nil {
+					b.Fatal(err)
+				}
+
+				batch = batch[:0]
+			}
+		})
+	}
+}
+
+// Inserts b.N prices at random timestamps, querying the mean of a random
+// range after every thousand
+func BenchmarkStoresRandomOrder(b *testing.B) {
+	for _, testStore := range testStores {
+		b.Run(testStore.name, func(b *testing.B) {
+			ctx := context.Background()
+			store := testStore.newStore(b, DuplicatesKeep)
+			rng := rand.New(rand.NewPCG(1, 2))
+			batch := []PricePoint{}
+
+			if err := store.Drop(ctx); err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+
+			for i := range b.N {
+				batch = append(batch, PricePoint{timestamp: rng.Int32(), price: int32(i % 100)})
+
+				if len(batch) < 1000 && i < b.N-1 {
+					continue
+				}
+
+				if _, _, err := store.Insert(ctx, batch); err != nil {
+					b.Fatal(err)
+				}
+
+				minTime := rng.Int32()
+
+				if _, _, err := store.Summary(ctx, minTime, minTime+rng.Int32N(math.MaxInt32-minTime)); err != nil {
+					b.Fatal(err)
+				}
+
+				batch = batch[:0]
+			}
+		})
+	}
+}
@@ -0,0 +1,575 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nivekithan/go-network/problems/speed-daemon/client"
+	"github.com/nivekithan/go-network/problems/speed-daemon/db"
+)
+
+type RoadResponse struct {
+	ID         int64 `json:"id"`
+	SpeedLimit int64 `json:"speed_limit"`
+}
+
+type CameraResponse struct {
+	ID          string    `json:"id"`
+	RemoteAddr  string    `json:"remote_addr"`
+	ConnectedAt time.Time `json:"connected_at"`
+
+	// Common name of the client certificate. Empty for plain TCP clients
+	CertIdentity string `json:"cert_identity,omitempty"`
+
+	Road  uint16 `json:"road"`
+	Mile  uint16 `json:"mile"`
+	Limit uint16 `json:"limit"`
+}
+
+type DispatcherResponse struct {
+	ID           string    `json:"id"`
+	RemoteAddr   string    `json:"remote_addr"`
+	ConnectedAt  time.Time `json:"connected_at"`
+	CertIdentity string    `json:"cert_identity,omitempty"`
+	Roads        []uint16  `json:"roads"`
+}
+
+type LimitConflictResponse struct {
+	Road        int64     `json:"road"`
+	Mile        int64     `json:"mile"`
+	CameraLimit int64     `json:"camera_limit"`
+	RoadLimit   int64     `json:"road_limit"`
+	ClientID    string    `json:"client_id"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+type StatsResponse struct {
+	ConnectedCameras     int `json:"connected_cameras"`
+	ConnectedDispatchers int `json:"connected_dispatchers"`
+
+	// Cameras which declared a different limit than the one of their road
+	LimitConflicts       int64                   `json:"limit_conflicts"`
+	RecentLimitConflicts []LimitConflictResponse `json:"recent_limit_conflicts"`
+}
+
+type TicketResponse struct {
+	ID         int64  `json:"id"`
+	Plate      string `json:"plate"`
+	Road       int64  `json:"road"`
+	Mile1      int64  `json:"mile1"`
+	Timestamp1 int64  `json:"timestamp1"`
+	Mile2      int64  `json:"mile2"`
+	Timestamp2 int64  `json:"timestamp2"`
+
+	// Miles per hour
+	Speed    float64 `json:"speed"`
+	Severity string  `json:"severity,omitempty"`
+	Status   string  `json:"status"`
+}
+
+type TicketEventResponse struct {
+	Event        string    `json:"event"`
+	CreatedAt    time.Time `json:"created_at"`
+	DispatcherID string    `json:"dispatcher_id,omitempty"`
+	Detail       string    `json:"detail,omitempty"`
+}
+
+func newTicketEventResponse(event db.TicketEvent) TicketEventResponse {
+	return TicketEventResponse{
+		Event:        event.Event,
+		CreatedAt:    time.UnixMilli(event.CreatedAt).UTC(),
+		DispatcherID: event.DispatcherID,
+		Detail:       event.Detail,
+	}
+}
+
+type ObservationResponse struct {
+	ID        int64 `json:"id"`
+	Road      int64 `json:"road"`
+	Mile      int64 `json:"mile"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+// Two consecutive observations of a plate on the same road
+type SegmentResponse struct {
+	Road         int64 `json:"road"`
+	SpeedLimit   int64 `json:"speed_limit"`
+	Observation1 int64 `json:"observation1"`
+	Observation2 int64 `json:"observation2"`
+	Speed        int64 `json:"speed"`
+
+	// Whether the rules ticket the speed, ignoring plate exemptions
+	ExceedsLimit bool   `json:"exceeds_limit"`
+	Severity     string `json:"severity,omitempty"`
+}
+
+type PlateHistoryResponse struct {
+	Plate        string                `json:"plate"`
+	Observations []ObservationResponse `json:"observations"`
+	Segments     []SegmentResponse     `json:"segments"`
+	Tickets      []TicketResponse      `json:"tickets"`
+}
+
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+const (
+	TicketPending   = "pending"
+	TicketDelivered = "delivered"
+	TicketVoided    = "voided"
+)
+
+func ticketStatus(ticket db.Ticket) string {
+	if ticket.Voided != 0 {
+		return TicketVoided
+	}
+
+	if ticket.IsProcessed != 0 {
+		return TicketDelivered
+	}
+
+	return TicketPending
+}
+
+func newTicketResponse(ticket db.Ticket) TicketResponse {
+	return TicketResponse{
+		ID:         ticket.ID,
+		Plate:      ticket.PlateNumber,
+		Road:       ticket.RoadID,
+		Mile1:      ticket.Mile1,
+		Timestamp1: ticket.Timestamp1,
+		Mile2:      ticket.Mile2,
+		Timestamp2: ticket.Timestamp2,
+		Speed:      float64(ticket.Speed) / 100,
+		Severity:   ticket.Severity,
+		Status:     ticketStatus(ticket),
+	}
+}
+
+type AdminHandler struct {
+	queries Repository
+	rules   *Rules
+
+	// Nil unless the server is part of a cluster
+	cluster *Cluster
+}
+
+// NewAdminHandler returns the JSON admin API
+func NewAdminHandler(queries Repository, rules *Rules, cluster *Cluster) http.Handler {
+	admin := &AdminHandler{queries: queries, rules: rules, cluster: cluster}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /roads", admin.listRoads)
+	mux.HandleFunc("GET /cameras", admin.listCameras)
+	mux.HandleFunc("GET /dispatchers", admin.listDispatchers)
+	mux.HandleFunc("GET /tickets", admin.listTickets)
+	mux.HandleFunc("GET /tickets/{id}", admin.getTicket)
+	mux.HandleFunc("GET /tickets/{id}/events", admin.getTicketEvents)
+	mux.HandleFunc("POST /tickets/{id}/requeue", admin.requeueTicket)
+	mux.HandleFunc("POST /tickets/{id}/void", admin.voidTicket)
+	mux.HandleFunc("GET /plates/{plate}", admin.getPlateHistory)
+	mux.HandleFunc("GET /stats", admin.getStats)
+
+	return mux
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("error writing admin response: %v", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, ErrorResponse{Error: err.Error()})
+}
+
+func (a *AdminHandler) listRoads(w http.ResponseWriter, r *http.Request) {
+	roads, err := a.queries.ListRoads(r.Context())
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response := []RoadResponse{}
+
+	for _, road := range roads {
+		response = append(response, RoadResponse{ID: road.ID, SpeedLimit: road.SpeedLimit})
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (a *AdminHandler) listCameras(w http.ResponseWriter, r *http.Request) {
+	response := []CameraResponse{}
+
+	for _, client := range listClients() {
+		role, camera, _ := client.identity()
+
+		if role != RoleCamera {
+			continue
+		}
+
+		response = append(response, CameraResponse{
+			ID:           client.id,
+			RemoteAddr:   client.conn.RemoteAddr().String(),
+			ConnectedAt:  client.connectedAt,
+			CertIdentity: client.certIdentity,
+			Road:         camera.road,
+			Mile:         camera.mile,
+			Limit:        camera.limit,
+		})
+	}
+
+	slices.SortFunc(response, func(a, b CameraResponse) int {
+		return a.ConnectedAt.Compare(b.ConnectedAt)
+	})
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func (a *AdminHandler) listDispatchers(w http.ResponseWriter, r *http.Request) {
+	dispatcherConnLock.Lock()
+	dispatchers := make([]*Client, 0, len(dispatcherConnMap))
+
+	for _, client := range dispatcherConnMap {
+		dispatchers = append(dispatchers, client)
+	}
+
+	dispatcherConnLock.Unlock()
+
+	response := []DispatcherResponse{}
+
+	for _, client := range dispatchers {
+		_, _, dispatcher := client.identity()
+
+		response = append(response, DispatcherResponse{
+			ID:           client.id,
+			RemoteAddr:   client.conn.RemoteAddr().String(),
+			ConnectedAt:  client.connectedAt,
+			CertIdentity: client.certIdentity,
+			Roads:        dispatcher.roads,
+		})
+	}
+
+	slices.SortFunc(response, func(a, b DispatcherResponse) int {
+		return a.ConnectedAt.Compare(b.ConnectedAt)
+	})
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Accepts an optional status query parameter: pending, delivered or voided
+func (a *AdminHandler) listTickets(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+
+	if status != "" && status != TicketPending && status != TicketDelivered && status != TicketVoided {
+		writeError(w, http.StatusBadRequest, errors.New("status must be one of pending, delivered or voided"))
+		return
+	}
+
+	tickets, err := a.queries.ListTickets(r.Context())
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response := []TicketResponse{}
+
+	for _, ticket := range tickets {
+		if status != "" && ticketStatus(ticket) != status {
+			continue
+		}
+
+		response = append(response, newTicketResponse(ticket))
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Writes the error response itself and returns false if the ticket could not
+// be found
+func (a *AdminHandler) findTicket(w http.ResponseWriter, r *http.Request) (db.Ticket, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+
+	if err != nil {
+		writeError(w, http.StatusBadRequest, errors.New("ticket id must be an integer"))
+		return db.Ticket{}, false
+	}
+
+	ticket, err := a.queries.GetTicket(r.Context(), id)
+
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, http.StatusNotFound, errors.New("ticket not found"))
+		return db.Ticket{}, false
+	}
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return db.Ticket{}, false
+	}
+
+	return ticket, true
+}
+
+func (a *AdminHandler) getTicket(w http.ResponseWriter, r *http.Request) {
+	ticket, ok := a.findTicket(w, r)
+
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newTicketResponse(ticket))
+}
+
+func (a *AdminHandler) getTicketEvents(w http.ResponseWriter, r *http.Request) {
+	ticket, ok := a.findTicket(w, r)
+
+	if !ok {
+		return
+	}
+
+	events, err := a.queries.GetTicketEvents(r.Context(), ticket.ID)
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response := []TicketEventResponse{}
+
+	for _, event := range events {
+		response = append(response, newTicketEventResponse(event))
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Delivers the ticket again, even if it was already delivered or voided
+func (a *AdminHandler) requeueTicket(w http.ResponseWriter, r *http.Request) {
+	ticket, ok := a.findTicket(w, r)
+
+	if !ok {
+		return
+	}
+
+	// A delivery in progress would mark the ticket as processed again
+	ticketDeliveryLock.Lock()
+	_, err := a.queries.RequeueTicket(r.Context(), ticket.ID)
+	ticketDeliveryLock.Unlock()
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("Admin requeued ticket %d", ticket.ID)
+	recordTicketEvent(r.Context(), a.queries, ticket.ID, TicketEventRequeued, "", "requeued by admin")
+
+	// The days of a voided ticket were released. The admin decides to
+	// deliver it anyway, so a conflict is only logged
+	if ticket.Voided != 0 && a.ownsTicket(ticket) {
+		if !a.cluster.claimTicketDays(r.Context(), a.queries, ticketDays(ticket)) {
+			log.Printf("Requeued ticket %d conflicts with a ticket of another node", ticket.ID)
+		}
+	}
+
+	notifyPendingTickets(ticket.RoadID)
+
+	ticket.IsProcessed = 0
+	ticket.Voided = 0
+
+	writeJSON(w, http.StatusOK, newTicketResponse(ticket))
+}
+
+func (a *AdminHandler) voidTicket(w http.ResponseWriter, r *http.Request) {
+	ticket, ok := a.findTicket(w, r)
+
+	if !ok {
+		return
+	}
+
+	// A delivery in progress finishes first. Later deliveries see the ticket
+	// voided
+	ticketDeliveryLock.Lock()
+	_, err := a.queries.VoidTicket(r.Context(), ticket.ID)
+	ticketDeliveryLock.Unlock()
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	log.Printf("Admin voided ticket %d", ticket.ID)
+	recordTicketEvent(r.Context(), a.queries, ticket.ID, TicketEventVoided, "", "voided by admin")
+
+	if ticket.Voided == 0 && a.ownsTicket(ticket) {
+		if err := a.cluster.releaseTicketDays(r.Context(), a.queries, ticketDays(ticket)); err != nil {
+			log.Printf("error: voided ticket %d still conflicts with tickets of other nodes: %v", ticket.ID, err)
+		}
+	}
+
+	ticket.Voided = 1
+
+	writeJSON(w, http.StatusOK, newTicketResponse(ticket))
+}
+
+// Whether the ticket was generated by this node of a cluster, which claimed
+// its days from the authority of its plate. Tickets forwarded by other nodes
+// were claimed by them
+func (a *AdminHandler) ownsTicket(ticket db.Ticket) bool {
+	if a.cluster == nil {
+		return false
+	}
+
+	_, remote := a.cluster.remoteOwner(uint16(ticket.RoadID))
+
+	return !remote
+}
+
+func ticketDays(ticket db.Ticket) client.TicketDays {
+	return client.TicketDays{Plate: ticket.PlateNumber, Start: uint32(ticket.DayStartRange), End: uint32(ticket.DayEndRange)}
+}
+
+// Lists the observations of a plate along with the speed between each pair of
+// consecutive observations on a road, which explains why it was or was not
+// ticketed
+func (a *AdminHandler) getPlateHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	plate := strings.TrimSpace(r.PathValue("plate"))
+
+	observations, err := a.queries.GetObservationsForPlate(ctx, plate)
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	tickets, err := a.queries.GetTicketsForPlate(ctx, plate)
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response := PlateHistoryResponse{
+		Plate:        plate,
+		Observations: []ObservationResponse{},
+		Segments:     []SegmentResponse{},
+		Tickets:      []TicketResponse{},
+	}
+
+	speedLimits := map[int64]int64{}
+
+	for i, observation := range observations {
+		response.Observations = append(response.Observations, ObservationResponse{
+			ID:        observation.ID,
+			Road:      observation.RoadID,
+			Mile:      observation.Location,
+			Timestamp: observation.Timestamp,
+		})
+
+		if i == 0 {
+			continue
+		}
+
+		previous := observations[i-1]
+
+		if previous.RoadID != observation.RoadID || previous.Timestamp == observation.Timestamp {
+			continue
+		}
+
+		speedLimit, ok := speedLimits[observation.RoadID]
+
+		if !ok {
+			road, err := a.queries.GetRoad(ctx, observation.RoadID)
+
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, err)
+				return
+			}
+
+			speedLimit = road.SpeedLimit
+			speedLimits[observation.RoadID] = speedLimit
+		}
+
+		speed := observedSpeed(previous, observation)
+		exceedsLimit, severity := a.rules.Evaluate(uint16(observation.RoadID), speedLimit, speed)
+
+		response.Segments = append(response.Segments, SegmentResponse{
+			Road:         observation.RoadID,
+			SpeedLimit:   speedLimit,
+			Observation1: previous.ID,
+			Observation2: observation.ID,
+			Speed:        int64(math.Round(speed)),
+			ExceedsLimit: exceedsLimit,
+			Severity:     severity,
+		})
+	}
+
+	for _, ticket := range tickets {
+		response.Tickets = append(response.Tickets, newTicketResponse(ticket))
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+const recentLimitConflicts = 20
+
+func (a *AdminHandler) getStats(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	response := StatsResponse{RecentLimitConflicts: []LimitConflictResponse{}}
+
+	for _, client := range listClients() {
+		role, _, _ := client.identity()
+
+		switch role {
+		case RoleCamera:
+			response.ConnectedCameras++
+		case RoleDispatcher:
+			response.ConnectedDispatchers++
+		}
+	}
+
+	limitConflicts, err := a.queries.CountLimitConflicts(ctx)
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	response.LimitConflicts = limitConflicts
+
+	conflicts, err := a.queries.GetLimitConflicts(ctx, recentLimitConflicts)
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	for _, conflict := range conflicts {
+		response.RecentLimitConflicts = append(response.RecentLimitConflicts, LimitConflictResponse{
+			Road:        conflict.RoadID,
+			Mile:        conflict.Mile,
+			CameraLimit: conflict.SpeedLimit,
+			RoadLimit:   conflict.RoadSpeedLimit,
+			ClientID:    conflict.ClientID,
+			CreatedAt:   time.UnixMilli(conflict.CreatedAt),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
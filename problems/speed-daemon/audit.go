@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/nivekithan/go-network/problems/speed-daemon/db"
+)
+
+const (
+	TicketEventCreated        = "created"
+	TicketEventDelivered      = "delivered"
+	TicketEventDeliveryFailed = "delivery_failed"
+	TicketEventRequeued       = "requeued"
+	TicketEventVoided         = "voided"
+
+	// Handed back to the node which owns the road of the ticket because no
+	// dispatcher of this node could take it
+	TicketEventReturned = "returned"
+)
+
+// Appends an event to the audit trail of a ticket. Failing to record an event
+// must not stop the ticket itself, so errors are only logged.
+func recordTicketEvent(ctx context.Context, queries Repository, ticketId int64, event string, dispatcherId string, detail string) {
+	if err := queries.InsertTicketEvent(ctx, db.InsertTicketEventParams{
+		TicketID:     ticketId,
+		Event:        event,
+		CreatedAt:    time.Now().UnixMilli(),
+		DispatcherID: dispatcherId,
+		Detail:       detail,
+	}); err != nil {
+		log.Printf("Error recording %s event for ticket %d: %v", event, ticketId, err)
+	}
+}
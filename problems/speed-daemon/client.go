@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/nivekithan/go-network/problems/speed-daemon/client"
+)
+
+type ClientRole int
+
+const (
+	RoleUnknown ClientRole = iota
+	RoleCamera
+	RoleDispatcher
+	RoleNode
+)
+
+func (r ClientRole) String() string {
+	switch r {
+	case RoleCamera:
+		return "camera"
+	case RoleDispatcher:
+		return "dispatcher"
+	case RoleNode:
+		return "node"
+	default:
+		return "unknown"
+	}
+}
+
+// Every connected client by id
+var clientsMap map[string]*Client
+var clientsLock sync.Mutex
+
+func addClient(client *Client) {
+	clientsLock.Lock()
+	defer clientsLock.Unlock()
+
+	clientsMap[client.id] = client
+}
+
+func removeClient(client *Client) {
+	clientsLock.Lock()
+	defer clientsLock.Unlock()
+
+	delete(clientsMap, client.id)
+}
+
+func listClients() []*Client {
+	clientsLock.Lock()
+	defer clientsLock.Unlock()
+
+	clients := make([]*Client, 0, len(clientsMap))
+
+	for _, client := range clientsMap {
+		clients = append(clients, client)
+	}
+
+	return clients
+}
+
+// A client which does not accept a message within this duration is
+// considered dead
+const clientWriteTimeout = 10 * time.Second
+
+type OutgoingMessage struct {
+	data []byte
+
+	// Receives the result of the write
+	result chan error
+}
+
+// Client is a single connection to the server. Every write to the connection
+// goes through its writer goroutine, so messages never interleave.
+type Client struct {
+	id          string
+	conn        net.Conn
+	reader      *bufio.Reader
+	connectedAt time.Time
+
+	// Common name of the client certificate on TLS connections
+	certIdentity string
+
+	// Registrations of clients with a certificate are checked against the
+	// policy. Nil allows every registration
+	policy *Policy
+
+	// One of LimitConflictReject, LimitConflictFirst or LimitConflictMin
+	limitConflict string
+
+	// Nil unless the server is part of a cluster
+	cluster *Cluster
+
+	// Id of the cluster node on the other end of a node connection
+	nodeId string
+
+	// Connection to the node which owns the road of a camera, when it is not
+	// this node
+	proxy *client.Camera
+
+	// Guards role, camera and dispatcher which are read by the admin API
+	lock       sync.Mutex
+	role       ClientRole
+	camera     *IAmCamera
+	dispatcher *IamDispatcher
+
+	isWantHeartbeat bool
+
+	outgoingChan  chan OutgoingMessage
+	heartbeatChan chan time.Duration
+
+	closeOnce sync.Once
+	closeChan chan struct{}
+
+	// Closed once the connection has been handled and cleaned up
+	done chan struct{}
+}
+
+func NewClient(conn net.Conn) *Client {
+	client := &Client{
+		id:            rand.Text(),
+		conn:          conn,
+		reader:        bufio.NewReader(conn),
+		connectedAt:   time.Now(),
+		role:          RoleUnknown,
+		outgoingChan:  make(chan OutgoingMessage),
+		heartbeatChan: make(chan time.Duration),
+		closeChan:     make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+
+	go client.writeLoop()
+
+	return client
+}
+
+// Blocks until the client is closed
+func (c *Client) writeLoop() {
+	var heartbeatTicker *time.Ticker
+	var heartbeatTickerChan <-chan time.Time
+
+	defer func() {
+		if heartbeatTicker != nil {
+			heartbeatTicker.Stop()
+		}
+	}()
+
+	for {
+		select {
+		case <-c.closeChan:
+			return
+
+		case interval := <-c.heartbeatChan:
+			heartbeatTicker = time.NewTicker(interval)
+			heartbeatTickerChan = heartbeatTicker.C
+
+		case <-heartbeatTickerChan:
+			heartbeat := &Heartbeat{}
+
+			if err := c.write(heartbeat.toBinary()); err != nil {
+				log.Printf("error sending heartbeat to client %s: %v", c.id, err)
+				c.Close()
+				return
+			}
+
+			heartbeatsTotal.Inc()
+
+		case msg := <-c.outgoingChan:
+			err := c.write(msg.data)
+			msg.result <- err
+
+			if err != nil {
+				c.Close()
+				return
+			}
+		}
+	}
+}
+
+func (c *Client) write(data []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(clientWriteTimeout))
+
+	_, err := c.conn.Write(data)
+	return err
+}
+
+// Send writes a message to the client and waits until it has been written
+func (c *Client) Send(data []byte) error {
+	result := make(chan error, 1)
+
+	select {
+	case c.outgoingChan <- OutgoingMessage{data: data, result: result}:
+		return <-result
+	case <-c.closeChan:
+		return net.ErrClosed
+	}
+}
+
+func (c *Client) Close() {
+	c.closeOnce.Do(func() {
+		close(c.closeChan)
+		c.conn.Close()
+	})
+}
+
+// Sends an Error message to the client. Reason is a short identifier used to
+// count errors in the metrics. The returned error is meant to be returned from
+// Run, which closes the connection.
+func (c *Client) clientError(reason string, msg string) error {
+	clientErrors.Inc(reason)
+	clientError := ClientError{msg: msg}
+
+	if err := c.Send(clientError.toBinary()); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("client error: %s", msg)
+}
+
+// Run reads messages from the client until the connection is closed or the
+// client breaks the protocol
+func (c *Client) Run(ctx context.Context, queries Repository) error {
+	for {
+		messageType, err := c.reader.ReadByte()
+
+		if err != nil {
+			return err
+		}
+
+		switch messageType {
+		case 0x80:
+			log.Println("MessageType=IamCamera")
+
+			if c.role != RoleUnknown {
+				return c.clientError("already_identified", fmt.Sprintf("already identified as %s", c.role))
+			}
+
+			camera, err := NewIamCamera(c.reader)
+
+			if err != nil {
+				return err
+			}
+
+			log.Printf("%+v\n", camera)
+
+			if c.policy != nil && !c.policy.AllowsCamera(c.certIdentity, camera.road, camera.mile) {
+				return c.clientError("policy_denied", fmt.Sprintf("%s is not allowed to be a camera at road %d mile %d", c.certIdentity, camera.road, camera.mile))
+			}
+
+			if owner, ok := c.cluster.remoteOwner(camera.road); ok {
+				if err := c.proxyCamera(owner, camera); err != nil {
+					log.Printf("error: proxying camera to node %s: %v", owner.ID, err)
+					return c.clientError("node_unavailable", fmt.Sprintf("road %d is unavailable", camera.road))
+				}
+			} else if err := camera.Register(ctx, queries, c.id, c.limitConflict); err != nil {
+				var conflict *LimitConflictError
+
+				if errors.As(err, &conflict) {
+					return c.clientError("limit_conflict", conflict.Error())
+				}
+
+				return err
+			}
+
+			c.lock.Lock()
+			c.role = RoleCamera
+			c.camera = camera
+			c.lock.Unlock()
+
+		case 0x81:
+			log.Println("MessageType=IamDispatcher")
+
+			if c.role != RoleUnknown {
+				return c.clientError("already_identified", fmt.Sprintf("already identified as %s", c.role))
+			}
+
+			dispatcher, err := NewIamDispatcher(c.reader)
+
+			if err != nil {
+				return err
+			}
+
+			log.Printf("Dispatcher %+v\n", dispatcher)
+
+			if c.policy != nil && !c.policy.AllowsDispatcher(c.certIdentity, dispatcher.roads) {
+				return c.clientError("policy_denied", fmt.Sprintf("%s is not allowed to be a dispatcher for roads %v", c.certIdentity, dispatcher.roads))
+			}
+
+			c.lock.Lock()
+			c.role = RoleDispatcher
+			c.dispatcher = dispatcher
+			c.lock.Unlock()
+
+			addDispatcherConnection(c)
+			dispatcher.Register(ctx, queries, c.id)
+			c.proxyDispatchers(queries, dispatcher.roads)
+
+		case 0x20:
+			log.Println("MessageType=Plate")
+
+			if c.role != RoleCamera {
+				return c.clientError("not_a_camera", "plate sent by a client which is not a camera")
+			}
+
+			plate, err := NewPlate(c.reader)
+
+			if err != nil {
+				return err
+			}
+
+			platesTotal.Inc()
+
+			if c.proxy != nil {
+				if err := c.proxy.SendPlate(plate.plate, plate.timestamp); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			receivedAt := time.Now()
+
+			log.Printf("%+v\n", plate)
+
+			observation_id, err := plate.RegisterObservation(ctx, queries, RegisterObservationsParams{
+				RoadID:   c.camera.road,
+				Location: c.camera.mile,
+			})
+
+			if err != nil {
+				return err
+			}
+
+			if err := submitPlateObservation(QueuedObservation{id: observation_id, receivedAt: receivedAt}); err != nil {
+				return err
+			}
+
+		case 0x40:
+			log.Println("MessageType=WantHeartbeat")
+
+			if c.isWantHeartbeat {
+				return c.clientError("multiple_heartbeats", fmt.Sprintf("Multiple heartbeats not allowed: %x", messageType))
+			}
+
+			c.isWantHeartbeat = true
+
+			heartbeat, err := NewWantHeartbeat(c.reader)
+
+			if err != nil {
+				return err
+			}
+
+			if heartbeat.interval == 0 {
+				log.Println("heartbeat interval is zero")
+				continue
+			}
+
+			select {
+			case c.heartbeatChan <- time.Duration(heartbeat.interval) * (time.Second / 10):
+			case <-c.closeChan:
+				return net.ErrClosed
+			}
+
+		default:
+			return c.clientError("unknown_message", fmt.Sprintf("unknown messageType: %x", messageType))
+		}
+	}
+}
+
+// Returns the role of the client along with its identification message
+func (c *Client) identity() (ClientRole, *IAmCamera, *IamDispatcher) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.role, c.camera, c.dispatcher
+}
+
+// Undoes the registrations made while the client was connected
+func (c *Client) cleanup(ctx context.Context, queries Repository) {
+	if c.role == RoleDispatcher {
+		removeDispatcherConnection(c.id)
+		c.dispatcher.Unregister(ctx, queries, c.id)
+	}
+}
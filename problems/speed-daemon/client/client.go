@@ -0,0 +1,549 @@
+// Package client implements the client side of the speed daemon protocol.
+//
+// A Camera reports plate observations for a single position on a road and a
+// Dispatcher receives tickets for a set of roads. Both can ask the server for
+// heartbeats. A Node returns tickets to the cluster node which owns their
+// road and claims the days of new tickets from the node which checks
+// conflicts for their plate.
+package client
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	msgError         uint8 = 0x10
+	msgPlate         uint8 = 0x20
+	msgTicket        uint8 = 0x21
+	msgClaimDays     uint8 = 0x22
+	msgDaysClaimed   uint8 = 0x23
+	msgReleaseDays   uint8 = 0x24
+	msgWantHeartbeat uint8 = 0x40
+	msgHeartbeat     uint8 = 0x41
+	msgIAmCamera     uint8 = 0x80
+	msgIAmDispatcher uint8 = 0x81
+	msgIAmNode       uint8 = 0x82
+)
+
+// Ticket is a ticket sent by the server to a dispatcher, or returned by a node
+// to the node which owns its road.
+type Ticket struct {
+	Plate      string
+	Road       uint16
+	Mile1      uint16
+	Timestamp1 uint32
+	Mile2      uint16
+	Timestamp2 uint32
+
+	// Speed is represented by 100 * miles / hour
+	Speed uint16
+}
+
+// ServerError is returned when the server sends an Error message before
+// closing the connection.
+type ServerError struct {
+	Msg string
+}
+
+func (e *ServerError) Error() string {
+	return fmt.Sprintf("server error: %s", e.Msg)
+}
+
+// Heartbeat is a heartbeat sent by the server
+type Heartbeat struct{}
+
+// TicketDays are the days from Start to End, both included, on which Plate
+// was ticketed. Days are unix timestamps divided by 86400.
+type TicketDays struct {
+	Plate string
+	Start uint32
+	End   uint32
+}
+
+// DaysClaimed answers a claim of TicketDays. Granted is false when the plate
+// was already ticketed on one of the days.
+type DaysClaimed struct {
+	Granted bool
+}
+
+// ReadMessage reads a single message sent by the server. It returns a
+// *Ticket, a *ServerError, a Heartbeat or a DaysClaimed.
+func ReadMessage(reader io.Reader) (any, error) {
+	var messageType uint8
+
+	if err := binary.Read(reader, binary.BigEndian, &messageType); err != nil {
+		return nil, err
+	}
+
+	switch messageType {
+	case msgHeartbeat:
+		return Heartbeat{}, nil
+
+	case msgTicket:
+		return ReadTicket(reader)
+
+	case msgDaysClaimed:
+		var granted uint8
+
+		if err := binary.Read(reader, binary.BigEndian, &granted); err != nil {
+			return nil, err
+		}
+
+		return DaysClaimed{Granted: granted != 0}, nil
+
+	case msgError:
+		msg, err := readString(reader)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &ServerError{Msg: msg}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown messageType: %x", messageType)
+	}
+}
+
+type session struct {
+	conn net.Conn
+
+	writeLock sync.Mutex
+
+	heartbeatChan chan time.Time
+	ticketChan    chan Ticket
+	claimChan     chan bool
+
+	done chan struct{}
+	err  error
+}
+
+func newSession(conn net.Conn, ticketChan chan Ticket, claimChan chan bool) *session {
+	s := &session{
+		conn:          conn,
+		heartbeatChan: make(chan time.Time, 1),
+		ticketChan:    ticketChan,
+		claimChan:     claimChan,
+		done:          make(chan struct{}),
+	}
+
+	go s.readLoop()
+
+	return s
+}
+
+func (s *session) write(msg []byte) error {
+	s.writeLock.Lock()
+	defer s.writeLock.Unlock()
+
+	_, err := s.conn.Write(msg)
+	return err
+}
+
+// Reads messages sent by the server until the connection is closed
+func (s *session) readLoop() {
+	defer close(s.done)
+
+	if s.ticketChan != nil {
+		defer close(s.ticketChan)
+	}
+
+	reader := bufio.NewReader(s.conn)
+
+	for {
+		message, err := ReadMessage(reader)
+
+		if err != nil {
+			s.err = err
+			return
+		}
+
+		switch message := message.(type) {
+		case Heartbeat:
+			select {
+			case s.heartbeatChan <- time.Now():
+			default:
+			}
+
+		case *Ticket:
+			if s.ticketChan == nil {
+				s.err = fmt.Errorf("unexpected ticket for non dispatcher client: %+v", message)
+				return
+			}
+
+			s.ticketChan <- *message
+
+		case DaysClaimed:
+			if s.claimChan == nil {
+				s.err = fmt.Errorf("unexpected claim answer for non node client: %+v", message)
+				return
+			}
+
+			s.claimChan <- message.Granted
+
+		case *ServerError:
+			s.err = message
+			return
+		}
+	}
+}
+
+// WantHeartbeat asks the server to send a heartbeat every interval. The
+// interval is sent with decisecond precision and zero disables heartbeats.
+func (s *session) WantHeartbeat(interval time.Duration) error {
+	msg := []byte{msgWantHeartbeat}
+	msg = binary.BigEndian.AppendUint32(msg, uint32(interval/(time.Second/10)))
+
+	return s.write(msg)
+}
+
+// Heartbeats returns a channel which receives the time at which each
+// heartbeat arrived. Heartbeats are dropped if the channel is not drained.
+func (s *session) Heartbeats() <-chan time.Time {
+	return s.heartbeatChan
+}
+
+// Done is closed once the connection to the server has ended
+func (s *session) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the reason the connection ended. It returns nil while the
+// connection is still open. A *ServerError is returned if the server reported
+// an error.
+func (s *session) Err() error {
+	select {
+	case <-s.done:
+		if errors.Is(s.err, io.EOF) || errors.Is(s.err, net.ErrClosed) {
+			return nil
+		}
+		return s.err
+	default:
+		return nil
+	}
+}
+
+func (s *session) Close() error {
+	return s.conn.Close()
+}
+
+// CloseWrite tells the server that nothing more will be sent. Messages it has
+// already sent can still be read until it closes the connection. Connections
+// which cannot be half closed are closed entirely.
+func (s *session) CloseWrite() error {
+	if conn, ok := s.conn.(interface{ CloseWrite() error }); ok {
+		return conn.CloseWrite()
+	}
+
+	return s.conn.Close()
+}
+
+type Camera struct {
+	*session
+
+	Road  uint16
+	Mile  uint16
+	Limit uint16
+}
+
+// DialCamera connects to the server at addr and identifies itself as a
+// camera at the given mile of road. Limit is in miles per hour.
+func DialCamera(addr string, road, mile, limit uint16) (*Camera, error) {
+	conn, err := net.Dial("tcp", addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	camera, err := NewCamera(conn, road, mile, limit)
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return camera, nil
+}
+
+// NewCamera identifies an already established connection as a camera
+func NewCamera(conn net.Conn, road, mile, limit uint16) (*Camera, error) {
+	msg := []byte{msgIAmCamera}
+	msg = binary.BigEndian.AppendUint16(msg, road)
+	msg = binary.BigEndian.AppendUint16(msg, mile)
+	msg = binary.BigEndian.AppendUint16(msg, limit)
+
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+
+	return &Camera{
+		session: newSession(conn, nil, nil),
+		Road:    road,
+		Mile:    mile,
+		Limit:   limit,
+	}, nil
+}
+
+// SendPlate reports that plate passed the camera at timestamp
+func (c *Camera) SendPlate(plate string, timestamp uint32) error {
+	if len(plate) > 255 {
+		return fmt.Errorf("plate is too long: %d bytes", len(plate))
+	}
+
+	msg := []byte{msgPlate, byte(len(plate))}
+	msg = append(msg, plate...)
+	msg = binary.BigEndian.AppendUint32(msg, timestamp)
+
+	return c.write(msg)
+}
+
+type Dispatcher struct {
+	*session
+
+	Roads []uint16
+}
+
+// DialDispatcher connects to the server at addr and identifies itself as a
+// dispatcher for roads.
+func DialDispatcher(addr string, roads []uint16) (*Dispatcher, error) {
+	conn, err := net.Dial("tcp", addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dispatcher, err := NewDispatcher(conn, roads)
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return dispatcher, nil
+}
+
+// NewDispatcher identifies an already established connection as a dispatcher
+func NewDispatcher(conn net.Conn, roads []uint16) (*Dispatcher, error) {
+	if len(roads) > 255 {
+		return nil, fmt.Errorf("too many roads: %d", len(roads))
+	}
+
+	msg := []byte{msgIAmDispatcher, byte(len(roads))}
+
+	for _, road := range roads {
+		msg = binary.BigEndian.AppendUint16(msg, road)
+	}
+
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+
+	return &Dispatcher{
+		session: newSession(conn, make(chan Ticket, 64), nil),
+		Roads:   roads,
+	}, nil
+}
+
+// Tickets returns the channel on which tickets are delivered. It is closed
+// once the connection ends. Reading from the server stops while the channel
+// is full, so it must be drained.
+func (d *Dispatcher) Tickets() <-chan Ticket {
+	return d.ticketChan
+}
+
+func readString(reader io.Reader) (string, error) {
+	var length uint8
+
+	if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+		return "", err
+	}
+
+	str := make([]byte, length)
+
+	if _, err := io.ReadFull(reader, str); err != nil {
+		return "", err
+	}
+
+	return string(str), nil
+}
+
+// ReadTicket reads a Ticket message whose type has already been read
+func ReadTicket(reader io.Reader) (*Ticket, error) {
+	plate, err := readString(reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	ticket := &Ticket{Plate: plate}
+
+	fields := []any{
+		&ticket.Road,
+		&ticket.Mile1,
+		&ticket.Timestamp1,
+		&ticket.Mile2,
+		&ticket.Timestamp2,
+		&ticket.Speed,
+	}
+
+	for _, field := range fields {
+		if err := binary.Read(reader, binary.BigEndian, field); err != nil {
+			return nil, err
+		}
+	}
+
+	return ticket, nil
+}
+
+// ReadIAmNode reads an IAmNode message whose type has already been read and
+// returns the id of the node
+func ReadIAmNode(reader io.Reader) (string, error) {
+	return readString(reader)
+}
+
+// ReadTicketDays reads a ClaimTicketDays or ReleaseTicketDays message whose
+// type has already been read
+func ReadTicketDays(reader io.Reader) (*TicketDays, error) {
+	plate, err := readString(reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	days := &TicketDays{Plate: plate}
+
+	if err := binary.Read(reader, binary.BigEndian, &days.Start); err != nil {
+		return nil, err
+	}
+
+	if err := binary.Read(reader, binary.BigEndian, &days.End); err != nil {
+		return nil, err
+	}
+
+	return days, nil
+}
+
+// Node is a cluster node talking to another node on its node listener. It
+// returns tickets it could not deliver to the node which owns their road, and
+// claims or releases the days of tickets with the node which checks conflicts
+// for their plate. The other node closes the connection once it has handled
+// every message sent before CloseWrite.
+type Node struct {
+	*session
+
+	// Claims are answered in order, so only one is in flight at a time
+	claimLock sync.Mutex
+}
+
+// DialNode connects to the node listener of the cluster node at addr and
+// identifies itself as the node with id
+func DialNode(addr string, id string) (*Node, error) {
+	conn, err := net.Dial("tcp", addr)
+
+	if err != nil {
+		return nil, err
+	}
+
+	node, err := NewNode(conn, id)
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return node, nil
+}
+
+// NewNode identifies an already established connection as the node with id
+func NewNode(conn net.Conn, id string) (*Node, error) {
+	if len(id) > 255 {
+		return nil, fmt.Errorf("node id is too long: %d bytes", len(id))
+	}
+
+	msg := []byte{msgIAmNode, byte(len(id))}
+	msg = append(msg, id...)
+
+	if _, err := conn.Write(msg); err != nil {
+		return nil, err
+	}
+
+	return &Node{session: newSession(conn, nil, make(chan bool))}, nil
+}
+
+// ReturnTicket sends ticket back to the node which owns its road
+func (n *Node) ReturnTicket(ticket Ticket) error {
+	if len(ticket.Plate) > 255 {
+		return fmt.Errorf("plate is too long: %d bytes", len(ticket.Plate))
+	}
+
+	msg := []byte{msgTicket, byte(len(ticket.Plate))}
+	msg = append(msg, ticket.Plate...)
+	msg = binary.BigEndian.AppendUint16(msg, ticket.Road)
+	msg = binary.BigEndian.AppendUint16(msg, ticket.Mile1)
+	msg = binary.BigEndian.AppendUint32(msg, ticket.Timestamp1)
+	msg = binary.BigEndian.AppendUint16(msg, ticket.Mile2)
+	msg = binary.BigEndian.AppendUint32(msg, ticket.Timestamp2)
+	msg = binary.BigEndian.AppendUint16(msg, ticket.Speed)
+
+	return n.write(msg)
+}
+
+// ClaimTicketDays asks the node which checks conflicts for the plate to
+// record its ticket days. It returns false when the plate was already
+// ticketed on one of them.
+func (n *Node) ClaimTicketDays(days TicketDays) (bool, error) {
+	n.claimLock.Lock()
+	defer n.claimLock.Unlock()
+
+	msg, err := ticketDaysMessage(msgClaimDays, days)
+
+	if err != nil {
+		return false, err
+	}
+
+	if err := n.write(msg); err != nil {
+		return false, err
+	}
+
+	select {
+	case granted := <-n.claimChan:
+		return granted, nil
+	case <-n.done:
+		if err := n.Err(); err != nil {
+			return false, err
+		}
+
+		return false, io.ErrUnexpectedEOF
+	}
+}
+
+// ReleaseTicketDays gives back days claimed by a ticket which was voided
+func (n *Node) ReleaseTicketDays(days TicketDays) error {
+	msg, err := ticketDaysMessage(msgReleaseDays, days)
+
+	if err != nil {
+		return err
+	}
+
+	return n.write(msg)
+}
+
+func ticketDaysMessage(messageType uint8, days TicketDays) ([]byte, error) {
+	if len(days.Plate) > 255 {
+		return nil, fmt.Errorf("plate is too long: %d bytes", len(days.Plate))
+	}
+
+	msg := []byte{messageType, byte(len(days.Plate))}
+	msg = append(msg, days.Plate...)
+	msg = binary.BigEndian.AppendUint32(msg, days.Start)
+	msg = binary.BigEndian.AppendUint32(msg, days.End)
+
+	return msg, nil
+}
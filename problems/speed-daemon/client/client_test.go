@@ -0,0 +1,346 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Ticket message of the protocol's example, without its type
+var ticketBody = []byte{
+	0x04, 'U', 'N', '1', 'X',
+	0x00, 0x42,
+	0x00, 0x64,
+	0x00, 0x01, 0xe2, 0x40,
+	0x00, 0x6e,
+	0x00, 0x01, 0xe3, 0xa8,
+	0x27, 0x10,
+}
+
+var exampleTicket = Ticket{Plate: "UN1X", Road: 66, Mile1: 100, Timestamp1: 123456, Mile2: 110, Timestamp2: 123816, Speed: 10000}
+
+// Connects a client to serve, which runs as the server side of an in process
+// pipe. The pipe is closed and serve waited for once the test ends
+func pipe(t *testing.T, serve func(server net.Conn)) net.Conn {
+	t.Helper()
+
+	client, server := net.Pipe()
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		defer server.Close()
+
+		serve(server)
+	}()
+
+	t.Cleanup(func() {
+		client.Close()
+		<-done
+	})
+
+	return client
+}
+
+// Reads len(want) bytes sent by the client and reports whether they are want
+func expect(t *testing.T, server net.Conn, want []byte) bool {
+	got := make([]byte, len(want))
+
+	if _, err := io.ReadFull(server, got); err != nil {
+		t.Errorf("reading %d bytes: %v", len(want), err)
+		return false
+	}
+
+	if !bytes.Equal(got, want) {
+		t.Errorf("client sent % x, want % x", got, want)
+		return false
+	}
+
+	return true
+}
+
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+func TestEncodeMessages(t *testing.T) {
+	tests := []struct {
+		name string
+		send func(conn net.Conn) error
+		want []byte
+	}{
+		{
+			name: "camera",
+			send: func(conn net.Conn) error {
+				_, err := NewCamera(conn, 123, 8, 60)
+				return err
+			},
+			want: []byte{0x80, 0x00, 0x7b, 0x00, 0x08, 0x00, 0x3c},
+		},
+		{
+			name: "plate",
+			send: func(conn net.Conn) error {
+				camera, err := NewCamera(conn, 123, 8, 60)
+
+				if err != nil {
+					return err
+				}
+
+				return camera.SendPlate("UN1X", 1000)
+			},
+			want: join(
+				[]byte{0x80, 0x00, 0x7b, 0x00, 0x08, 0x00, 0x3c},
+				[]byte{0x20, 0x04, 'U', 'N', '1', 'X', 0x00, 0x00, 0x03, 0xe8},
+			),
+		},
+		{
+			name: "dispatcher",
+			send: func(conn net.Conn) error {
+				_, err := NewDispatcher(conn, []uint16{66, 368, 5000})
+				return err
+			},
+			want: []byte{0x81, 0x03, 0x00, 0x42, 0x01, 0x70, 0x13, 0x88},
+		},
+		{
+			name: "want heartbeat",
+			send: func(conn net.Conn) error {
+				dispatcher, err := NewDispatcher(conn, []uint16{66})
+
+				if err != nil {
+					return err
+				}
+
+				return dispatcher.WantHeartbeat(2500 * time.Millisecond)
+			},
+			want: join(
+				[]byte{0x81, 0x01, 0x00, 0x42},
+				[]byte{0x40, 0x00, 0x00, 0x00, 0x19},
+			),
+		},
+		{
+			name: "node",
+			send: func(conn net.Conn) error {
+				node, err := NewNode(conn, "a")
+
+				if err != nil {
+					return err
+				}
+
+				if err := node.ReturnTicket(exampleTicket); err != nil {
+					return err
+				}
+
+				return node.ReleaseTicketDays(TicketDays{Plate: "UN1X", Start: 1, End: 2})
+			},
+			want: join(
+				[]byte{0x82, 0x01, 'a'},
+				[]byte{0x21}, ticketBody,
+				[]byte{0x24, 0x04, 'U', 'N', '1', 'X', 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02},
+			),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn := pipe(t, func(server net.Conn) {
+				expect(t, server, test.want)
+			})
+
+			if err := test.send(conn); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestEncodeTooLongStrings(t *testing.T) {
+	conn := pipe(t, func(server net.Conn) {
+		expect(t, server, []byte{0x80, 0x00, 0x01, 0x00, 0x02, 0x00, 0x03})
+	})
+
+	camera, err := NewCamera(conn, 1, 2, 3)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := camera.SendPlate(string(make([]byte, 256)), 0); err == nil {
+		t.Error("SendPlate() of a 256 byte plate succeeded")
+	}
+
+	if _, err := NewDispatcher(conn, make([]uint16, 256)); err == nil {
+		t.Error("NewDispatcher() of 256 roads succeeded")
+	}
+}
+
+func TestReadMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		message []byte
+		want    any
+		wantErr bool
+	}{
+		{name: "heartbeat", message: []byte{0x41}, want: Heartbeat{}},
+		{name: "ticket", message: join([]byte{0x21}, ticketBody), want: &exampleTicket},
+		{name: "granted", message: []byte{0x23, 0x01}, want: DaysClaimed{Granted: true}},
+		{name: "not granted", message: []byte{0x23, 0x00}, want: DaysClaimed{Granted: false}},
+		{name: "error", message: []byte{0x10, 0x03, 'b', 'a', 'd'}, want: &ServerError{Msg: "bad"}},
+		{name: "unknown type", message: []byte{0x99}, wantErr: true},
+		{name: "truncated ticket", message: join([]byte{0x21}, ticketBody[:10]), wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ReadMessage(bytes.NewReader(test.message))
+
+			if (err != nil) != test.wantErr {
+				t.Fatalf("ReadMessage() error = %v, want error %t", err, test.wantErr)
+			}
+
+			if !test.wantErr && !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ReadMessage() = %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDispatcherReceivesTicketsAndHeartbeats(t *testing.T) {
+	conn := pipe(t, func(server net.Conn) {
+		if !expect(t, server, []byte{0x81, 0x01, 0x00, 0x42}) {
+			return
+		}
+
+		if _, err := server.Write(join([]byte{0x21}, ticketBody, []byte{0x41})); err != nil {
+			t.Error(err)
+		}
+	})
+
+	dispatcher, err := NewDispatcher(conn, []uint16{66})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ticket, ok := <-dispatcher.Tickets()
+
+	if !ok || ticket != exampleTicket {
+		t.Fatalf("ticket = %+v, %t, want %+v", ticket, ok, exampleTicket)
+	}
+
+	select {
+	case <-dispatcher.Heartbeats():
+	case <-time.After(time.Second):
+		t.Fatal("no heartbeat")
+	}
+
+	if _, ok := <-dispatcher.Tickets(); ok {
+		t.Fatal("tickets are not closed once the server closed the connection")
+	}
+
+	<-dispatcher.Done()
+
+	if err := dispatcher.Err(); err != nil {
+		t.Errorf("Err() = %v, want nil once the server closed the connection", err)
+	}
+}
+
+func TestServerError(t *testing.T) {
+	conn := pipe(t, func(server net.Conn) {
+		if !expect(t, server, []byte{0x80, 0x00, 0x01, 0x00, 0x02, 0x00, 0x03}) {
+			return
+		}
+
+		if _, err := server.Write([]byte{0x10, 0x0b, 'i', 'l', 'l', 'e', 'g', 'a', 'l', ' ', 'm', 's', 'g'}); err != nil {
+			t.Error(err)
+		}
+	})
+
+	camera, err := NewCamera(conn, 1, 2, 3)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-camera.Done()
+
+	var serverErr *ServerError
+
+	if err := camera.Err(); !errors.As(err, &serverErr) || serverErr.Msg != "illegal msg" {
+		t.Errorf("Err() = %v, want server error \"illegal msg\"", err)
+	}
+}
+
+func TestCameraRejectsTickets(t *testing.T) {
+	conn := pipe(t, func(server net.Conn) {
+		if !expect(t, server, []byte{0x80, 0x00, 0x01, 0x00, 0x02, 0x00, 0x03}) {
+			return
+		}
+
+		if _, err := server.Write(join([]byte{0x21}, ticketBody)); err != nil {
+			t.Error(err)
+		}
+	})
+
+	camera, err := NewCamera(conn, 1, 2, 3)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-camera.Done()
+
+	if err := camera.Err(); err == nil {
+		t.Error("Err() = nil after a camera was sent a ticket")
+	}
+}
+
+func TestNodeClaimTicketDays(t *testing.T) {
+	claim := []byte{0x22, 0x04, 'U', 'N', '1', 'X', 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x02}
+
+	conn := pipe(t, func(server net.Conn) {
+		if !expect(t, server, []byte{0x82, 0x01, 'a'}) {
+			return
+		}
+
+		for _, granted := range []byte{0x01, 0x00} {
+			if !expect(t, server, claim) {
+				return
+			}
+
+			if _, err := server.Write([]byte{0x23, granted}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	})
+
+	node, err := NewNode(conn, "a")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	days := TicketDays{Plate: "UN1X", Start: 1, End: 2}
+
+	for _, want := range []bool{true, false} {
+		granted, err := node.ClaimTicketDays(days)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if granted != want {
+			t.Errorf("ClaimTicketDays() = %t, want %t", granted, want)
+		}
+	}
+
+	<-node.Done()
+
+	if _, err := node.ClaimTicketDays(days); err == nil {
+		t.Error("ClaimTicketDays() succeeded after the server closed the connection")
+	}
+}
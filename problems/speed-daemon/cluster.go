@@ -0,0 +1,698 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nivekithan/go-network/problems/speed-daemon/client"
+	"github.com/nivekithan/go-network/problems/speed-daemon/db"
+)
+
+type ClusterNode struct {
+	ID string `json:"id"`
+
+	// Address of the speed daemon protocol listener of the node
+	Addr string `json:"addr"`
+
+	// Address of the listener for other nodes. Connections to it are only
+	// accepted from the addresses its host resolves to in the config of
+	// every other node, so it must name the host the node connects from
+	NodeAddr string `json:"node_addr"`
+
+	// Roads owned by the node
+	Roads []uint16 `json:"roads"`
+}
+
+// Cluster splits roads between several nodes. Every road has a single owner
+// which registers its cameras and generates its tickets. Nodes talk to each
+// other with the speed daemon protocol itself: cameras for a road owned by
+// another node are proxied to it, and every dispatcher gets a proxy
+// dispatcher on the owners of its roads which stores the tickets it receives
+// locally.
+//
+// Nodes also listen for each other on a separate node listener. A node
+// which cannot deliver a ticket returns it there to the owner of its road,
+// and every new ticket first claims its days from the node which checks
+// conflicts for its plate, so a plate speeding on roads of several nodes is
+// still ticketed once a day.
+//
+//	{
+//	  "nodes": [
+//	    {"id": "a", "addr": "localhost:8001", "node_addr": "localhost:9001", "roads": [1, 2]},
+//	    {"id": "b", "addr": "localhost:8002", "node_addr": "localhost:9002", "roads": [3]}
+//	  ]
+//	}
+//
+// Roads which no node lists are owned by nodes[road % len(nodes)].
+type Cluster struct {
+	Nodes []ClusterNode `json:"nodes"`
+
+	// Id of this node
+	self string
+}
+
+func LoadCluster(path string, nodeId string) (*Cluster, error) {
+	content, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	cluster := &Cluster{self: nodeId}
+
+	if err := json.Unmarshal(content, cluster); err != nil {
+		return nil, fmt.Errorf("parsing cluster %s: %w", path, err)
+	}
+
+	ids := []string{}
+	owners := map[uint16]string{}
+
+	for _, node := range cluster.Nodes {
+		if slices.Contains(ids, node.ID) {
+			return nil, fmt.Errorf("cluster %s: duplicate node %q", path, node.ID)
+		}
+
+		ids = append(ids, node.ID)
+
+		if host, _, err := net.SplitHostPort(node.NodeAddr); err != nil || host == "" {
+			return nil, fmt.Errorf("cluster %s: node %q needs a node_addr with a host", path, node.ID)
+		}
+
+		for _, road := range node.Roads {
+			if owner, ok := owners[road]; ok {
+				return nil, fmt.Errorf("cluster %s: road %d is owned by both %q and %q", path, road, owner, node.ID)
+			}
+
+			owners[road] = node.ID
+		}
+	}
+
+	if !slices.Contains(ids, nodeId) {
+		return nil, fmt.Errorf("cluster %s: unknown node %q", path, nodeId)
+	}
+
+	return cluster, nil
+}
+
+func (c *Cluster) node(id string) *ClusterNode {
+	for i, node := range c.Nodes {
+		if node.ID == id {
+			return &c.Nodes[i]
+		}
+	}
+
+	return nil
+}
+
+// The node which checks conflicting tickets of plate for the whole cluster
+func (c *Cluster) authority(plate string) *ClusterNode {
+	hash := fnv.New32a()
+	hash.Write([]byte(plate))
+
+	return &c.Nodes[hash.Sum32()%uint32(len(c.Nodes))]
+}
+
+// Whether remoteAddr is one of the addresses the host of the node listener
+// of node resolves to
+func (c *Cluster) isFrom(node *ClusterNode, remoteAddr net.Addr) bool {
+	tcpAddr, ok := remoteAddr.(*net.TCPAddr)
+
+	if !ok {
+		return false
+	}
+
+	host, _, err := net.SplitHostPort(node.NodeAddr)
+
+	if err != nil {
+		return false
+	}
+
+	ips, err := net.LookupIP(host)
+
+	if err != nil {
+		log.Printf("error: resolving node %s: %v", node.ID, err)
+		return false
+	}
+
+	for _, ip := range ips {
+		if ip.Equal(tcpAddr.IP) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Cluster) dialNode(node *ClusterNode) (*client.Node, error) {
+	return client.DialNode(node.NodeAddr, c.self)
+}
+
+func (c *Cluster) owner(road uint16) *ClusterNode {
+	for i, node := range c.Nodes {
+		if slices.Contains(node.Roads, road) {
+			return &c.Nodes[i]
+		}
+	}
+
+	return &c.Nodes[int(road)%len(c.Nodes)]
+}
+
+// Returns the node which owns road if it is not this node. A nil cluster owns
+// every road locally
+func (c *Cluster) remoteOwner(road uint16) (*ClusterNode, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	owner := c.owner(road)
+
+	if owner.ID == c.self {
+		return nil, false
+	}
+
+	return owner, true
+}
+
+// Groups the roads owned by other nodes by their owner
+func (c *Cluster) remoteRoads(roads []uint16) map[*ClusterNode][]uint16 {
+	grouped := map[*ClusterNode][]uint16{}
+
+	for _, road := range roads {
+		if owner, ok := c.remoteOwner(road); ok {
+			grouped[owner] = append(grouped[owner], road)
+		}
+	}
+
+	return grouped
+}
+
+// Forwards the plates of a camera on a road owned by another node. The owner
+// registers the camera, so an Error it sends is passed on to the camera.
+func (c *Client) proxyCamera(owner *ClusterNode, camera *IAmCamera) error {
+	proxy, err := client.DialCamera(owner.Addr, camera.road, camera.mile, camera.limit)
+
+	if err != nil {
+		return err
+	}
+
+	c.proxy = proxy
+
+	go func() {
+		select {
+		case <-proxy.Done():
+			var serverError *client.ServerError
+
+			if errors.As(proxy.Err(), &serverError) {
+				c.clientError("node_error", serverError.Msg)
+			}
+
+			c.Close()
+
+		case <-c.closeChan:
+			proxy.Close()
+		}
+	}()
+
+	return nil
+}
+
+// Receives the tickets of the roads of a dispatcher which are owned by other
+// nodes for as long as the dispatcher is connected
+func (c *Client) proxyDispatchers(queries Repository, roads []uint16) {
+	for owner, ownerRoads := range c.cluster.remoteRoads(roads) {
+		go proxyDispatcher(queries, owner, ownerRoads, c.closeChan)
+	}
+}
+
+// Connects to owner as a dispatcher for roads and stores the tickets it sends
+// as pending tickets of this node, until done is closed. Reconnects when the
+// connection to the owner ends.
+func proxyDispatcher(queries Repository, owner *ClusterNode, roads []uint16, done <-chan struct{}) {
+	for {
+		dispatcher, err := client.DialDispatcher(owner.Addr, roads)
+
+		if err != nil {
+			log.Printf("error: connecting to node %s for roads %v. Retrying in %v: %v", owner.ID, roads, ticketRetryInterval, err)
+
+			select {
+			case <-done:
+				return
+			case <-time.After(ticketRetryInterval):
+				continue
+			}
+		}
+
+		log.Printf("Receiving tickets for roads %v from node %s", roads, owner.ID)
+
+	receive:
+		for {
+			select {
+			case <-done:
+				drainProxyDispatcher(queries, owner, dispatcher)
+				return
+
+			case ticket, ok := <-dispatcher.Tickets():
+				if !ok {
+					break receive
+				}
+
+				storeForwardedTicket(queries, owner, ticket)
+			}
+		}
+
+		log.Printf("error: connection to node %s for roads %v ended. Reconnecting: %v", owner.ID, roads, dispatcher.Err())
+	}
+}
+
+// Ends a proxy dispatcher without losing the tickets owner has already
+// written to it, since owner considers them delivered. Owner unregisters the
+// proxy and closes the connection once it reads the end of the stream, and
+// every ticket received until then is stored as pending on this node.
+func drainProxyDispatcher(queries Repository, owner *ClusterNode, dispatcher *client.Dispatcher) {
+	if err := dispatcher.CloseWrite(); err != nil {
+		log.Printf("error: closing proxy dispatcher of node %s: %v", owner.ID, err)
+	}
+
+	timeout := time.After(ticketRetryInterval)
+
+	for {
+		select {
+		case ticket, ok := <-dispatcher.Tickets():
+			if !ok {
+				return
+			}
+
+			storeForwardedTicket(queries, owner, ticket)
+
+		case <-timeout:
+			log.Printf("error: node %s did not close the proxy dispatcher in %v", owner.ID, ticketRetryInterval)
+			dispatcher.Close()
+		}
+	}
+}
+
+// Stores a ticket generated by another node so that it is delivered to the
+// dispatchers of this node. Storing is retried until it succeeds, since the
+// owner already considers the ticket delivered.
+func storeForwardedTicket(queries Repository, owner *ClusterNode, ticket client.Ticket) {
+	ctx := context.Background()
+
+	for {
+		ticketId, err := queries.StoreTicket(ctx, db.StoreTicketParams{
+			PlateNumber:   ticket.Plate,
+			RoadID:        int64(ticket.Road),
+			Mile1:         int64(ticket.Mile1),
+			Mile2:         int64(ticket.Mile2),
+			Timestamp1:    int64(ticket.Timestamp1),
+			Timestamp2:    int64(ticket.Timestamp2),
+			Speed:         int64(ticket.Speed),
+			DayStartRange: int64(ticket.Timestamp1 / 86400),
+			DayEndRange:   int64(ticket.Timestamp2 / 86400),
+			IsProcessed:   0,
+			CreatedAt:     time.Now().UnixMilli(),
+		})
+
+		if err != nil {
+			log.Printf("error: storing ticket %+v forwarded by node %s. Retrying in %v: %v", ticket, owner.ID, ticketRetryInterval, err)
+			time.Sleep(ticketRetryInterval)
+			continue
+		}
+
+		ticketsForwarded.Inc()
+		recordTicketEvent(ctx, queries, ticketId, TicketEventCreated, "", fmt.Sprintf("forwarded by node %s", owner.ID))
+
+		notifyPendingTickets(int64(ticket.Road))
+		return
+	}
+}
+
+// Sends tickets which owner forwarded to this node back to it, once no
+// dispatcher of this node is left for their road. Owner queues them again for
+// its other dispatchers. They are only marked as processed here once owner
+// has read all of them, so a failure returns them again on the next retry.
+func returnTicketsToOwner(ctx context.Context, queries Repository, cluster *Cluster, owner *ClusterNode, tickets []db.Ticket) error {
+	node, err := cluster.dialNode(owner)
+
+	if err != nil {
+		return fmt.Errorf("returning tickets to node %s: %w", owner.ID, err)
+	}
+
+	defer node.Close()
+
+	for _, ticket := range tickets {
+		if err := node.ReturnTicket(client.Ticket{
+			Plate:      ticket.PlateNumber,
+			Road:       uint16(ticket.RoadID),
+			Mile1:      uint16(ticket.Mile1),
+			Timestamp1: uint32(ticket.Timestamp1),
+			Mile2:      uint16(ticket.Mile2),
+			Timestamp2: uint32(ticket.Timestamp2),
+			Speed:      uint16(ticket.Speed),
+		}); err != nil {
+			return fmt.Errorf("returning tickets to node %s: %w", owner.ID, err)
+		}
+	}
+
+	if err := node.CloseWrite(); err != nil {
+		return fmt.Errorf("returning tickets to node %s: %w", owner.ID, err)
+	}
+
+	select {
+	case <-node.Done():
+	case <-time.After(ticketRetryInterval):
+		return fmt.Errorf("node %s did not confirm %d returned tickets in %v", owner.ID, len(tickets), ticketRetryInterval)
+	}
+
+	if err := node.Err(); err != nil {
+		return fmt.Errorf("returning tickets to node %s: %w", owner.ID, err)
+	}
+
+	for _, ticket := range tickets {
+		if err := queries.MarkTicketAsProcessed(ctx, ticket.ID); err != nil {
+			return fmt.Errorf("marking ticket %v as processed: %w", ticket.ID, err)
+		}
+
+		ticketsReturned.Inc()
+		recordTicketEvent(ctx, queries, ticket.ID, TicketEventReturned, "", fmt.Sprintf("returned to node %s", owner.ID))
+	}
+
+	log.Printf("Returned %d tickets to node %s", len(tickets), owner.ID)
+
+	return nil
+}
+
+// Queues again a ticket which a node could not deliver. The ticket was
+// generated by this node, so the stored ticket is requeued instead of being
+// stored twice. Voided tickets stay voided. A ticket which is not known here
+// anymore, such as after a restart with an in memory database, is stored as
+// a new pending ticket.
+func requeueReturnedTicket(ctx context.Context, queries Repository, ticket client.Ticket) error {
+	tickets, err := queries.GetTicketsForPlate(ctx, ticket.Plate)
+
+	if err != nil {
+		return err
+	}
+
+	for _, stored := range tickets {
+		if stored.RoadID != int64(ticket.Road) || stored.Mile1 != int64(ticket.Mile1) || stored.Timestamp1 != int64(ticket.Timestamp1) ||
+			stored.Mile2 != int64(ticket.Mile2) || stored.Timestamp2 != int64(ticket.Timestamp2) {
+			continue
+		}
+
+		if stored.Voided != 0 {
+			return nil
+		}
+
+		ticketDeliveryLock.Lock()
+		_, err := queries.RequeueTicket(ctx, stored.ID)
+		ticketDeliveryLock.Unlock()
+
+		if err != nil {
+			return err
+		}
+
+		recordTicketEvent(ctx, queries, stored.ID, TicketEventRequeued, "", "returned by a node which could not deliver it")
+		notifyPendingTickets(stored.RoadID)
+
+		return nil
+	}
+
+	ticketId, err := queries.StoreTicket(ctx, db.StoreTicketParams{
+		PlateNumber:   ticket.Plate,
+		RoadID:        int64(ticket.Road),
+		Mile1:         int64(ticket.Mile1),
+		Mile2:         int64(ticket.Mile2),
+		Timestamp1:    int64(ticket.Timestamp1),
+		Timestamp2:    int64(ticket.Timestamp2),
+		Speed:         int64(ticket.Speed),
+		DayStartRange: int64(ticket.Timestamp1 / 86400),
+		DayEndRange:   int64(ticket.Timestamp2 / 86400),
+		IsProcessed:   0,
+		CreatedAt:     time.Now().UnixMilli(),
+	})
+
+	if err != nil {
+		return err
+	}
+
+	recordTicketEvent(ctx, queries, ticketId, TicketEventCreated, "", "returned by a node which could not deliver it")
+	notifyPendingTickets(int64(ticket.Road))
+
+	return nil
+}
+
+// Serializes checking and recording claims, so two nodes never both get the
+// same day of a plate
+var ticketClaimLock sync.Mutex
+
+// Records the days of a ticket of this node or of the node with nodeId,
+// unless the plate was already ticketed on one of them
+func claimTicketDays(ctx context.Context, queries Repository, nodeId string, days client.TicketDays) (bool, error) {
+	ticketClaimLock.Lock()
+	defer ticketClaimLock.Unlock()
+
+	conflict, err := queries.ConflictingTicketClaim(ctx, db.ConflictingTicketClaimParams{
+		PlateNumber: days.Plate,
+		StartDate:   int64(days.Start),
+		EndDate:     int64(days.End),
+	})
+
+	if err == nil {
+		log.Printf("Plate %s was already ticketed by node %s on days %d to %d", days.Plate, conflict.NodeID, conflict.DayStartRange, conflict.DayEndRange)
+		return false, nil
+	}
+
+	if !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+
+	if err := queries.InsertTicketClaim(ctx, db.InsertTicketClaimParams{
+		PlateNumber:   days.Plate,
+		DayStartRange: int64(days.Start),
+		DayEndRange:   int64(days.End),
+		NodeID:        nodeId,
+	}); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Claims the days of a new ticket from the authority of its plate. A nil
+// cluster grants every claim, since the local conflict check covers it.
+// Claiming is retried until the authority answers, as ticketing without an
+// answer could ticket the plate twice on a day.
+func (c *Cluster) claimTicketDays(ctx context.Context, queries Repository, days client.TicketDays) bool {
+	if c == nil {
+		return true
+	}
+
+	authority := c.authority(days.Plate)
+
+	for {
+		var granted bool
+		var err error
+
+		if authority.ID == c.self {
+			granted, err = claimTicketDays(ctx, queries, c.self, days)
+		} else {
+			granted, err = c.claimRemoteTicketDays(authority, days)
+		}
+
+		if err == nil {
+			return granted
+		}
+
+		log.Printf("error: claiming days %d to %d of plate %s from node %s. Retrying in %v: %v", days.Start, days.End, days.Plate, authority.ID, ticketRetryInterval, err)
+		time.Sleep(ticketRetryInterval)
+	}
+}
+
+func (c *Cluster) claimRemoteTicketDays(authority *ClusterNode, days client.TicketDays) (bool, error) {
+	node, err := c.dialNode(authority)
+
+	if err != nil {
+		return false, err
+	}
+
+	defer node.Close()
+
+	return node.ClaimTicketDays(days)
+}
+
+// Gives back the days of a voided ticket of this node, so that they no longer
+// conflict with tickets of other nodes
+func (c *Cluster) releaseTicketDays(ctx context.Context, queries Repository, days client.TicketDays) error {
+	authority := c.authority(days.Plate)
+
+	if authority.ID == c.self {
+		return releaseTicketDays(ctx, queries, days)
+	}
+
+	node, err := c.dialNode(authority)
+
+	if err != nil {
+		return fmt.Errorf("releasing days of plate %s on node %s: %w", days.Plate, authority.ID, err)
+	}
+
+	defer node.Close()
+
+	if err := node.ReleaseTicketDays(days); err != nil {
+		return fmt.Errorf("releasing days of plate %s on node %s: %w", days.Plate, authority.ID, err)
+	}
+
+	if err := node.CloseWrite(); err != nil {
+		return fmt.Errorf("releasing days of plate %s on node %s: %w", days.Plate, authority.ID, err)
+	}
+
+	select {
+	case <-node.Done():
+	case <-time.After(ticketRetryInterval):
+		return fmt.Errorf("node %s did not confirm releasing days of plate %s in %v", authority.ID, days.Plate, ticketRetryInterval)
+	}
+
+	return node.Err()
+}
+
+func releaseTicketDays(ctx context.Context, queries Repository, days client.TicketDays) error {
+	ticketClaimLock.Lock()
+	defer ticketClaimLock.Unlock()
+
+	_, err := queries.DeleteTicketClaim(ctx, db.DeleteTicketClaimParams{
+		PlateNumber:   days.Plate,
+		DayStartRange: int64(days.Start),
+		DayEndRange:   int64(days.End),
+	})
+
+	return err
+}
+
+// Serves a connection on the node listener. Nothing but messages between
+// nodes is accepted there
+func handleNodeConnection(queries Repository, conn net.Conn, config *Config) {
+	ctx := context.Background()
+
+	connectionsTotal.Inc()
+	client := NewClient(conn)
+	client.cluster = config.cluster
+
+	defer close(client.done)
+
+	addClient(client)
+	defer removeClient(client)
+
+	defer client.Close()
+	defer log.Println("Closing node connection")
+
+	if err := client.RunNode(ctx, queries); err != nil {
+		log.Println("error: ", err)
+	}
+}
+
+// RunNode reads messages from another node until the connection is closed.
+// The node has to identify itself first, from an address its node listener
+// resolves to.
+func (c *Client) RunNode(ctx context.Context, queries Repository) error {
+	for {
+		messageType, err := c.reader.ReadByte()
+
+		if err != nil {
+			return err
+		}
+
+		if messageType != 0x82 && c.role != RoleNode {
+			return c.clientError("not_a_node", "message sent by a client which is not a cluster node")
+		}
+
+		switch messageType {
+		case 0x82:
+			log.Println("MessageType=IAmNode")
+
+			if c.role != RoleUnknown {
+				return c.clientError("already_identified", fmt.Sprintf("already identified as %s", c.role))
+			}
+
+			id, err := client.ReadIAmNode(c.reader)
+
+			if err != nil {
+				return err
+			}
+
+			node := c.cluster.node(id)
+
+			if node == nil || node.ID == c.cluster.self || !c.cluster.isFrom(node, c.conn.RemoteAddr()) {
+				return c.clientError("not_a_node", fmt.Sprintf("%s is not node %q of the cluster", c.conn.RemoteAddr(), id))
+			}
+
+			c.lock.Lock()
+			c.role = RoleNode
+			c.nodeId = id
+			c.lock.Unlock()
+
+		case 0x21:
+			log.Println("MessageType=Ticket")
+
+			ticket, err := client.ReadTicket(c.reader)
+
+			if err != nil {
+				return err
+			}
+
+			if _, ok := c.cluster.remoteOwner(ticket.Road); ok {
+				return c.clientError("not_owner", fmt.Sprintf("road %d is owned by another node", ticket.Road))
+			}
+
+			if err := requeueReturnedTicket(ctx, queries, *ticket); err != nil {
+				return err
+			}
+
+		case 0x22, 0x24:
+			days, err := client.ReadTicketDays(c.reader)
+
+			if err != nil {
+				return err
+			}
+
+			if c.cluster.authority(days.Plate).ID != c.cluster.self {
+				return c.clientError("not_authority", fmt.Sprintf("conflicts of plate %s are checked by another node", days.Plate))
+			}
+
+			if messageType == 0x24 {
+				log.Println("MessageType=ReleaseTicketDays")
+
+				if err := releaseTicketDays(ctx, queries, *days); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			log.Println("MessageType=ClaimTicketDays")
+
+			granted, err := claimTicketDays(ctx, queries, c.nodeId, *days)
+
+			if err != nil {
+				return err
+			}
+
+			claimed := DaysClaimed{granted: granted}
+
+			if err := c.Send(claimed.toBinary()); err != nil {
+				return err
+			}
+
+		default:
+			return c.clientError("unknown_message", fmt.Sprintf("unknown messageType: %x", messageType))
+		}
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nivekithan/go-network/problems/speed-daemon/client"
+)
+
+// Nodes keep their state in package variables, so every node of a test
+// cluster runs as its own process
+func buildServer(t *testing.T) string {
+	t.Helper()
+
+	if testing.Short() {
+		t.Skip("starts several server processes")
+	}
+
+	bin := filepath.Join(t.TempDir(), "speed-daemon")
+
+	if output, err := exec.Command("go", "build", "-o", bin, ".").CombinedOutput(); err != nil {
+		t.Fatalf("building the server: %v\n%s", err, output)
+	}
+
+	return bin
+}
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer listener.Close()
+
+	return listener.Addr().String()
+}
+
+// Starts a node for every id on 127.0.0.1, the first of which owns road 1,
+// the second road 2 and so on, and returns the nodes by id
+func startCluster(t *testing.T, ids ...string) map[string]ClusterNode {
+	t.Helper()
+
+	bin := buildServer(t)
+	dir := t.TempDir()
+
+	cluster := Cluster{}
+	nodes := map[string]ClusterNode{}
+
+	for i, id := range ids {
+		nodes[id] = ClusterNode{ID: id, Addr: freeAddr(t), NodeAddr: freeAddr(t), Roads: []uint16{uint16(i + 1)}}
+		cluster.Nodes = append(cluster.Nodes, nodes[id])
+	}
+
+	content, err := json.Marshal(cluster)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clusterPath := filepath.Join(dir, "cluster.json")
+
+	if err := os.WriteFile(clusterPath, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, id := range ids {
+		logFile, err := os.Create(filepath.Join(dir, id+".log"))
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		cmd := exec.Command(bin, "-addr", nodes[id].Addr, "-cluster", clusterPath, "-node", id, "-storage", StorageMemory)
+		cmd.Stdout = logFile
+		cmd.Stderr = logFile
+
+		if err := cmd.Start(); err != nil {
+			t.Fatal(err)
+		}
+
+		t.Cleanup(func() {
+			cmd.Process.Kill()
+			cmd.Wait()
+			logFile.Close()
+
+			if t.Failed() {
+				log, _ := os.ReadFile(logFile.Name())
+				t.Logf("log of node %s:\n%s", id, log)
+			}
+		})
+	}
+
+	for _, node := range nodes {
+		waitForListener(t, node.Addr)
+		waitForListener(t, node.NodeAddr)
+	}
+
+	return nodes
+}
+
+func waitForListener(t *testing.T, addr string) {
+	t.Helper()
+
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			return
+		}
+	}
+
+	t.Fatalf("node at %s did not start", addr)
+}
+
+// Reports plate at 120 mph on road, where the limit is 60
+func speed(t *testing.T, addr string, road uint16, plate string) {
+	t.Helper()
+
+	for _, observation := range []struct {
+		mile      uint16
+		timestamp uint32
+	}{{mile: 0, timestamp: 0}, {mile: 10, timestamp: 300}} {
+		camera, err := client.DialCamera(addr, road, observation.mile, 60)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer camera.Close()
+
+		if err := camera.SendPlate(plate, observation.timestamp); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Waits for the ticket speed sent for plate on road 1
+func waitForTicket(t *testing.T, dispatcher *client.Dispatcher, plate string) {
+	t.Helper()
+
+	select {
+	case ticket, ok := <-dispatcher.Tickets():
+		if !ok {
+			t.Fatalf("dispatcher disconnected: %v", dispatcher.Err())
+		}
+
+		want := client.Ticket{Plate: plate, Road: 1, Mile1: 0, Timestamp1: 0, Mile2: 10, Timestamp2: 300, Speed: 12000}
+
+		if ticket != want {
+			t.Fatalf("got ticket %+v, want %+v", ticket, want)
+		}
+
+	case <-time.After(15 * time.Second):
+		t.Fatalf("no ticket for %s", plate)
+	}
+}
+
+func TestClusterDeliversToDispatcherOfOtherNode(t *testing.T) {
+	nodes := startCluster(t, "a", "b", "c")
+
+	dispatcher, err := client.DialDispatcher(nodes["c"].Addr, []uint16{1})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer dispatcher.Close()
+
+	// Cameras on the owner and on a node which proxies them to the owner
+	for i, node := range []string{"a", "b"} {
+		plate := fmt.Sprintf("CLUSTER%d", i)
+		speed(t, nodes[node].Addr, 1, plate)
+		waitForTicket(t, dispatcher, plate)
+	}
+}
+
+func TestClusterReturnsTicketsWhenDispatcherLeaves(t *testing.T) {
+	nodes := startCluster(t, "a", "b", "c")
+
+	leaving, err := client.DialDispatcher(nodes["c"].Addr, []uint16{1})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Gives node c time to connect its proxy dispatcher to the owner
+	time.Sleep(200 * time.Millisecond)
+	leaving.Close()
+
+	speed(t, nodes["b"].Addr, 1, "RETURNED")
+
+	dispatcher, err := client.DialDispatcher(nodes["b"].Addr, []uint16{1})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer dispatcher.Close()
+
+	waitForTicket(t, dispatcher, "RETURNED")
+}
+
+func TestClusterOwnerRequeuesReturnedTickets(t *testing.T) {
+	nodes := startCluster(t, "a", "b")
+
+	dispatcher, err := client.DialDispatcher(nodes["a"].Addr, []uint16{1})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer dispatcher.Close()
+
+	speed(t, nodes["a"].Addr, 1, "REQUEUED")
+	waitForTicket(t, dispatcher, "REQUEUED")
+
+	// Node b returns the ticket to addr
+	returnTicket := func(addr string, ticket client.Ticket) error {
+		node, err := client.DialNode(addr, "b")
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		defer node.Close()
+
+		if err := node.ReturnTicket(ticket); err != nil {
+			t.Fatal(err)
+		}
+
+		node.CloseWrite()
+		<-node.Done()
+
+		return node.Err()
+	}
+
+	ticket := client.Ticket{Plate: "REQUEUED", Road: 1, Mile1: 0, Timestamp1: 0, Mile2: 10, Timestamp2: 300, Speed: 12000}
+
+	if err := returnTicket(nodes["a"].NodeAddr, ticket); err != nil {
+		t.Fatalf("returning ticket to its owner: %v", err)
+	}
+
+	waitForTicket(t, dispatcher, "REQUEUED")
+
+	// Road 1 is not owned by node b, which does not take tickets from itself
+	if err := returnTicket(nodes["b"].NodeAddr, ticket); err == nil {
+		t.Fatal("node b accepted a ticket of a road it does not own")
+	}
+}
+
+func TestClusterOnlyTakesTicketsFromNodes(t *testing.T) {
+	nodes := startCluster(t, "a", "b")
+
+	ticket := client.Ticket{Plate: "FORGED", Road: 1, Mile1: 0, Timestamp1: 0, Mile2: 10, Timestamp2: 300, Speed: 12000}
+
+	tests := []struct {
+		name string
+		addr string
+		id   string
+	}{
+		{name: "client listener", addr: nodes["a"].Addr, id: "b"},
+		{name: "unknown node", addr: nodes["a"].NodeAddr, id: "z"},
+		{name: "node itself", addr: nodes["a"].NodeAddr, id: "a"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			node, err := client.DialNode(test.addr, test.id)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			defer node.Close()
+
+			node.ReturnTicket(ticket)
+			node.CloseWrite()
+			<-node.Done()
+
+			var serverError *client.ServerError
+
+			if !errors.As(node.Err(), &serverError) {
+				t.Fatalf("ticket was not refused: %v", node.Err())
+			}
+		})
+	}
+
+	dispatcher, err := client.DialDispatcher(nodes["a"].Addr, []uint16{1})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer dispatcher.Close()
+
+	select {
+	case ticket := <-dispatcher.Tickets():
+		t.Fatalf("forged ticket %+v was delivered", ticket)
+	case <-time.After(time.Second):
+	}
+}
+
+// Every plate speeds on the same day on road 1 of node a and road 2 of node
+// b. Whichever node checks conflicts for a plate, it is ticketed once
+func TestClusterTicketsPlateOnceAcrossNodes(t *testing.T) {
+	nodes := startCluster(t, "a", "b")
+
+	dispatcher, err := client.DialDispatcher(nodes["a"].Addr, []uint16{1, 2})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer dispatcher.Close()
+
+	// Gives node a time to connect its proxy dispatcher to node b
+	time.Sleep(200 * time.Millisecond)
+
+	plates := []string{"TWICE0", "TWICE1", "TWICE2", "TWICE3", "TWICE4", "TWICE5"}
+
+	for _, plate := range plates {
+		speed(t, nodes["a"].Addr, 1, plate)
+		speed(t, nodes["b"].Addr, 2, plate)
+	}
+
+	tickets := map[string]int{}
+	timeout := time.After(15 * time.Second)
+
+	// Waits a little longer once every plate is ticketed for tickets which
+	// should not exist
+	for settled := (<-chan time.Time)(nil); ; {
+		select {
+		case ticket, ok := <-dispatcher.Tickets():
+			if !ok {
+				t.Fatalf("dispatcher disconnected: %v", dispatcher.Err())
+			}
+
+			tickets[ticket.Plate]++
+
+			if len(tickets) == len(plates) && settled == nil {
+				settled = time.After(2 * time.Second)
+			}
+
+			continue
+
+		case <-settled:
+		case <-timeout:
+		}
+
+		break
+	}
+
+	for _, plate := range plates {
+		if tickets[plate] != 1 {
+			t.Errorf("plate %s got %d tickets, want 1", plate, tickets[plate])
+		}
+	}
+}
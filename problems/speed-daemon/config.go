@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"time"
+)
+
+type Config struct {
+	// Address of the speed daemon protocol listener
+	addr string
+
+	// Address of the admin HTTP API. Disabled when empty
+	adminAddr string
+
+	// Address of the Prometheus metrics endpoint. Disabled when empty
+	metricsAddr string
+
+	// Where roads, observations and tickets are stored: sqlite or memory
+	storage string
+
+	// Path of the SQLite database. Kept in memory when empty
+	dbPath string
+
+	// Directory in which a session file is written for every connection.
+	// Recording is disabled when empty
+	recordDir string
+
+	// Address of the TLS listener. Clients must present a certificate signed
+	// by tlsClientCA. Disabled when empty
+	tlsAddr     string
+	tlsCert     string
+	tlsKey      string
+	tlsClientCA string
+
+	// Path of the JSON policy which restricts the registrations of TLS
+	// clients. Every registration is allowed when empty
+	policyPath string
+
+	// How cameras declaring a different speed limit than the one of their
+	// road are handled: reject, first or min
+	limitConflict string
+
+	// Loaded from policyPath
+	policy *Policy
+
+	// Path of the JSON ticket rules. The default rules are used when empty
+	rulesPath string
+
+	// Loaded from rulesPath
+	rules *Rules
+
+	// Observations this much older than the newest observation are deleted.
+	// Measured in camera timestamps. Observations are kept forever when zero
+	retention time.Duration
+
+	// How often the retention policy is applied
+	compactionInterval time.Duration
+
+	// Path of the JSON cluster config. The node owns every road when empty
+	clusterPath string
+
+	// Id of this node in the cluster config
+	nodeId string
+
+	// Loaded from clusterPath
+	cluster *Cluster
+
+	// How long queued observations and pending tickets are processed for
+	// after a shutdown signal
+	shutdownTimeout time.Duration
+}
+
+func parseFlags() *Config {
+	config := &Config{}
+
+	flag.StringVar(&config.addr, "addr", ":8000", "address to listen for cameras and dispatchers. Disabled when empty")
+	flag.StringVar(&config.adminAddr, "admin", "", "address of the admin HTTP API. Disabled when empty")
+	flag.StringVar(&config.metricsAddr, "metrics", "", "address to serve Prometheus metrics on /metrics. Disabled when empty")
+	flag.StringVar(&config.storage, "storage", StorageSQLite, "where roads, observations and tickets are stored: sqlite or memory")
+	flag.StringVar(&config.dbPath, "db", "", "path of the SQLite database file. Kept in memory when empty")
+	flag.StringVar(&config.recordDir, "record", "", "directory to record every connection into. Disabled when empty")
+
+	flag.StringVar(&config.tlsAddr, "tls-addr", "", "address to listen for cameras and dispatchers over TLS. Disabled when empty")
+	flag.StringVar(&config.tlsCert, "tls-cert", "", "path of the PEM encoded server certificate")
+	flag.StringVar(&config.tlsKey, "tls-key", "", "path of the PEM encoded server private key")
+	flag.StringVar(&config.tlsClientCA, "tls-client-ca", "", "path of the PEM encoded CA which signs client certificates")
+	flag.StringVar(&config.policyPath, "policy", "", "path of the JSON policy for TLS clients. Requires -addr \"\" and cannot be combined with -cluster. Every registration is allowed when empty")
+	flag.StringVar(&config.limitConflict, "limit-conflict", LimitConflictFirst, "how cameras declaring a different speed limit than their road are handled: reject, first or min")
+	flag.StringVar(&config.rulesPath, "rules", "", "path of the JSON ticket rules. Tickets speeds of at least limit + 0.5 mph when empty")
+	flag.DurationVar(&config.retention, "retention", 0, "delete observations this much older than the newest one of their plate on their road, in camera time. Kept forever when 0")
+	flag.DurationVar(&config.compactionInterval, "compaction-interval", time.Minute, "how often old observations are deleted")
+	flag.StringVar(&config.clusterPath, "cluster", "", "path of the JSON cluster config which splits roads between nodes. Disabled when empty")
+	flag.StringVar(&config.nodeId, "node", "", "id of this node in the cluster config")
+	flag.DurationVar(&config.shutdownTimeout, "shutdown-timeout", 10*time.Second, "how long to drain observations and deliver pending tickets on SIGINT or SIGTERM")
+
+	flag.Parse()
+
+	return config
+}
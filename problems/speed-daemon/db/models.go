@@ -4,6 +4,17 @@
 
 package db
 
+type CameraLimit struct {
+	ID             int64
+	RoadID         int64
+	Mile           int64
+	SpeedLimit     int64
+	RoadSpeedLimit int64
+	ClientID       string
+	Conflict       int64
+	CreatedAt      int64
+}
+
 type Dispatcher struct {
 	ID           int64
 	RoadID       int64
@@ -24,15 +35,37 @@ type Road struct {
 }
 
 type Ticket struct {
+	ID             int64
+	PlateNumber    string
+	RoadID         int64
+	Mile1          int64
+	Timestamp1     int64
+	Mile2          int64
+	Timestamp2     int64
+	Speed          int64
+	DayStartRange  int64
+	DayEndRange    int64
+	IsProcessed    int64
+	Voided         int64
+	ObservationID1 int64
+	ObservationID2 int64
+	CreatedAt      int64
+	Severity       string
+}
+
+type TicketClaim struct {
 	ID            int64
 	PlateNumber   string
-	RoadID        int64
-	Mile1         int64
-	Timestamp1    int64
-	Mile2         int64
-	Timestamp2    int64
-	Speed         int64
 	DayStartRange int64
 	DayEndRange   int64
-	IsProcessed   int64
+	NodeID        string
+}
+
+type TicketEvent struct {
+	ID           int64
+	TicketID     int64
+	Event        string
+	CreatedAt    int64
+	DispatcherID string
+	Detail       string
 }
@@ -23,8 +23,8 @@ func (q *Queries) AddDispatcherForRoad(ctx context.Context, arg AddDispatcherFor
 	return err
 }
 
-const conflictingTickets = `-- name: ConflictingTickets :one
-SELECT id, plate_number, road_id, mile_1, timestamp_1, mile_2, timestamp_2, speed, day_start_range, day_end_range, is_processed FROM ticket WHERE
+const conflictingTicketClaim = `-- name: ConflictingTicketClaim :one
+SELECT id, plate_number, day_start_range, day_end_range, node_id FROM ticket_claim WHERE
     plate_number = ?1 AND
     (
     (?2 >= day_start_range AND ?2 <= day_end_range ) OR
@@ -32,6 +32,34 @@ SELECT id, plate_number, road_id, mile_1, timestamp_1, mile_2, timestamp_2, spee
     ) LIMIT 1
 `
 
+type ConflictingTicketClaimParams struct {
+	PlateNumber string
+	StartDate   int64
+	EndDate     int64
+}
+
+func (q *Queries) ConflictingTicketClaim(ctx context.Context, arg ConflictingTicketClaimParams) (TicketClaim, error) {
+	row := q.db.QueryRowContext(ctx, conflictingTicketClaim, arg.PlateNumber, arg.StartDate, arg.EndDate)
+	var i TicketClaim
+	err := row.Scan(
+		&i.ID,
+		&i.PlateNumber,
+		&i.DayStartRange,
+		&i.DayEndRange,
+		&i.NodeID,
+	)
+	return i, err
+}
+
+const conflictingTickets = `-- name: ConflictingTickets :one
+SELECT id, plate_number, road_id, mile_1, timestamp_1, mile_2, timestamp_2, speed, day_start_range, day_end_range, is_processed, voided, observation_id_1, observation_id_2, created_at, severity FROM ticket WHERE
+    plate_number = ?1 AND
+    (
+    (?2 >= day_start_range AND ?2 <= day_end_range ) OR
+    (?3  >= day_start_range AND ?3 <= day_end_range)
+    ) AND voided = 0 LIMIT 1
+`
+
 type ConflictingTicketsParams struct {
 	PlateNumber string
 	StartDate   int64
@@ -53,19 +81,156 @@ func (q *Queries) ConflictingTickets(ctx context.Context, arg ConflictingTickets
 		&i.DayStartRange,
 		&i.DayEndRange,
 		&i.IsProcessed,
+		&i.Voided,
+		&i.ObservationID1,
+		&i.ObservationID2,
+		&i.CreatedAt,
+		&i.Severity,
 	)
 	return i, err
 }
 
-const findDispatcherForRoad = `-- name: FindDispatcherForRoad :one
-SELECT dispatcher_id FROM dispatcher WHERE road_id = ?1 LIMIT 1
+const countLimitConflicts = `-- name: CountLimitConflicts :one
+SELECT COUNT(*) FROM camera_limit WHERE conflict = 1
+`
+
+func (q *Queries) CountLimitConflicts(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countLimitConflicts)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const countPendingTickets = `-- name: CountPendingTickets :one
+SELECT COUNT(*) FROM ticket WHERE is_processed = 0 AND voided = 0
+`
+
+func (q *Queries) CountPendingTickets(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countPendingTickets)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
+const deleteExpiredObservations = `-- name: DeleteExpiredObservations :execrows
+DELETE FROM plate_observation WHERE timestamp < (
+    SELECT MAX(latest.timestamp) FROM plate_observation AS latest WHERE
+        latest.plate_number = plate_observation.plate_number AND
+        latest.road_id = plate_observation.road_id
+) - CAST(?1 AS INTEGER)
+`
+
+func (q *Queries) DeleteExpiredObservations(ctx context.Context, retention int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteExpiredObservations, retention)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const deleteTicketClaim = `-- name: DeleteTicketClaim :execrows
+DELETE FROM ticket_claim WHERE id = (
+    SELECT id FROM ticket_claim WHERE
+        plate_number = ?1 AND
+        day_start_range = ?2 AND
+        day_end_range = ?3
+    ORDER BY id LIMIT 1
+)
+`
+
+type DeleteTicketClaimParams struct {
+	PlateNumber   string
+	DayStartRange int64
+	DayEndRange   int64
+}
+
+func (q *Queries) DeleteTicketClaim(ctx context.Context, arg DeleteTicketClaimParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, deleteTicketClaim, arg.PlateNumber, arg.DayStartRange, arg.DayEndRange)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const findDispatchersForRoad = `-- name: FindDispatchersForRoad :many
+SELECT dispatcher_id FROM dispatcher WHERE road_id = ?1 ORDER BY id
+`
+
+func (q *Queries) FindDispatchersForRoad(ctx context.Context, roadID int64) ([]string, error) {
+	rows, err := q.db.QueryContext(ctx, findDispatchersForRoad, roadID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []string
+	for rows.Next() {
+		var dispatcher_id string
+		if err := rows.Scan(&dispatcher_id); err != nil {
+			return nil, err
+		}
+		items = append(items, dispatcher_id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getLatestPlateObservationTimestamp = `-- name: GetLatestPlateObservationTimestamp :one
+SELECT CAST(COALESCE(MAX(timestamp), 0) AS INTEGER) AS latest_timestamp FROM plate_observation WHERE
+    plate_number = ?1 AND
+    road_id = ?2
+`
+
+type GetLatestPlateObservationTimestampParams struct {
+	PlateNumber string
+	RoadID      int64
+}
+
+func (q *Queries) GetLatestPlateObservationTimestamp(ctx context.Context, arg GetLatestPlateObservationTimestampParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, getLatestPlateObservationTimestamp, arg.PlateNumber, arg.RoadID)
+	var latest_timestamp int64
+	err := row.Scan(&latest_timestamp)
+	return latest_timestamp, err
+}
+
+const getLimitConflicts = `-- name: GetLimitConflicts :many
+SELECT id, road_id, mile, speed_limit, road_speed_limit, client_id, conflict, created_at FROM camera_limit WHERE conflict = 1 ORDER BY id DESC LIMIT ?1
 `
 
-func (q *Queries) FindDispatcherForRoad(ctx context.Context, roadID int64) (string, error) {
-	row := q.db.QueryRowContext(ctx, findDispatcherForRoad, roadID)
-	var dispatcher_id string
-	err := row.Scan(&dispatcher_id)
-	return dispatcher_id, err
+func (q *Queries) GetLimitConflicts(ctx context.Context, limit int64) ([]CameraLimit, error) {
+	rows, err := q.db.QueryContext(ctx, getLimitConflicts, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CameraLimit
+	for rows.Next() {
+		var i CameraLimit
+		if err := rows.Scan(
+			&i.ID,
+			&i.RoadID,
+			&i.Mile,
+			&i.SpeedLimit,
+			&i.RoadSpeedLimit,
+			&i.ClientID,
+			&i.Conflict,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
 }
 
 const getNextObservation = `-- name: GetNextObservation :one
@@ -112,6 +277,39 @@ func (q *Queries) GetObservationById(ctx context.Context, id int64) (PlateObserv
 	return i, err
 }
 
+const getObservationsForPlate = `-- name: GetObservationsForPlate :many
+SELECT id, plate_number, timestamp, location, road_id FROM plate_observation WHERE plate_number = ?1 ORDER BY road_id, timestamp
+`
+
+func (q *Queries) GetObservationsForPlate(ctx context.Context, plateNumber string) ([]PlateObservation, error) {
+	rows, err := q.db.QueryContext(ctx, getObservationsForPlate, plateNumber)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []PlateObservation
+	for rows.Next() {
+		var i PlateObservation
+		if err := rows.Scan(
+			&i.ID,
+			&i.PlateNumber,
+			&i.Timestamp,
+			&i.Location,
+			&i.RoadID,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getPreviousObservation = `-- name: GetPreviousObservation :one
 SELECT id, plate_number, timestamp, location, road_id FROM plate_observation WHERE
     plate_number = ?1 AND
@@ -150,8 +348,114 @@ func (q *Queries) GetRoad(ctx context.Context, id int64) (Road, error) {
 	return i, err
 }
 
+const getTicket = `-- name: GetTicket :one
+SELECT id, plate_number, road_id, mile_1, timestamp_1, mile_2, timestamp_2, speed, day_start_range, day_end_range, is_processed, voided, observation_id_1, observation_id_2, created_at, severity FROM ticket WHERE id = ?1
+`
+
+func (q *Queries) GetTicket(ctx context.Context, id int64) (Ticket, error) {
+	row := q.db.QueryRowContext(ctx, getTicket, id)
+	var i Ticket
+	err := row.Scan(
+		&i.ID,
+		&i.PlateNumber,
+		&i.RoadID,
+		&i.Mile1,
+		&i.Timestamp1,
+		&i.Mile2,
+		&i.Timestamp2,
+		&i.Speed,
+		&i.DayStartRange,
+		&i.DayEndRange,
+		&i.IsProcessed,
+		&i.Voided,
+		&i.ObservationID1,
+		&i.ObservationID2,
+		&i.CreatedAt,
+		&i.Severity,
+	)
+	return i, err
+}
+
+const getTicketEvents = `-- name: GetTicketEvents :many
+SELECT id, ticket_id, event, created_at, dispatcher_id, detail FROM ticket_event WHERE ticket_id = ?1 ORDER BY id
+`
+
+func (q *Queries) GetTicketEvents(ctx context.Context, ticketID int64) ([]TicketEvent, error) {
+	rows, err := q.db.QueryContext(ctx, getTicketEvents, ticketID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []TicketEvent
+	for rows.Next() {
+		var i TicketEvent
+		if err := rows.Scan(
+			&i.ID,
+			&i.TicketID,
+			&i.Event,
+			&i.CreatedAt,
+			&i.DispatcherID,
+			&i.Detail,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getTicketsForPlate = `-- name: GetTicketsForPlate :many
+SELECT id, plate_number, road_id, mile_1, timestamp_1, mile_2, timestamp_2, speed, day_start_range, day_end_range, is_processed, voided, observation_id_1, observation_id_2, created_at, severity FROM ticket WHERE plate_number = ?1 ORDER BY id
+`
+
+func (q *Queries) GetTicketsForPlate(ctx context.Context, plateNumber string) ([]Ticket, error) {
+	rows, err := q.db.QueryContext(ctx, getTicketsForPlate, plateNumber)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Ticket
+	for rows.Next() {
+		var i Ticket
+		if err := rows.Scan(
+			&i.ID,
+			&i.PlateNumber,
+			&i.RoadID,
+			&i.Mile1,
+			&i.Timestamp1,
+			&i.Mile2,
+			&i.Timestamp2,
+			&i.Speed,
+			&i.DayStartRange,
+			&i.DayEndRange,
+			&i.IsProcessed,
+			&i.Voided,
+			&i.ObservationID1,
+			&i.ObservationID2,
+			&i.CreatedAt,
+			&i.Severity,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getUnProcessedTickets = `-- name: GetUnProcessedTickets :many
-SELECT id, plate_number, road_id, mile_1, timestamp_1, mile_2, timestamp_2, speed, day_start_range, day_end_range, is_processed FROM ticket WHERE is_processed = 0
+SELECT id, plate_number, road_id, mile_1, timestamp_1, mile_2, timestamp_2, speed, day_start_range, day_end_range, is_processed, voided, observation_id_1, observation_id_2, created_at, severity FROM ticket WHERE is_processed = 0 AND voided = 0
 `
 
 func (q *Queries) GetUnProcessedTickets(ctx context.Context) ([]Ticket, error) {
@@ -175,6 +479,11 @@ func (q *Queries) GetUnProcessedTickets(ctx context.Context) ([]Ticket, error) {
 			&i.DayStartRange,
 			&i.DayEndRange,
 			&i.IsProcessed,
+			&i.Voided,
+			&i.ObservationID1,
+			&i.ObservationID2,
+			&i.CreatedAt,
+			&i.Severity,
 		); err != nil {
 			return nil, err
 		}
@@ -189,6 +498,79 @@ func (q *Queries) GetUnProcessedTickets(ctx context.Context) ([]Ticket, error) {
 	return items, nil
 }
 
+const getUnProcessedTicketsForRoad = `-- name: GetUnProcessedTicketsForRoad :many
+SELECT id, plate_number, road_id, mile_1, timestamp_1, mile_2, timestamp_2, speed, day_start_range, day_end_range, is_processed, voided, observation_id_1, observation_id_2, created_at, severity FROM ticket WHERE is_processed = 0 AND voided = 0 AND road_id = ?1 ORDER BY id
+`
+
+func (q *Queries) GetUnProcessedTicketsForRoad(ctx context.Context, roadID int64) ([]Ticket, error) {
+	rows, err := q.db.QueryContext(ctx, getUnProcessedTicketsForRoad, roadID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Ticket
+	for rows.Next() {
+		var i Ticket
+		if err := rows.Scan(
+			&i.ID,
+			&i.PlateNumber,
+			&i.RoadID,
+			&i.Mile1,
+			&i.Timestamp1,
+			&i.Mile2,
+			&i.Timestamp2,
+			&i.Speed,
+			&i.DayStartRange,
+			&i.DayEndRange,
+			&i.IsProcessed,
+			&i.Voided,
+			&i.ObservationID1,
+			&i.ObservationID2,
+			&i.CreatedAt,
+			&i.Severity,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const insertCameraLimit = `-- name: InsertCameraLimit :exec
+INSERT INTO camera_limit
+    (road_id, mile, speed_limit, road_speed_limit, client_id, conflict, created_at) VALUES
+    (?1, ?2, ?3, ?4, ?5, ?6, ?7)
+`
+
+type InsertCameraLimitParams struct {
+	RoadID         int64
+	Mile           int64
+	SpeedLimit     int64
+	RoadSpeedLimit int64
+	ClientID       string
+	Conflict       int64
+	CreatedAt      int64
+}
+
+func (q *Queries) InsertCameraLimit(ctx context.Context, arg InsertCameraLimitParams) error {
+	_, err := q.db.ExecContext(ctx, insertCameraLimit,
+		arg.RoadID,
+		arg.Mile,
+		arg.SpeedLimit,
+		arg.RoadSpeedLimit,
+		arg.ClientID,
+		arg.Conflict,
+		arg.CreatedAt,
+	)
+	return err
+}
+
 const insertPlateObservation = `-- name: InsertPlateObservation :one
 INSERT INTO plate_observation
     (plate_number, road_id, timestamp, location) VALUES
@@ -215,8 +597,8 @@ func (q *Queries) InsertPlateObservation(ctx context.Context, arg InsertPlateObs
 	return id, err
 }
 
-const insertRoad = `-- name: InsertRoad :exec
-INSERT INTO road (id, speed_limit) VALUES (?1, ?2)
+const insertRoad = `-- name: InsertRoad :execrows
+INSERT INTO road (id, speed_limit) VALUES (?1, ?2) ON CONFLICT (id) DO NOTHING
 `
 
 type InsertRoadParams struct {
@@ -224,8 +606,195 @@ type InsertRoadParams struct {
 	SpeedLimit int64
 }
 
-func (q *Queries) InsertRoad(ctx context.Context, arg InsertRoadParams) error {
-	_, err := q.db.ExecContext(ctx, insertRoad, arg.ID, arg.SpeedLimit)
+func (q *Queries) InsertRoad(ctx context.Context, arg InsertRoadParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, insertRoad, arg.ID, arg.SpeedLimit)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const insertTicketClaim = `-- name: InsertTicketClaim :exec
+INSERT INTO ticket_claim (plate_number, day_start_range, day_end_range, node_id)
+VALUES (?1, ?2, ?3, ?4)
+`
+
+type InsertTicketClaimParams struct {
+	PlateNumber   string
+	DayStartRange int64
+	DayEndRange   int64
+	NodeID        string
+}
+
+func (q *Queries) InsertTicketClaim(ctx context.Context, arg InsertTicketClaimParams) error {
+	_, err := q.db.ExecContext(ctx, insertTicketClaim,
+		arg.PlateNumber,
+		arg.DayStartRange,
+		arg.DayEndRange,
+		arg.NodeID,
+	)
+	return err
+}
+
+const insertTicketEvent = `-- name: InsertTicketEvent :exec
+INSERT INTO ticket_event
+    (ticket_id, event, created_at, dispatcher_id, detail)
+VALUES (?1, ?2, ?3, ?4, ?5)
+`
+
+type InsertTicketEventParams struct {
+	TicketID     int64
+	Event        string
+	CreatedAt    int64
+	DispatcherID string
+	Detail       string
+}
+
+func (q *Queries) InsertTicketEvent(ctx context.Context, arg InsertTicketEventParams) error {
+	_, err := q.db.ExecContext(ctx, insertTicketEvent,
+		arg.TicketID,
+		arg.Event,
+		arg.CreatedAt,
+		arg.DispatcherID,
+		arg.Detail,
+	)
+	return err
+}
+
+const listRoads = `-- name: ListRoads :many
+SELECT id, speed_limit FROM road ORDER BY id
+`
+
+func (q *Queries) ListRoads(ctx context.Context) ([]Road, error) {
+	rows, err := q.db.QueryContext(ctx, listRoads)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Road
+	for rows.Next() {
+		var i Road
+		if err := rows.Scan(&i.ID, &i.SpeedLimit); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTickets = `-- name: ListTickets :many
+SELECT id, plate_number, road_id, mile_1, timestamp_1, mile_2, timestamp_2, speed, day_start_range, day_end_range, is_processed, voided, observation_id_1, observation_id_2, created_at, severity FROM ticket ORDER BY id
+`
+
+func (q *Queries) ListTickets(ctx context.Context) ([]Ticket, error) {
+	rows, err := q.db.QueryContext(ctx, listTickets)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Ticket
+	for rows.Next() {
+		var i Ticket
+		if err := rows.Scan(
+			&i.ID,
+			&i.PlateNumber,
+			&i.RoadID,
+			&i.Mile1,
+			&i.Timestamp1,
+			&i.Mile2,
+			&i.Timestamp2,
+			&i.Speed,
+			&i.DayStartRange,
+			&i.DayEndRange,
+			&i.IsProcessed,
+			&i.Voided,
+			&i.ObservationID1,
+			&i.ObservationID2,
+			&i.CreatedAt,
+			&i.Severity,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listTicketsInTimeRange = `-- name: ListTicketsInTimeRange :many
+SELECT id, plate_number, road_id, mile_1, timestamp_1, mile_2, timestamp_2, speed, day_start_range, day_end_range, is_processed, voided, observation_id_1, observation_id_2, created_at, severity FROM ticket WHERE
+    timestamp_1 >= ?1 AND
+    timestamp_1 <= ?2
+ORDER BY timestamp_1, id
+`
+
+type ListTicketsInTimeRangeParams struct {
+	MinTimestamp int64
+	MaxTimestamp int64
+}
+
+func (q *Queries) ListTicketsInTimeRange(ctx context.Context, arg ListTicketsInTimeRangeParams) ([]Ticket, error) {
+	rows, err := q.db.QueryContext(ctx, listTicketsInTimeRange, arg.MinTimestamp, arg.MaxTimestamp)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Ticket
+	for rows.Next() {
+		var i Ticket
+		if err := rows.Scan(
+			&i.ID,
+			&i.PlateNumber,
+			&i.RoadID,
+			&i.Mile1,
+			&i.Timestamp1,
+			&i.Mile2,
+			&i.Timestamp2,
+			&i.Speed,
+			&i.DayStartRange,
+			&i.DayEndRange,
+			&i.IsProcessed,
+			&i.Voided,
+			&i.ObservationID1,
+			&i.ObservationID2,
+			&i.CreatedAt,
+			&i.Severity,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const lowerRoadSpeedLimit = `-- name: LowerRoadSpeedLimit :exec
+UPDATE road SET speed_limit = ?1 WHERE id = ?2 AND speed_limit > ?1
+`
+
+type LowerRoadSpeedLimitParams struct {
+	SpeedLimit int64
+	ID         int64
+}
+
+func (q *Queries) LowerRoadSpeedLimit(ctx context.Context, arg LowerRoadSpeedLimitParams) error {
+	_, err := q.db.ExecContext(ctx, lowerRoadSpeedLimit, arg.SpeedLimit, arg.ID)
 	return err
 }
 
@@ -238,29 +807,64 @@ func (q *Queries) MarkTicketAsProcessed(ctx context.Context, id int64) error {
 	return err
 }
 
-const storeTicket = `-- name: StoreTicket :exec
+const removeAllDispatchers = `-- name: RemoveAllDispatchers :exec
+DELETE FROM dispatcher
+`
+
+func (q *Queries) RemoveAllDispatchers(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, removeAllDispatchers)
+	return err
+}
+
+const removeDispatcher = `-- name: RemoveDispatcher :exec
+DELETE FROM dispatcher WHERE dispatcher_id = ?1
+`
+
+func (q *Queries) RemoveDispatcher(ctx context.Context, dispatcherID string) error {
+	_, err := q.db.ExecContext(ctx, removeDispatcher, dispatcherID)
+	return err
+}
+
+const requeueTicket = `-- name: RequeueTicket :execrows
+UPDATE ticket SET is_processed = 0, voided = 0 WHERE id = ?1
+`
+
+func (q *Queries) RequeueTicket(ctx context.Context, id int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, requeueTicket, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const storeTicket = `-- name: StoreTicket :one
 INSERT INTO ticket
-    (plate_number, road_id, mile_1, timestamp_1, mile_2, timestamp_2, speed, day_start_range, day_end_range, is_processed)
+    (plate_number, road_id, mile_1, timestamp_1, mile_2, timestamp_2, speed, day_start_range, day_end_range, is_processed, observation_id_1, observation_id_2, created_at, severity)
 VALUES (
-    ?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10
+    ?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11, ?12, ?13, ?14
 )
+RETURNING id
 `
 
 type StoreTicketParams struct {
-	PlateNumber   string
-	RoadID        int64
-	Mile1         int64
-	Timestamp1    int64
-	Mile2         int64
-	Timestamp2    int64
-	Speed         int64
-	DayStartRange int64
-	DayEndRange   int64
-	IsProcessed   int64
+	PlateNumber    string
+	RoadID         int64
+	Mile1          int64
+	Timestamp1     int64
+	Mile2          int64
+	Timestamp2     int64
+	Speed          int64
+	DayStartRange  int64
+	DayEndRange    int64
+	IsProcessed    int64
+	ObservationID1 int64
+	ObservationID2 int64
+	CreatedAt      int64
+	Severity       string
 }
 
-func (q *Queries) StoreTicket(ctx context.Context, arg StoreTicketParams) error {
-	_, err := q.db.ExecContext(ctx, storeTicket,
+func (q *Queries) StoreTicket(ctx context.Context, arg StoreTicketParams) (int64, error) {
+	row := q.db.QueryRowContext(ctx, storeTicket,
 		arg.PlateNumber,
 		arg.RoadID,
 		arg.Mile1,
@@ -271,6 +875,24 @@ func (q *Queries) StoreTicket(ctx context.Context, arg StoreTicketParams) error
 		arg.DayStartRange,
 		arg.DayEndRange,
 		arg.IsProcessed,
+		arg.ObservationID1,
+		arg.ObservationID2,
+		arg.CreatedAt,
+		arg.Severity,
 	)
-	return err
+	var id int64
+	err := row.Scan(&id)
+	return id, err
+}
+
+const voidTicket = `-- name: VoidTicket :execrows
+UPDATE ticket SET voided = 1 WHERE id = ?1
+`
+
+func (q *Queries) VoidTicket(ctx context.Context, id int64) (int64, error) {
+	result, err := q.db.ExecContext(ctx, voidTicket, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+)
+
+var dispatcherConnMap map[string]*Client
+
+// Index of the dispatcher which should receive the next ticket of a road
+var dispatcherNextIndex map[int64]int
+var dispatcherConnLock sync.Mutex
+
+var errNoDispatcher = errors.New("no dispatcher for road")
+
+func addDispatcherConnection(client *Client) {
+	dispatcherConnLock.Lock()
+	defer dispatcherConnLock.Unlock()
+
+	dispatcherConnMap[client.id] = client
+}
+
+func removeDispatcherConnection(dispatcher string) {
+	dispatcherConnLock.Lock()
+	defer dispatcherConnLock.Unlock()
+
+	delete(dispatcherConnMap, dispatcher)
+}
+
+// Picks the live dispatchers of a road in round robin order. Returns
+// errNoDispatcher if none of the road's dispatchers is connected.
+func nextDispatcherForRoad(ctx context.Context, queries Repository, roadId int64) (*Client, error) {
+	dispatcherIds, err := queries.FindDispatchersForRoad(ctx, roadId)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dispatcherConnLock.Lock()
+	defer dispatcherConnLock.Unlock()
+
+	start := dispatcherNextIndex[roadId]
+
+	for i := range dispatcherIds {
+		index := (start + i) % len(dispatcherIds)
+		dispatcherId := dispatcherIds[index]
+
+		client, ok := dispatcherConnMap[dispatcherId]
+
+		if !ok {
+			continue
+		}
+
+		dispatcherNextIndex[roadId] = index + 1
+
+		return client, nil
+	}
+
+	return nil, errNoDispatcher
+}
+
+// Forgets a dispatcher whose connection is broken and closes it. Its
+// connection handler unregisters it again on exit, which is harmless.
+func dropDispatcher(ctx context.Context, queries Repository, client *Client) {
+	removeDispatcherConnection(client.id)
+	client.Close()
+
+	if err := queries.RemoveDispatcher(ctx, client.id); err != nil {
+		log.Printf("Failed to remove dispatcher %s: %v", client.id, err)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/nivekithan/go-network/problems/speed-daemon/db"
+)
+
+type ExportedTicket struct {
+	ID             int64     `json:"id"`
+	Plate          string    `json:"plate"`
+	Road           int64     `json:"road"`
+	Mile1          int64     `json:"mile1"`
+	Timestamp1     int64     `json:"timestamp1"`
+	Mile2          int64     `json:"mile2"`
+	Timestamp2     int64     `json:"timestamp2"`
+	Speed          float64   `json:"speed"`
+	Severity       string    `json:"severity"`
+	Status         string    `json:"status"`
+	ObservationID1 int64     `json:"observation_id1"`
+	ObservationID2 int64     `json:"observation_id2"`
+	CreatedAt      time.Time `json:"created_at"`
+
+	// Last successful delivery. Zero if the ticket was never delivered
+	DeliveredAt      time.Time             `json:"delivered_at,omitzero"`
+	DispatcherID     string                `json:"dispatcher_id,omitempty"`
+	DeliveryFailures int                   `json:"delivery_failures"`
+	Events           []TicketEventResponse `json:"events"`
+}
+
+var exportCSVHeader = []string{
+	"id",
+	"plate",
+	"road",
+	"mile1",
+	"timestamp1",
+	"mile2",
+	"timestamp2",
+	"speed",
+	"severity",
+	"status",
+	"observation_id1",
+	"observation_id2",
+	"created_at",
+	"delivered_at",
+	"dispatcher_id",
+	"delivery_failures",
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format(time.RFC3339Nano)
+}
+
+func (t *ExportedTicket) csvRecord() []string {
+	return []string{
+		strconv.FormatInt(t.ID, 10),
+		t.Plate,
+		strconv.FormatInt(t.Road, 10),
+		strconv.FormatInt(t.Mile1, 10),
+		strconv.FormatInt(t.Timestamp1, 10),
+		strconv.FormatInt(t.Mile2, 10),
+		strconv.FormatInt(t.Timestamp2, 10),
+		strconv.FormatFloat(t.Speed, 'f', 2, 64),
+		t.Severity,
+		t.Status,
+		strconv.FormatInt(t.ObservationID1, 10),
+		strconv.FormatInt(t.ObservationID2, 10),
+		formatTime(t.CreatedAt),
+		formatTime(t.DeliveredAt),
+		t.DispatcherID,
+		strconv.Itoa(t.DeliveryFailures),
+	}
+}
+
+func newExportedTicket(ticket db.Ticket, events []db.TicketEvent) *ExportedTicket {
+	exported := &ExportedTicket{
+		ID:             ticket.ID,
+		Plate:          ticket.PlateNumber,
+		Road:           ticket.RoadID,
+		Mile1:          ticket.Mile1,
+		Timestamp1:     ticket.Timestamp1,
+		Mile2:          ticket.Mile2,
+		Timestamp2:     ticket.Timestamp2,
+		Speed:          float64(ticket.Speed) / 100,
+		Severity:       ticket.Severity,
+		Status:         ticketStatus(ticket),
+		ObservationID1: ticket.ObservationID1,
+		ObservationID2: ticket.ObservationID2,
+		CreatedAt:      time.UnixMilli(ticket.CreatedAt).UTC(),
+		Events:         []TicketEventResponse{},
+	}
+
+	for _, event := range events {
+		exported.Events = append(exported.Events, newTicketEventResponse(event))
+
+		switch event.Event {
+		case TicketEventDelivered:
+			exported.DeliveredAt = time.UnixMilli(event.CreatedAt).UTC()
+			exported.DispatcherID = event.DispatcherID
+		case TicketEventDeliveryFailed:
+			exported.DeliveryFailures++
+		}
+	}
+
+	return exported
+}
+
+// Writes the tickets whose first observation falls within the time range.
+// Usage: speed-daemon export -db <path> [-from <timestamp>] [-to <timestamp>] [-format csv|jsonl] [-o <file>]
+func runExport(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ExitOnError)
+
+	dbPath := flags.String("db", "", "path of the SQLite database file written by the server")
+	from := flags.Int64("from", 0, "export tickets whose timestamp1 is at or after this timestamp")
+	to := flags.Int64("to", math.MaxUint32, "export tickets whose timestamp1 is at or before this timestamp")
+	format := flags.String("format", "csv", "output format: csv or jsonl")
+	output := flags.String("o", "", "output file. Defaults to stdout")
+
+	flags.Parse(args)
+
+	if *dbPath == "" {
+		return errors.New("export: -db is required")
+	}
+
+	if *format != "csv" && *format != "jsonl" {
+		return fmt.Errorf("export: unknown format %s", *format)
+	}
+
+	if _, err := os.Stat(*dbPath); err != nil {
+		return fmt.Errorf("export: %w", err)
+	}
+
+	ctx := context.Background()
+	queries, err := openReadOnlyDatabase(*dbPath)
+
+	if err != nil {
+		return err
+	}
+
+	var out io.Writer = os.Stdout
+
+	if *output != "" {
+		file, err := os.Create(*output)
+
+		if err != nil {
+			return err
+		}
+
+		defer file.Close()
+
+		out = file
+	}
+
+	writer := bufio.NewWriter(out)
+
+	if err := exportTickets(ctx, queries, writer, *format, *from, *to); err != nil {
+		return err
+	}
+
+	return writer.Flush()
+}
+
+func exportTickets(ctx context.Context, queries Repository, out io.Writer, format string, from int64, to int64) error {
+	tickets, err := queries.ListTicketsInTimeRange(ctx, db.ListTicketsInTimeRangeParams{
+		MinTimestamp: from,
+		MaxTimestamp: to,
+	})
+
+	if err != nil {
+		return err
+	}
+
+	csvWriter := csv.NewWriter(out)
+	jsonEncoder := json.NewEncoder(out)
+
+	if format == "csv" {
+		if err := csvWriter.Write(exportCSVHeader); err != nil {
+			return err
+		}
+	}
+
+	for _, ticket := range tickets {
+		events, err := queries.GetTicketEvents(ctx, ticket.ID)
+
+		if err != nil {
+			return err
+		}
+
+		exported := newExportedTicket(ticket, events)
+
+		if format == "csv" {
+			err = csvWriter.Write(exported.csvRecord())
+		} else {
+			err = jsonEncoder.Encode(exported)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	csvWriter.Flush()
+
+	return csvWriter.Error()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+)
+
+// How a camera declaring a different speed limit than the one already known
+// for its road is handled
+const (
+	// Disconnect the camera with an Error message
+	LimitConflictReject = "reject"
+	// Keep the limit of the first camera which registered on the road
+	LimitConflictFirst = "first"
+	// Use the lowest limit declared for the road
+	LimitConflictMin = "min"
+)
+
+// Outcomes of a limit conflict counted by speed_daemon_limit_conflicts_total
+const (
+	// The camera was disconnected
+	LimitConflictOutcomeRejected = "rejected"
+	// The road kept its limit
+	LimitConflictOutcomeKept = "kept"
+	// The road took the lower limit of the camera
+	LimitConflictOutcomeLowered = "lowered"
+)
+
+func validateLimitConflictPolicy(policy string) error {
+	switch policy {
+	case LimitConflictReject, LimitConflictFirst, LimitConflictMin:
+		return nil
+	default:
+		return fmt.Errorf("unknown limit conflict policy %q, must be one of reject, first or min", policy)
+	}
+}
+
+// Returned by IAmCamera.Register when the camera is rejected because of the
+// limit conflict policy
+type LimitConflictError struct {
+	road      uint16
+	roadLimit int64
+	limit     uint16
+}
+
+func (e *LimitConflictError) Error() string {
+	return fmt.Sprintf("speed limit %d conflicts with limit %d of road %d", e.limit, e.roadLimit, e.road)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestLimitConflicts(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      string
+		limits      []uint16
+		wantErr     error
+		wantOutcome string
+		wantLimit   int64
+	}{
+		{name: "reject", policy: LimitConflictReject, limits: []uint16{60, 50}, wantErr: &LimitConflictError{}, wantOutcome: LimitConflictOutcomeRejected, wantLimit: 60},
+		{name: "first", policy: LimitConflictFirst, limits: []uint16{60, 50}, wantOutcome: LimitConflictOutcomeKept, wantLimit: 60},
+		{name: "min of a lower limit", policy: LimitConflictMin, limits: []uint16{60, 50}, wantOutcome: LimitConflictOutcomeLowered, wantLimit: 50},
+		{name: "min of a higher limit", policy: LimitConflictMin, limits: []uint16{60, 70}, wantOutcome: LimitConflictOutcomeKept, wantLimit: 60},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			queries := NewMemoryRepository()
+
+			limitConflicts.lock.Lock()
+			before := limitConflicts.values[test.wantOutcome]
+			limitConflicts.lock.Unlock()
+
+			var err error
+
+			for i, limit := range test.limits {
+				camera := &IAmCamera{road: 1, mile: uint16(i), limit: limit}
+				err = camera.Register(ctx, queries, "camera", test.policy)
+			}
+
+			var conflict *LimitConflictError
+
+			if (test.wantErr != nil) != errors.As(err, &conflict) {
+				t.Fatalf("error = %v, want %v", err, test.wantErr)
+			}
+
+			limitConflicts.lock.Lock()
+			after := limitConflicts.values[test.wantOutcome]
+			limitConflicts.lock.Unlock()
+
+			if after != before+1 {
+				t.Errorf("%s conflicts went from %d to %d, want one more", test.wantOutcome, before, after)
+			}
+
+			road, err := queries.GetRoad(ctx, 1)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if road.SpeedLimit != test.wantLimit {
+				t.Errorf("road limit = %d, want %d", road.SpeedLimit, test.wantLimit)
+			}
+		})
+	}
+}
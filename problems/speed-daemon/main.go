@@ -1,97 +1,178 @@
 package main
 
 import (
-	"bufio"
 	"context"
 	"crypto/rand"
+	"crypto/tls"
 	"database/sql"
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"log"
 	"math"
 	"net"
+	"net/http"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
 	"time"
 
 	_ "embed"
 
+	"github.com/nivekithan/go-network/problems/speed-daemon/client"
 	"github.com/nivekithan/go-network/problems/speed-daemon/db"
 	_ "modernc.org/sqlite"
 )
 
-var plateObservationChan chan int64
-
-var dispatcherConnMap map[string]net.Conn
-var dispatcherConnLock sync.Mutex
+// Observation stored in the database which is waiting to be checked for
+// speeding
+type QueuedObservation struct {
+	id         int64
+	receivedAt time.Time
+}
 
-func addDispatcherConnection(dispatcher string, conn net.Conn) {
-	dispatcherConnLock.Lock()
-	defer dispatcherConnLock.Unlock()
+var plateObservationChan chan QueuedObservation
 
-	dispatcherConnMap[dispatcher] = conn
-}
+// Road ids which might have tickets waiting to be delivered
+var pendingTicketRoadChan chan int64
 
-func getDispatcherConnection(dispatcher string) (net.Conn, bool) {
-	dispatcherConnLock.Lock()
-	defer dispatcherConnLock.Unlock()
-	conn, ok := dispatcherConnMap[dispatcher]
-	return conn, ok
-}
+const ticketRetryInterval = 5 * time.Second
 
-func removeDispatcherConnection(dispatcher string) {
-	dispatcherConnLock.Lock()
-	defer dispatcherConnLock.Unlock()
+// Held from writing a ticket to a dispatcher until it is marked as processed,
+// so that a node returning the ticket or the admin API requeueing it in
+// between does not requeue it only for it to be marked as processed again.
+// Voiding takes it too, and delivery checks the ticket is still pending once
+// it holds it
+var ticketDeliveryLock sync.Mutex
 
-	delete(dispatcherConnMap, dispatcher)
+// Schedules delivery of the unprocessed tickets of the road
+func notifyPendingTickets(roadId int64) {
+	pendingTicketRoadChan <- roadId
 }
 
-func processUnProcessedTicket(queries *db.Queries) {
+// Blocks the current goroutine until stop is closed. A delivery in progress is
+// always finished
+func processUnProcessedTicket(queries Repository, cluster *Cluster, stop <-chan struct{}) {
 	ctx := context.Background()
-	ticker := time.NewTicker(1 * time.Second)
+	ticker := time.NewTicker(ticketRetryInterval)
+	defer ticker.Stop()
 
-	for _ = range ticker.C {
+	// Roads whose delivery failed and have to be retried
+	failedRoads := map[int64]struct{}{}
 
-		tickets, err := queries.GetUnProcessedTickets(ctx)
-		if err != nil {
-			log.Printf("Error getting unprocessed tickets: %v", err)
+	for {
+		select {
+		case <-stop:
 			return
+
+		case roadId := <-pendingTicketRoadChan:
+			if err := deliverPendingTickets(ctx, queries, cluster, roadId); err != nil {
+				log.Printf("Error delivering tickets for road %v. Retrying in %v: %v", roadId, ticketRetryInterval, err)
+				failedRoads[roadId] = struct{}{}
+				continue
+			}
+
+			delete(failedRoads, roadId)
+
+		case <-ticker.C:
+			for roadId := range failedRoads {
+				if err := deliverPendingTickets(ctx, queries, cluster, roadId); err != nil {
+					log.Printf("Error retrying tickets for road %v: %v", roadId, err)
+					continue
+				}
+
+				delete(failedRoads, roadId)
+			}
 		}
+	}
+}
+
+// Writes every unprocessed ticket of the road to its dispatcher. Tickets are
+// marked as processed only after they have been written. Returns nil if
+// there is no dispatcher for the road, since its registration triggers
+// another delivery. Tickets of a road owned by another node are returned to
+// the owner instead, which can deliver them to the dispatchers of any node.
+func deliverPendingTickets(ctx context.Context, queries Repository, cluster *Cluster, roadId int64) error {
+	tickets, err := queries.GetUnProcessedTicketsForRoad(ctx, roadId)
 
-		for _, ticket := range tickets {
-			dispatcher, err := queries.FindDispatcherForRoad(ctx, ticket.RoadID)
+	if err != nil {
+		return err
+	}
+
+tickets:
+	for i, ticket := range tickets {
+		ticketBinary := Ticket{
+			plate:      ticket.PlateNumber,
+			road:       uint16(ticket.RoadID),
+			mile1:      uint16(ticket.Mile1),
+			timestamp1: uint32(ticket.Timestamp1),
+			timestamp2: uint32(ticket.Timestamp2),
+			mile2:      uint16(ticket.Mile2),
+			speed:      uint16(ticket.Speed),
+		}
+
+		// A dispatcher whose write fails is dropped, so this ends once the
+		// ticket is written or no dispatcher is left for the road
+		for {
+			dispatcher, err := nextDispatcherForRoad(ctx, queries, ticket.RoadID)
+
+			if errors.Is(err, errNoDispatcher) {
+				if owner, ok := cluster.remoteOwner(uint16(ticket.RoadID)); ok {
+					return returnTicketsToOwner(ctx, queries, cluster, owner, tickets[i:])
+				}
+
+				log.Printf("No dispatcher for road %v. Keeping %d tickets as not processed", ticket.RoadID, len(tickets))
+				return nil
+			}
 
 			if err != nil {
-				log.Printf("Error finding dispatcher for road %v: %v", ticket.RoadID, err)
-				continue
+				return err
 			}
 
-			dispatcherConn, ok := getDispatcherConnection(dispatcher)
+			ticketDeliveryLock.Lock()
 
-			if !ok {
-				log.Printf("No dispatcher connection found for road %v", ticket.RoadID)
-				continue
+			// The admin API may have voided the ticket since it was read
+			current, err := queries.GetTicket(ctx, ticket.ID)
+
+			if err != nil {
+				ticketDeliveryLock.Unlock()
+				return err
 			}
 
-			ticketBinary := Ticket{
-				plate:      ticket.PlateNumber,
-				road:       uint16(ticket.RoadID),
-				mile1:      uint16(ticket.Mile1),
-				timestamp1: uint32(ticket.Timestamp1),
-				timestamp2: uint32(ticket.Timestamp2),
-				mile2:      uint16(ticket.Mile2),
-				speed:      uint16(ticket.Speed),
+			if !isPending(current) {
+				ticketDeliveryLock.Unlock()
+				continue tickets
 			}
 
-			dispatcherConn.Write(ticketBinary.toBinary())
+			if err := dispatcher.Send(ticketBinary.toBinary()); err != nil {
+				ticketDeliveryLock.Unlock()
+				log.Printf("Error writing ticket %v to dispatcher %v. Trying another dispatcher: %v", ticket.ID, dispatcher.id, err)
+				deliveryFailures.Inc()
+				recordTicketEvent(ctx, queries, ticket.ID, TicketEventDeliveryFailed, dispatcher.id, err.Error())
+				dropDispatcher(ctx, queries, dispatcher)
+				continue
+			}
+
+			ticketsDelivered.Inc()
+			recordTicketEvent(ctx, queries, ticket.ID, TicketEventDelivered, dispatcher.id, "")
+			break
+		}
 
-			queries.MarkTicketAsProcessed(ctx, ticket.ID)
+		err := queries.MarkTicketAsProcessed(ctx, ticket.ID)
+		ticketDeliveryLock.Unlock()
 
+		if err != nil {
+			return fmt.Errorf("marking ticket %v as processed: %w", ticket.ID, err)
 		}
+
+		log.Printf("Ticket processed successfully %+v", ticketBinary)
 	}
+
+	return nil
 }
 
 type TicketObservation struct {
+	id        int64
 	timestamp int64
 	location  int64
 }
@@ -102,31 +183,26 @@ type CreateNewTicketParams struct {
 	observation1 TicketObservation
 	observation2 TicketObservation
 	speed        int64
+	severity     string
 }
 
-func createNewTicket(ctx context.Context, queries *db.Queries, newTicket CreateNewTicketParams) error {
-	var ticket Ticket
-
+// Tickets the plate unless it was already ticketed on one of the days of the
+// ticket. In a cluster the days are also claimed from the authority of the
+// plate, since other nodes ticket it on their own roads.
+func createNewTicket(ctx context.Context, queries Repository, cluster *Cluster, newTicket CreateNewTicketParams) error {
+	// Tickets always go from the earlier observation to the later one
 	if newTicket.observation1.timestamp > newTicket.observation2.timestamp {
-		ticket = Ticket{
-			plate:      newTicket.plate,
-			road:       uint16(newTicket.roadId),
-			mile1:      uint16(newTicket.observation2.location),
-			mile2:      uint16(newTicket.observation1.location),
-			timestamp1: uint32(newTicket.observation2.timestamp),
-			timestamp2: uint32(newTicket.observation1.timestamp),
-			speed:      uint16(newTicket.speed * 100),
-		}
-	} else {
-		ticket = Ticket{
-			plate:      newTicket.plate,
-			road:       uint16(newTicket.roadId),
-			mile1:      uint16(newTicket.observation1.location),
-			mile2:      uint16(newTicket.observation2.location),
-			timestamp1: uint32(newTicket.observation1.timestamp),
-			timestamp2: uint32(newTicket.observation2.timestamp),
-			speed:      uint16(newTicket.speed * 100),
-		}
+		newTicket.observation1, newTicket.observation2 = newTicket.observation2, newTicket.observation1
+	}
+
+	ticket := Ticket{
+		plate:      newTicket.plate,
+		road:       uint16(newTicket.roadId),
+		mile1:      uint16(newTicket.observation1.location),
+		mile2:      uint16(newTicket.observation2.location),
+		timestamp1: uint32(newTicket.observation1.timestamp),
+		timestamp2: uint32(newTicket.observation2.timestamp),
+		speed:      uint16(newTicket.speed * 100),
 	}
 
 	minDay := math.Trunc(float64(ticket.timestamp1 / 86400))
@@ -140,96 +216,88 @@ func createNewTicket(ctx context.Context, queries *db.Queries, newTicket CreateN
 
 	if err == nil {
 
+		ticketsSuppressed.Inc()
 		log.Printf("Found conflicting tickets  Ticket: %+v Conflicts: %+v \n", ticket, conflitcingTickets)
 		return errors.New("conflict tickets")
 	}
 
+	if !cluster.claimTicketDays(ctx, queries, client.TicketDays{Plate: ticket.plate, Start: uint32(minDay), End: uint32(maxDay)}) {
+		ticketsSuppressed.Inc()
+		log.Printf("Found conflicting tickets on other nodes Ticket: %+v \n", ticket)
+		return errors.New("conflict tickets")
+	}
+
 	log.Println("Did not find conflicting tickets. Ticketing the plate")
 
-	dispatcherId, err := queries.FindDispatcherForRoad(ctx, int64(ticket.road))
+	ticketId, err := queries.StoreTicket(ctx, db.StoreTicketParams{
+		PlateNumber:    ticket.plate,
+		RoadID:         int64(ticket.road),
+		Mile1:          int64(ticket.mile1),
+		Mile2:          int64(ticket.mile2),
+		Timestamp1:     int64(ticket.timestamp1),
+		Timestamp2:     int64(ticket.timestamp2),
+		Speed:          int64(ticket.speed),
+		DayStartRange:  int64(minDay),
+		DayEndRange:    int64(maxDay),
+		IsProcessed:    0,
+		ObservationID1: newTicket.observation1.id,
+		ObservationID2: newTicket.observation2.id,
+		CreatedAt:      time.Now().UnixMilli(),
+		Severity:       newTicket.severity,
+	})
 
 	if err != nil {
-		log.Printf("found no dispatcher for road: %v. Storing ticket as not processed. Ticket: %+v", ticket.road, ticket)
-
-		if err = queries.StoreTicket(ctx, db.StoreTicketParams{
-			PlateNumber:   ticket.plate,
-			RoadID:        int64(ticket.road),
-			Mile1:         int64(ticket.mile1),
-			Mile2:         int64(ticket.mile2),
-			Timestamp1:    int64(ticket.timestamp1),
-			Timestamp2:    int64(ticket.timestamp2),
-			Speed:         int64(ticket.speed),
-			DayStartRange: int64(minDay),
-			DayEndRange:   int64(maxDay),
-			IsProcessed:   0,
-		}); err != nil {
-			panic(err)
-		}
-
-		return nil
-	}
-
-	conn, ok := getDispatcherConnection(dispatcherId)
-
-	if !ok {
-		log.Printf("Found no dispatcher for road: %v, Storing ticket as not processed. Ticket: %+v", ticket.road, ticket)
-		if err = queries.StoreTicket(ctx, db.StoreTicketParams{
-			PlateNumber:   ticket.plate,
-			RoadID:        int64(ticket.road),
-			Mile1:         int64(ticket.mile1),
-			Mile2:         int64(ticket.mile2),
-			Timestamp1:    int64(ticket.timestamp1),
-			Timestamp2:    int64(ticket.timestamp2),
-			Speed:         int64(ticket.speed),
-			DayStartRange: int64(minDay),
-			DayEndRange:   int64(maxDay),
-			IsProcessed:   0,
-		}); err != nil {
-			panic(err)
-		}
-
-		return nil
-	}
-
-	if _, err = conn.Write(ticket.toBinary()); err != nil {
-		log.Printf("Error writing ticket to dispatcher: %v. Ticket %+v", err, ticket)
-		return nil
-	}
-
-	if err = queries.StoreTicket(ctx, db.StoreTicketParams{
-		PlateNumber:   ticket.plate,
-		RoadID:        int64(ticket.road),
-		Mile1:         int64(ticket.mile1),
-		Mile2:         int64(ticket.mile2),
-		Timestamp1:    int64(ticket.timestamp1),
-		Timestamp2:    int64(ticket.timestamp2),
-		Speed:         int64(ticket.speed),
-		DayStartRange: int64(minDay),
-		DayEndRange:   int64(maxDay),
-		IsProcessed:   1,
-	}); err != nil {
 		panic(err)
 	}
 
-	log.Printf("Ticket processed successfully %+v", ticket)
+	ticketsCreated.Inc()
+	recordTicketEvent(ctx, queries, ticketId, TicketEventCreated, "", fmt.Sprintf("observations %d and %d", newTicket.observation1.id, newTicket.observation2.id))
+
+	notifyPendingTickets(int64(ticket.road))
 
 	return nil
 }
 
-// Blocks the current goroutine
-func processPlateObservation(queries *db.Queries) {
+// Average speed between two observations of a plate in miles per hour
+func observedSpeed(observation1 db.PlateObservation, observation2 db.PlateObservation) float64 {
+	distance := math.Abs(float64(observation1.Location - observation2.Location))
+	time := math.Abs(float64(observation1.Timestamp-observation2.Timestamp) / (60 * 60))
+
+	return distance / time
+}
+
+// Blocks the current goroutine until plateObservationChan is closed
+func processPlateObservation(queries Repository, rules *Rules, cluster *Cluster, retention time.Duration) {
 	ctx := context.Background()
 	log.Println("Processing plate observation")
 
-	for observationId := range plateObservationChan {
-		log.Printf("Processing observation ID: %d\n", observationId)
-		observation, err := queries.GetObservationById(ctx, observationId)
+	for queued := range plateObservationChan {
+		log.Printf("Processing observation ID: %d\n", queued.id)
+		observation, err := queries.GetObservationById(ctx, queued.id)
 
 		if err != nil {
 			log.Printf("Error getting observation by ID: %v\n", err)
 			continue
 		}
 
+		if rules.IsExempt(observation.PlateNumber) {
+			log.Printf("Plate %v is exempt from tickets\n", observation.PlateNumber)
+			continue
+		}
+
+		cutoff, err := retentionCutoff(ctx, queries, observation.PlateNumber, observation.RoadID, retention)
+
+		if err != nil {
+			log.Printf("Error getting retention cutoff: %v\n", err)
+			continue
+		}
+
+		if observation.Timestamp < cutoff {
+			observationsExpired.Inc()
+			log.Printf("Observation %d of plate %v is older than the retention\n", observation.ID, observation.PlateNumber)
+			continue
+		}
+
 		road, err := queries.GetRoad(ctx, observation.RoadID)
 
 		if err != nil {
@@ -245,25 +313,26 @@ func processPlateObservation(queries *db.Queries) {
 			Timestamp:   observation.Timestamp,
 		})
 
-		if err == nil {
-			distance := math.Abs(float64(observation.Location - previousObservation.Location))
-			time := math.Abs(float64(observation.Timestamp-previousObservation.Timestamp) / (60 * 60))
-
-			previousSpeedLimitFloat := (distance) / time
-			previousSpeedLimit := int64(math.Round(previousSpeedLimitFloat))
+		// Newer observations are never expired
+		if err == nil && previousObservation.Timestamp >= cutoff {
+			previousSpeedLimit := observedSpeed(observation, previousObservation)
 
 			log.Printf("Speed limit %v", previousSpeedLimit)
 
-			if previousSpeedLimit > accepetedSpeedLimit {
+			exceeded, severity := rules.Evaluate(uint16(road.ID), accepetedSpeedLimit, previousSpeedLimit)
+
+			if exceeded {
 				log.Printf("Speed limit exceeded for plate %v on road %v\n", observation.PlateNumber, road.ID)
-				if err = createNewTicket(ctx, queries, CreateNewTicketParams{
+				if err = createNewTicket(ctx, queries, cluster, CreateNewTicketParams{
 					plate:        observation.PlateNumber,
 					roadId:       road.ID,
-					observation1: TicketObservation{timestamp: observation.Timestamp, location: observation.Location},
-					observation2: TicketObservation{timestamp: previousObservation.Timestamp, location: previousObservation.Location},
-					speed:        previousSpeedLimit,
+					observation1: TicketObservation{id: observation.ID, timestamp: observation.Timestamp, location: observation.Location},
+					observation2: TicketObservation{id: previousObservation.ID, timestamp: previousObservation.Timestamp, location: previousObservation.Location},
+					speed:        int64(math.Round(previousSpeedLimit)),
+					severity:     severity,
 				}); err == nil {
 
+					observationToTicketSeconds.Observe(time.Since(queued.receivedAt).Seconds())
 					continue
 				}
 			}
@@ -277,23 +346,24 @@ func processPlateObservation(queries *db.Queries) {
 		})
 
 		if err == nil {
-			distance := math.Abs(float64(observation.Location - nextObservation.Location))
-			time := math.Abs(float64(observation.Timestamp-nextObservation.Timestamp) / (60 * 60))
-
-			nextSpeedLimitFloat := (distance) / time
-			nextSpeedLimit := int64(math.Round(nextSpeedLimitFloat))
+			nextSpeedLimit := observedSpeed(observation, nextObservation)
 
 			log.Printf("Speed limit %v", nextSpeedLimit)
-			if nextSpeedLimit > accepetedSpeedLimit {
+
+			exceeded, severity := rules.Evaluate(uint16(road.ID), accepetedSpeedLimit, nextSpeedLimit)
+
+			if exceeded {
 				log.Printf("Speed limit exceeded for plate %v on road %v\n", observation.PlateNumber, road.ID)
-				if err = createNewTicket(ctx, queries, CreateNewTicketParams{
+				if err = createNewTicket(ctx, queries, cluster, CreateNewTicketParams{
 					plate:        observation.PlateNumber,
 					roadId:       road.ID,
-					observation1: TicketObservation{timestamp: observation.Timestamp, location: observation.Location},
-					observation2: TicketObservation{timestamp: nextObservation.Timestamp, location: nextObservation.Location},
-					speed:        nextSpeedLimit,
+					observation1: TicketObservation{id: observation.ID, timestamp: observation.Timestamp, location: observation.Location},
+					observation2: TicketObservation{id: nextObservation.ID, timestamp: nextObservation.Timestamp, location: nextObservation.Location},
+					speed:        int64(math.Round(nextSpeedLimit)),
+					severity:     severity,
 				}); err == nil {
 
+					observationToTicketSeconds.Observe(time.Since(queued.receivedAt).Seconds())
 					continue
 				}
 			}
@@ -303,241 +373,318 @@ func processPlateObservation(queries *db.Queries) {
 	}
 }
 
-func handleConnectionImpl(queries *db.Queries, conn net.Conn) error {
+// This function blocks
+func handleConnection(queries Repository, conn net.Conn, config *Config) {
+	ctx := context.Background()
 
-	reader := bufio.NewReader(conn)
+	certIdentity := ""
 
-	ctx := context.Background()
+	// The handshake has to happen before recording so the session file holds
+	// the decrypted protocol
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		identity, err := tlsIdentity(tlsConn)
 
-	isWantHeartbeat := false
+		if err != nil {
+			log.Printf("error: TLS handshake with %s: %v", conn.RemoteAddr(), err)
+			conn.Close()
+			return
+		}
 
-	for {
-		var messageType uint8
+		log.Printf("TLS client %s connected from %s", identity, conn.RemoteAddr())
+		certIdentity = identity
+	}
 
-		if err := binary.Read(reader, binary.BigEndian, &messageType); err != nil {
-			return err
+	if config.recordDir != "" {
+		recordingConn, err := NewRecordingConn(conn, config.recordDir, rand.Text())
+
+		if err != nil {
+			log.Printf("error: not recording connection: %v", err)
+		} else {
+			conn = recordingConn
 		}
+	}
 
-		switch messageType {
-		case 0x80:
-			log.Println("MessageType=IamCamera")
-			camera, err := NewIamCamera(reader)
+	connectionsTotal.Inc()
+	client := NewClient(conn)
+	client.limitConflict = config.limitConflict
+	client.cluster = config.cluster
 
-			if err != nil {
-				return err
-			}
+	if certIdentity != "" {
+		client.certIdentity = certIdentity
+		client.policy = config.policy
+	}
 
-			log.Printf("%+v\n", camera)
+	defer close(client.done)
 
-			if err := camera.Register(ctx, queries); err != nil {
-				return err
-			}
+	addClient(client)
+	defer removeClient(client)
 
-			for {
-				var messageType uint8
+	defer client.Close()
+	defer client.cleanup(ctx, queries)
+	defer log.Println("Closing connection")
 
-				if err := binary.Read(reader, binary.BigEndian, &messageType); err != nil {
-					return err
-				}
+	if err := client.Run(ctx, queries); err != nil {
+		log.Println("error: ", err)
+	}
+}
 
-				switch messageType {
-				case 0x40:
-					log.Println("MessageType=WantHeartbeat")
+func handleListner(queries Repository, listner net.Listener, handle func(Repository, net.Conn, *Config), config *Config) error {
+	conn, err := listner.Accept()
 
-					if isWantHeartbeat {
-						return clientError(conn, fmt.Sprintf("Multiple heartbeats not allowed: %x", messageType))
-					}
+	if err != nil {
+		return err
+	}
 
-					isWantHeartbeat = true
+	go handle(queries, conn, config)
 
-					hearbeat, err := NewWantHeartbeat(reader)
+	return nil
+}
 
-					if err != nil {
-						return err
-					}
+//go:embed sql/schema.sql
+var ddl string
 
-					go hearbeat.SendHeartBeat(conn)
+// Opens the database and creates the tables which do not exist yet. The
+// database is kept in memory if path is empty.
+func openDatabase(ctx context.Context, path string) (*db.Queries, error) {
+	dsn := "file::memory:?cache=shared"
 
-				case 0x20:
-					log.Println("MessageType=Plate")
+	if path != "" {
+		dsn = fmt.Sprintf("file:%s?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path)
+	}
 
-					plate, err := NewPlate(reader)
+	sqliteDb, err := sql.Open("sqlite", dsn)
 
-					if err != nil {
-						return err
-					}
+	if err != nil {
+		return nil, err
+	}
 
-					log.Printf("%+v\n", plate)
+	log.Println(ddl)
 
-					observation_id, err := plate.RegisterObservation(ctx, queries, RegisterObservationsParams{
-						RoadID:   camera.road,
-						Location: camera.mile,
-					})
+	if _, err := sqliteDb.ExecContext(ctx, ddl); err != nil {
+		return nil, err
+	}
 
-					if err != nil {
-						return err
-					}
+	if err := addMissingColumns(ctx, sqliteDb); err != nil {
+		return nil, err
+	}
 
-					plateObservationChan <- observation_id
+	return db.New(sqliteDb), nil
+}
 
-				default:
-					return clientError(conn, fmt.Sprintf("unknown messageType: %x", messageType))
-				}
-			}
+// Opens an existing database file without creating or migrating its tables
+func openReadOnlyDatabase(path string) (*db.Queries, error) {
+	sqliteDb, err := sql.Open("sqlite", fmt.Sprintf("file:%s?mode=ro&_pragma=busy_timeout(5000)", path))
 
-		case 0x81:
-			log.Println("MessageType=IamDispatcher")
-			dispatcher, err := NewIamDispatcher(reader)
-			dispatcherId := rand.Text()
+	if err != nil {
+		return nil, err
+	}
 
-			if err != nil {
-				return err
-			}
-			log.Printf("Dispatcher %+v\n", dispatcher)
+	return db.New(sqliteDb), nil
+}
 
-			addDispatcherConnection(dispatcherId, conn)
-			defer func() {
-				removeDispatcherConnection(dispatcherId)
-			}()
-			dispatcher.Register(ctx, queries, dispatcherId)
+// Columns added to tables after they were first created. CREATE TABLE IF NOT
+// EXISTS does not add them to existing database files
+var addedColumns = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{table: "ticket", column: "severity", definition: "TEXT NOT NULL DEFAULT ''"},
+}
 
-			for {
+func addMissingColumns(ctx context.Context, sqliteDb *sql.DB) error {
+	for _, added := range addedColumns {
+		var count int64
 
-				var messageType uint8
+		if err := sqliteDb.QueryRowContext(ctx, "SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", added.table, added.column).Scan(&count); err != nil {
+			return err
+		}
 
-				if err := binary.Read(reader, binary.BigEndian, &messageType); err != nil {
-					return err
-				}
+		if count > 0 {
+			continue
+		}
 
-				switch messageType {
+		log.Printf("Adding column %s.%s", added.table, added.column)
 
-				case 0x40:
-					log.Println("MessageType=WantHeartbeat")
+		if _, err := sqliteDb.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", added.table, added.column, added.definition)); err != nil {
+			return err
+		}
+	}
 
-					if isWantHeartbeat {
-						return clientError(conn, fmt.Sprintf("Multiple heartbeats not allowed: %x", messageType))
-					}
+	return nil
+}
 
-					isWantHeartbeat = true
+// Serves until ctx is done and then shuts down gracefully
+func run(ctx context.Context, config *Config) error {
 
-					hearbeat, err := NewWantHeartbeat(reader)
+	if err := validateLimitConflictPolicy(config.limitConflict); err != nil {
+		return err
+	}
 
-					if err != nil {
-						return err
-					}
+	config.rules = DefaultRules()
 
-					go hearbeat.SendHeartBeat(conn)
+	if config.rulesPath != "" {
+		rules, err := LoadRules(config.rulesPath)
 
-				default:
-					return clientError(conn, fmt.Sprintf("unknown messageType: %x", messageType))
-				}
-			}
+		if err != nil {
+			return err
+		}
 
-		case 0x40:
-			log.Println("MessageType=WantHeartbeat")
+		config.rules = rules
+	}
 
-			if isWantHeartbeat {
-				return clientError(conn, fmt.Sprintf("Multiple heartbeats not allowed: %x", messageType))
-			}
+	if config.clusterPath != "" {
+		cluster, err := LoadCluster(config.clusterPath, config.nodeId)
 
-			isWantHeartbeat = true
+		if err != nil {
+			return err
+		}
 
-			hearbeat, err := NewWantHeartbeat(reader)
+		config.cluster = cluster
+	}
 
-			if err != nil {
-				return err
-			}
+	// Peers are dialed over plain TCP, which a policy does not allow
+	if config.cluster != nil && config.policyPath != "" {
+		return errors.New("-cluster cannot be combined with -policy")
+	}
 
-			go hearbeat.SendHeartBeat(conn)
+	if config.policyPath != "" {
+		if config.tlsAddr == "" {
+			return errors.New("-policy requires -tls-addr")
+		}
 
-		default:
-			return clientError(conn, fmt.Sprintf("unknown messageType: %x", messageType))
+		// Plain TCP clients have no identity, so they would bypass the policy
+		if config.addr != "" {
+			return errors.New(`-policy requires the plain TCP listener to be disabled with -addr ""`)
 		}
-	}
-}
 
-// This function blocks
-func handleConnection(queries *db.Queries, conn net.Conn) {
-	defer conn.Close()
-	defer log.Println("Closing connection")
+		policy, err := LoadPolicy(config.policyPath)
 
-	if err := handleConnectionImpl(queries, conn); err != nil {
-		log.Println("error: ", err)
+		if err != nil {
+			return err
+		}
+
+		config.policy = policy
 	}
-}
 
-func handleListner(queries *db.Queries, listner net.Listener) error {
-	conn, err := listner.Accept()
+	queries, err := openRepository(ctx, config.storage, config.dbPath)
 
 	if err != nil {
 		return err
 	}
 
-	go handleConnection(queries, conn)
+	// Dispatchers of a previous run are not connected anymore
+	if err := queries.RemoveAllDispatchers(ctx); err != nil {
+		return err
+	}
 
-	return nil
-}
+	plateObservationChan = make(chan QueuedObservation)
+	pendingTicketRoadChan = make(chan int64, 1024)
+	dispatcherConnMap = make(map[string]*Client)
+	dispatcherNextIndex = make(map[int64]int)
 
-//go:embed sql/schema.sql
-var ddl string
+	clientsMap = make(map[string]*Client)
 
-func run() error {
+	processors := startProcessors(queries, config)
 
-	ctx := context.Background()
-	sqliteDb, err := sql.Open("sqlite", "file::memory:?cache=shared")
+	if config.retention > 0 {
+		go compactObservations(queries, config.retention, config.compactionInterval)
+	}
 
-	if err != nil {
-		return err
+	if config.adminAddr != "" {
+		go func() {
+			log.Printf("Admin API listening in %s", config.adminAddr)
+
+			if err := http.ListenAndServe(config.adminAddr, NewAdminHandler(queries, config.rules, config.cluster)); err != nil {
+				log.Printf("error: admin API: %v", err)
+			}
+		}()
 	}
 
-	log.Println(ddl)
+	if config.metricsAddr != "" {
+		go func() {
+			log.Printf("Metrics listening in %s", config.metricsAddr)
 
-	if _, err := sqliteDb.ExecContext(ctx, ddl); err != nil {
-		return err
+			if err := http.ListenAndServe(config.metricsAddr, NewMetricsHandler(queries)); err != nil {
+				log.Printf("error: metrics: %v", err)
+			}
+		}()
 	}
 
-	queries := db.New(sqliteDb)
+	if config.addr == "" && config.tlsAddr == "" {
+		return errors.New("no listener enabled, set -addr or -tls-addr")
+	}
 
-	plateObservationChan = make(chan int64)
-	dispatcherConnMap = make(map[string]net.Conn)
+	listners := []net.Listener{}
 
-	go processPlateObservation(queries)
-	go processUnProcessedTicket(queries)
+	if config.addr != "" {
+		listner, err := net.Listen("tcp", config.addr)
 
-	listner, err := net.Listen("tcp", ":8000")
+		if err != nil {
+			return err
+		}
 
-	if err != nil {
-		return err
+		log.Printf("Listening in %s", config.addr)
+		listners = append(listners, listner)
 	}
 
-	log.Println("Listening in port :8000")
+	if config.tlsAddr != "" {
+		tlsConfig, err := NewTLSConfig(config.tlsCert, config.tlsKey, config.tlsClientCA)
 
-	for {
+		if err != nil {
+			return err
+		}
 
-		err := handleListner(queries, listner)
+		listner, err := tls.Listen("tcp", config.tlsAddr, tlsConfig)
 
 		if err != nil {
-			log.Println("error: handleListner", err)
+			return err
 		}
 
+		log.Printf("Listening for TLS clients in %s", config.tlsAddr)
+		listners = append(listners, listner)
 	}
 
-}
+	var nodeListner net.Listener
 
-func main() {
-	if err := run(); err != nil {
-		log.Fatal(err)
+	if config.cluster != nil {
+		nodeAddr := config.cluster.node(config.cluster.self).NodeAddr
+		nodeListner, err = net.Listen("tcp", nodeAddr)
+
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Listening for cluster nodes in %s", nodeAddr)
 	}
+
+	serveUntilDone(ctx, queries, listners, nodeListner, config)
+
+	return shutdown(queries, config.cluster, processors, config.shutdownTimeout)
 }
 
-func clientError(conn net.Conn, msg string) error {
-	clientError := ClientError{msg: msg}
+func main() {
+	var err error
 
-	binary := clientError.toBinary()
+	subcommand := ""
 
-	if _, err := conn.Write(binary); err != nil {
-		return err
+	if len(os.Args) > 1 {
+		subcommand = os.Args[1]
 	}
 
-	return errors.New(clientError.msg)
+	switch subcommand {
+	case "export":
+		err = runExport(os.Args[2:])
+	case "replay":
+		err = runReplay(os.Args[2:])
+	default:
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		err = run(ctx, parseFlags())
+	}
+
+	if err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,573 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"slices"
+	"sync"
+
+	"github.com/nivekithan/go-network/problems/speed-daemon/db"
+)
+
+type plateRoad struct {
+	plate string
+	road  int64
+}
+
+type plateDay struct {
+	plate string
+	day   int64
+}
+
+// MemoryRepository keeps everything in maps and slices guarded by a single
+// lock. Ids are assigned from 1 like the AUTOINCREMENT columns of the SQLite
+// schema.
+type MemoryRepository struct {
+	lock sync.Mutex
+
+	roads map[int64]db.Road
+
+	cameraLimits []db.CameraLimit
+
+	observations map[int64]db.PlateObservation
+
+	// Observations of a plate on a road, sorted by timestamp
+	observationsByPlateRoad map[plateRoad][]db.PlateObservation
+
+	// Indexed by id - 1
+	tickets []db.Ticket
+
+	// Ids of the tickets of a plate on every day of their range, in
+	// ascending order. Voided tickets are kept since they can be requeued
+	ticketsByPlateDay map[plateDay][]int64
+
+	// Claims of a plate in id order
+	ticketClaims map[string][]db.TicketClaim
+
+	dispatchers  []db.Dispatcher
+	ticketEvents map[int64][]db.TicketEvent
+
+	lastObservationId int64
+	lastClaimId       int64
+	lastDispatcherId  int64
+	lastEventId       int64
+}
+
+var _ Repository = (*MemoryRepository)(nil)
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		roads:                   map[int64]db.Road{},
+		observations:            map[int64]db.PlateObservation{},
+		observationsByPlateRoad: map[plateRoad][]db.PlateObservation{},
+		ticketsByPlateDay:       map[plateDay][]int64{},
+		ticketClaims:            map[string][]db.TicketClaim{},
+		ticketEvents:            map[int64][]db.TicketEvent{},
+	}
+}
+
+func (r *MemoryRepository) InsertRoad(ctx context.Context, arg db.InsertRoadParams) (int64, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.roads[arg.ID]; ok {
+		return 0, nil
+	}
+
+	r.roads[arg.ID] = db.Road{ID: arg.ID, SpeedLimit: arg.SpeedLimit}
+
+	return 1, nil
+}
+
+func (r *MemoryRepository) GetRoad(ctx context.Context, id int64) (db.Road, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	road, ok := r.roads[id]
+
+	if !ok {
+		return db.Road{}, sql.ErrNoRows
+	}
+
+	return road, nil
+}
+
+func (r *MemoryRepository) ListRoads(ctx context.Context) ([]db.Road, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var roads []db.Road
+
+	for _, road := range r.roads {
+		roads = append(roads, road)
+	}
+
+	slices.SortFunc(roads, func(a, b db.Road) int { return cmp.Compare(a.ID, b.ID) })
+
+	return roads, nil
+}
+
+func (r *MemoryRepository) LowerRoadSpeedLimit(ctx context.Context, arg db.LowerRoadSpeedLimitParams) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if road, ok := r.roads[arg.ID]; ok && road.SpeedLimit > arg.SpeedLimit {
+		road.SpeedLimit = arg.SpeedLimit
+		r.roads[arg.ID] = road
+	}
+
+	return nil
+}
+
+func (r *MemoryRepository) InsertCameraLimit(ctx context.Context, arg db.InsertCameraLimitParams) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.cameraLimits = append(r.cameraLimits, db.CameraLimit{
+		ID:             int64(len(r.cameraLimits) + 1),
+		RoadID:         arg.RoadID,
+		Mile:           arg.Mile,
+		SpeedLimit:     arg.SpeedLimit,
+		RoadSpeedLimit: arg.RoadSpeedLimit,
+		ClientID:       arg.ClientID,
+		Conflict:       arg.Conflict,
+		CreatedAt:      arg.CreatedAt,
+	})
+
+	return nil
+}
+
+func (r *MemoryRepository) CountLimitConflicts(ctx context.Context) (int64, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	count := int64(0)
+
+	for _, limit := range r.cameraLimits {
+		if limit.Conflict == 1 {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// Latest conflicts first
+func (r *MemoryRepository) GetLimitConflicts(ctx context.Context, limit int64) ([]db.CameraLimit, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var conflicts []db.CameraLimit
+
+	for i := len(r.cameraLimits) - 1; i >= 0 && int64(len(conflicts)) < limit; i-- {
+		if r.cameraLimits[i].Conflict == 1 {
+			conflicts = append(conflicts, r.cameraLimits[i])
+		}
+	}
+
+	return conflicts, nil
+}
+
+func (r *MemoryRepository) InsertPlateObservation(ctx context.Context, arg db.InsertPlateObservationParams) (int64, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.lastObservationId++
+
+	observation := db.PlateObservation{
+		ID:          r.lastObservationId,
+		PlateNumber: arg.PlateNumber,
+		Timestamp:   arg.Timestamp,
+		Location:    arg.Location,
+		RoadID:      arg.RoadID,
+	}
+
+	r.observations[observation.ID] = observation
+
+	key := plateRoad{plate: arg.PlateNumber, road: arg.RoadID}
+	sorted := r.observationsByPlateRoad[key]
+	i := sortedObservationIndex(sorted, arg.Timestamp+1)
+	r.observationsByPlateRoad[key] = slices.Insert(sorted, i, observation)
+
+	return observation.ID, nil
+}
+
+// Index of the first observation at or after timestamp
+func sortedObservationIndex(sorted []db.PlateObservation, timestamp int64) int {
+	i, _ := slices.BinarySearchFunc(sorted, timestamp, func(observation db.PlateObservation, timestamp int64) int {
+		return cmp.Compare(observation.Timestamp, timestamp)
+	})
+
+	return i
+}
+
+func (r *MemoryRepository) GetPreviousObservation(ctx context.Context, arg db.GetPreviousObservationParams) (db.PlateObservation, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	sorted := r.observationsByPlateRoad[plateRoad{plate: arg.PlateNumber, road: arg.RoadID}]
+	i := sortedObservationIndex(sorted, arg.Timestamp)
+
+	if i == 0 {
+		return db.PlateObservation{}, sql.ErrNoRows
+	}
+
+	return sorted[i-1], nil
+}
+
+func (r *MemoryRepository) GetNextObservation(ctx context.Context, arg db.GetNextObservationParams) (db.PlateObservation, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	sorted := r.observationsByPlateRoad[plateRoad{plate: arg.PlateNumber, road: arg.RoadID}]
+	i := sortedObservationIndex(sorted, arg.Timestamp+1)
+
+	if i == len(sorted) {
+		return db.PlateObservation{}, sql.ErrNoRows
+	}
+
+	return sorted[i], nil
+}
+
+func (r *MemoryRepository) GetObservationById(ctx context.Context, id int64) (db.PlateObservation, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	observation, ok := r.observations[id]
+
+	if !ok {
+		return db.PlateObservation{}, sql.ErrNoRows
+	}
+
+	return observation, nil
+}
+
+// Ordered by road and timestamp
+func (r *MemoryRepository) GetObservationsForPlate(ctx context.Context, plateNumber string) ([]db.PlateObservation, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var observations []db.PlateObservation
+
+	for key, sorted := range r.observationsByPlateRoad {
+		if key.plate == plateNumber {
+			observations = append(observations, sorted...)
+		}
+	}
+
+	slices.SortStableFunc(observations, func(a, b db.PlateObservation) int {
+		return cmp.Or(cmp.Compare(a.RoadID, b.RoadID), cmp.Compare(a.Timestamp, b.Timestamp))
+	})
+
+	return observations, nil
+}
+
+func (r *MemoryRepository) GetLatestPlateObservationTimestamp(ctx context.Context, arg db.GetLatestPlateObservationTimestampParams) (int64, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	sorted := r.observationsByPlateRoad[plateRoad{plate: arg.PlateNumber, road: arg.RoadID}]
+
+	if len(sorted) == 0 {
+		return 0, nil
+	}
+
+	return sorted[len(sorted)-1].Timestamp, nil
+}
+
+// Deletes observations more than retention older than the newest observation
+// of their plate on their road
+func (r *MemoryRepository) DeleteExpiredObservations(ctx context.Context, retention int64) (int64, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	deleted := int64(0)
+
+	for key, sorted := range r.observationsByPlateRoad {
+		i := sortedObservationIndex(sorted, sorted[len(sorted)-1].Timestamp-retention)
+
+		for _, observation := range sorted[:i] {
+			delete(r.observations, observation.ID)
+		}
+
+		deleted += int64(i)
+
+		if i > 0 {
+			r.observationsByPlateRoad[key] = slices.Clone(sorted[i:])
+		}
+	}
+
+	return deleted, nil
+}
+
+func (r *MemoryRepository) ConflictingTickets(ctx context.Context, arg db.ConflictingTicketsParams) (db.Ticket, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// The lowest id covering either day, as a scan in id order would find
+	conflict := int64(0)
+
+	for _, day := range []int64{arg.StartDate, arg.EndDate} {
+		for _, id := range r.ticketsByPlateDay[plateDay{plate: arg.PlateNumber, day: day}] {
+			if r.tickets[id-1].Voided != 0 {
+				continue
+			}
+
+			if conflict == 0 || id < conflict {
+				conflict = id
+			}
+
+			break
+		}
+	}
+
+	if conflict == 0 {
+		return db.Ticket{}, sql.ErrNoRows
+	}
+
+	return r.tickets[conflict-1], nil
+}
+
+func (r *MemoryRepository) StoreTicket(ctx context.Context, arg db.StoreTicketParams) (int64, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	ticket := db.Ticket{
+		ID:             int64(len(r.tickets) + 1),
+		PlateNumber:    arg.PlateNumber,
+		RoadID:         arg.RoadID,
+		Mile1:          arg.Mile1,
+		Timestamp1:     arg.Timestamp1,
+		Mile2:          arg.Mile2,
+		Timestamp2:     arg.Timestamp2,
+		Speed:          arg.Speed,
+		DayStartRange:  arg.DayStartRange,
+		DayEndRange:    arg.DayEndRange,
+		IsProcessed:    arg.IsProcessed,
+		ObservationID1: arg.ObservationID1,
+		ObservationID2: arg.ObservationID2,
+		CreatedAt:      arg.CreatedAt,
+		Severity:       arg.Severity,
+	}
+
+	r.tickets = append(r.tickets, ticket)
+
+	for day := ticket.DayStartRange; day <= ticket.DayEndRange; day++ {
+		key := plateDay{plate: ticket.PlateNumber, day: day}
+		r.ticketsByPlateDay[key] = append(r.ticketsByPlateDay[key], ticket.ID)
+	}
+
+	return ticket.ID, nil
+}
+
+// Tickets for which keep returns true, in id order
+func (r *MemoryRepository) filterTickets(keep func(ticket db.Ticket) bool) []db.Ticket {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var tickets []db.Ticket
+
+	for _, ticket := range r.tickets {
+		if keep(ticket) {
+			tickets = append(tickets, ticket)
+		}
+	}
+
+	return tickets
+}
+
+func isPending(ticket db.Ticket) bool {
+	return ticket.IsProcessed == 0 && ticket.Voided == 0
+}
+
+func (r *MemoryRepository) GetUnProcessedTickets(ctx context.Context) ([]db.Ticket, error) {
+	return r.filterTickets(isPending), nil
+}
+
+func (r *MemoryRepository) CountPendingTickets(ctx context.Context) (int64, error) {
+	return int64(len(r.filterTickets(isPending))), nil
+}
+
+func (r *MemoryRepository) GetUnProcessedTicketsForRoad(ctx context.Context, roadID int64) ([]db.Ticket, error) {
+	return r.filterTickets(func(ticket db.Ticket) bool {
+		return isPending(ticket) && ticket.RoadID == roadID
+	}), nil
+}
+
+func (r *MemoryRepository) GetTicket(ctx context.Context, id int64) (db.Ticket, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if id < 1 || id > int64(len(r.tickets)) {
+		return db.Ticket{}, sql.ErrNoRows
+	}
+
+	return r.tickets[id-1], nil
+}
+
+func (r *MemoryRepository) ListTickets(ctx context.Context) ([]db.Ticket, error) {
+	return r.filterTickets(func(ticket db.Ticket) bool { return true }), nil
+}
+
+// Ordered by the first timestamp and id
+func (r *MemoryRepository) ListTicketsInTimeRange(ctx context.Context, arg db.ListTicketsInTimeRangeParams) ([]db.Ticket, error) {
+	tickets := r.filterTickets(func(ticket db.Ticket) bool {
+		return ticket.Timestamp1 >= arg.MinTimestamp && ticket.Timestamp1 <= arg.MaxTimestamp
+	})
+
+	slices.SortStableFunc(tickets, func(a, b db.Ticket) int { return cmp.Compare(a.Timestamp1, b.Timestamp1) })
+
+	return tickets, nil
+}
+
+func (r *MemoryRepository) GetTicketsForPlate(ctx context.Context, plateNumber string) ([]db.Ticket, error) {
+	return r.filterTickets(func(ticket db.Ticket) bool { return ticket.PlateNumber == plateNumber }), nil
+}
+
+// Applies update to the ticket with id and returns how many tickets it
+// changed
+func (r *MemoryRepository) updateTicket(id int64, update func(ticket *db.Ticket)) int64 {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if id < 1 || id > int64(len(r.tickets)) {
+		return 0
+	}
+
+	update(&r.tickets[id-1])
+
+	return 1
+}
+
+func (r *MemoryRepository) MarkTicketAsProcessed(ctx context.Context, id int64) error {
+	r.updateTicket(id, func(ticket *db.Ticket) { ticket.IsProcessed = 1 })
+
+	return nil
+}
+
+func (r *MemoryRepository) RequeueTicket(ctx context.Context, id int64) (int64, error) {
+	return r.updateTicket(id, func(ticket *db.Ticket) {
+		ticket.IsProcessed = 0
+		ticket.Voided = 0
+	}), nil
+}
+
+func (r *MemoryRepository) VoidTicket(ctx context.Context, id int64) (int64, error) {
+	return r.updateTicket(id, func(ticket *db.Ticket) { ticket.Voided = 1 }), nil
+}
+
+func (r *MemoryRepository) ConflictingTicketClaim(ctx context.Context, arg db.ConflictingTicketClaimParams) (db.TicketClaim, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, claim := range r.ticketClaims[arg.PlateNumber] {
+		if (arg.StartDate >= claim.DayStartRange && arg.StartDate <= claim.DayEndRange) ||
+			(arg.EndDate >= claim.DayStartRange && arg.EndDate <= claim.DayEndRange) {
+			return claim, nil
+		}
+	}
+
+	return db.TicketClaim{}, sql.ErrNoRows
+}
+
+func (r *MemoryRepository) InsertTicketClaim(ctx context.Context, arg db.InsertTicketClaimParams) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.lastClaimId++
+	r.ticketClaims[arg.PlateNumber] = append(r.ticketClaims[arg.PlateNumber], db.TicketClaim{
+		ID:            r.lastClaimId,
+		PlateNumber:   arg.PlateNumber,
+		DayStartRange: arg.DayStartRange,
+		DayEndRange:   arg.DayEndRange,
+		NodeID:        arg.NodeID,
+	})
+
+	return nil
+}
+
+func (r *MemoryRepository) DeleteTicketClaim(ctx context.Context, arg db.DeleteTicketClaimParams) (int64, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	claims := r.ticketClaims[arg.PlateNumber]
+
+	for i, claim := range claims {
+		if claim.DayStartRange == arg.DayStartRange && claim.DayEndRange == arg.DayEndRange {
+			r.ticketClaims[arg.PlateNumber] = slices.Delete(slices.Clone(claims), i, i+1)
+			return 1, nil
+		}
+	}
+
+	return 0, nil
+}
+
+func (r *MemoryRepository) FindDispatchersForRoad(ctx context.Context, roadID int64) ([]string, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var dispatcherIds []string
+
+	for _, dispatcher := range r.dispatchers {
+		if dispatcher.RoadID == roadID {
+			dispatcherIds = append(dispatcherIds, dispatcher.DispatcherID)
+		}
+	}
+
+	return dispatcherIds, nil
+}
+
+func (r *MemoryRepository) AddDispatcherForRoad(ctx context.Context, arg db.AddDispatcherForRoadParams) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.lastDispatcherId++
+	r.dispatchers = append(r.dispatchers, db.Dispatcher{ID: r.lastDispatcherId, RoadID: arg.RoadID, DispatcherID: arg.DispatcherID})
+
+	return nil
+}
+
+func (r *MemoryRepository) RemoveDispatcher(ctx context.Context, dispatcherID string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.dispatchers = slices.DeleteFunc(r.dispatchers, func(dispatcher db.Dispatcher) bool {
+		return dispatcher.DispatcherID == dispatcherID
+	})
+
+	return nil
+}
+
+func (r *MemoryRepository) RemoveAllDispatchers(ctx context.Context) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.dispatchers = nil
+
+	return nil
+}
+
+func (r *MemoryRepository) InsertTicketEvent(ctx context.Context, arg db.InsertTicketEventParams) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.lastEventId++
+	r.ticketEvents[arg.TicketID] = append(r.ticketEvents[arg.TicketID], db.TicketEvent{
+		ID:           r.lastEventId,
+		TicketID:     arg.TicketID,
+		Event:        arg.Event,
+		CreatedAt:    arg.CreatedAt,
+		DispatcherID: arg.DispatcherID,
+		Detail:       arg.Detail,
+	})
+
+	return nil
+}
+
+func (r *MemoryRepository) GetTicketEvents(ctx context.Context, ticketID int64) ([]db.TicketEvent, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return slices.Clone(r.ticketEvents[ticketID]), nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Metrics are written in the Prometheus text exposition format
+type Metric interface {
+	write(w io.Writer)
+}
+
+type Counter struct {
+	name  string
+	help  string
+	value atomic.Uint64
+}
+
+func newCounter(name string, help string) *Counter {
+	return &Counter{name: name, help: help}
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	c.value.Add(n)
+}
+
+func (c *Counter) write(w io.Writer) {
+	writeHeader(w, c.name, c.help, "counter")
+	fmt.Fprintf(w, "%s %d\n", c.name, c.value.Load())
+}
+
+// Counter partitioned by the value of a single label
+type CounterVec struct {
+	name  string
+	help  string
+	label string
+
+	lock   sync.Mutex
+	values map[string]uint64
+}
+
+func newCounterVec(name string, help string, label string) *CounterVec {
+	return &CounterVec{name: name, help: help, label: label, values: map[string]uint64{}}
+}
+
+func (c *CounterVec) Inc(labelValue string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.values[labelValue]++
+}
+
+func (c *CounterVec) write(w io.Writer) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	writeHeader(w, c.name, c.help, "counter")
+
+	labelValues := make([]string, 0, len(c.values))
+
+	for labelValue := range c.values {
+		labelValues = append(labelValues, labelValue)
+	}
+
+	slices.Sort(labelValues)
+
+	for _, labelValue := range labelValues {
+		fmt.Fprintf(w, "%s{%s=\"%s\"} %d\n", c.name, c.label, escapeLabelValue(labelValue), c.values[labelValue])
+	}
+}
+
+type Histogram struct {
+	name string
+	help string
+
+	lock sync.Mutex
+
+	// Upper bounds of the buckets in ascending order, without +Inf
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(name string, help string, buckets []float64) *Histogram {
+	return &Histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *Histogram) Observe(value float64) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for i, bucket := range h.buckets {
+		if value <= bucket {
+			h.counts[i]++
+		}
+	}
+
+	h.sum += value
+	h.count++
+}
+
+func (h *Histogram) write(w io.Writer) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	writeHeader(w, h.name, h.help, "histogram")
+
+	// Bucket counts are cumulative
+	for i, bucket := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatFloat(bucket), h.counts[i])
+	}
+
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
+
+var (
+	connectionsTotal      = newCounter("speed_daemon_connections_total", "Connections accepted")
+	platesTotal           = newCounter("speed_daemon_plates_total", "Plate observations received from cameras")
+	heartbeatsTotal       = newCounter("speed_daemon_heartbeats_sent_total", "Heartbeats sent to clients")
+	ticketsCreated        = newCounter("speed_daemon_tickets_created_total", "Tickets generated")
+	ticketsDelivered      = newCounter("speed_daemon_tickets_delivered_total", "Tickets written to a dispatcher")
+	deliveryFailures      = newCounter("speed_daemon_ticket_delivery_failures_total", "Ticket writes to a dispatcher which failed")
+	ticketsSuppressed     = newCounter("speed_daemon_tickets_suppressed_total", "Tickets not generated because the plate was already ticketed on one of the days")
+	ticketsForwarded      = newCounter("speed_daemon_tickets_forwarded_total", "Tickets received from the node which owns their road")
+	ticketsReturned       = newCounter("speed_daemon_tickets_returned_total", "Tickets returned to the node which owns their road because no dispatcher here could take them")
+	observationsCompacted = newCounter("speed_daemon_observations_compacted_total", "Observations deleted by the retention policy")
+	observationsExpired   = newCounter("speed_daemon_observations_expired_total", "Observations not checked for tickets because they arrived older than the retention")
+	clientErrors          = newCounterVec("speed_daemon_client_errors_total", "Error messages sent to clients", "reason")
+	limitConflicts        = newCounterVec("speed_daemon_limit_conflicts_total", "Cameras which declared a different speed limit than their road, by how the conflict was resolved", "outcome")
+
+	observationToTicketSeconds = newHistogram(
+		"speed_daemon_observation_to_ticket_seconds",
+		"Time from receiving the observation which triggered a ticket to generating the ticket",
+		[]float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+	)
+)
+
+var registeredMetrics = []Metric{
+	connectionsTotal,
+	platesTotal,
+	heartbeatsTotal,
+	ticketsCreated,
+	ticketsDelivered,
+	deliveryFailures,
+	ticketsSuppressed,
+	ticketsForwarded,
+	ticketsReturned,
+	observationsCompacted,
+	observationsExpired,
+	clientErrors,
+	limitConflicts,
+	observationToTicketSeconds,
+}
+
+type MetricsHandler struct {
+	queries Repository
+}
+
+// NewMetricsHandler serves the metrics in the Prometheus text format on
+// /metrics
+func NewMetricsHandler(queries Repository) http.Handler {
+	metrics := &MetricsHandler{queries: queries}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /metrics", metrics.serveMetrics)
+
+	return mux
+}
+
+func (m *MetricsHandler) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	pendingTickets, err := m.queries.CountPendingTickets(r.Context())
+
+	if err != nil {
+		log.Printf("error: counting pending tickets: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	cameras := 0
+	dispatchers := 0
+
+	for _, client := range listClients() {
+		role, _, _ := client.identity()
+
+		switch role {
+		case RoleCamera:
+			cameras++
+		case RoleDispatcher:
+			dispatchers++
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	writeGauge(w, "speed_daemon_connected_cameras", "Cameras currently connected", float64(cameras))
+	writeGauge(w, "speed_daemon_connected_dispatchers", "Dispatchers currently connected", float64(dispatchers))
+	writeGauge(w, "speed_daemon_pending_tickets", "Tickets which are neither delivered nor voided", float64(pendingTickets))
+
+	for _, metric := range registeredMetrics {
+		metric.write(w)
+	}
+}
+
+func writeGauge(w io.Writer, name string, help string, value float64) {
+	writeHeader(w, name, help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", name, formatFloat(value))
+}
+
+func writeHeader(w io.Writer, name string, help string, metricType string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
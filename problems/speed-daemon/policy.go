@@ -0,0 +1,128 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"time"
+)
+
+// Roads and miles at which a camera may be installed
+type CameraGrant struct {
+	Road uint16 `json:"road"`
+
+	// Any mile of the road is allowed when empty
+	Miles []uint16 `json:"miles"`
+}
+
+// Policy maps client certificate identities to the registrations they are
+// allowed to make. Identities are the common name of the certificate subject.
+//
+//	{
+//	  "cameras": {"camera-a8": [{"road": 8, "miles": [10, 20]}]},
+//	  "dispatchers": {"dispatch-north": [8, 9]}
+//	}
+type Policy struct {
+	Cameras     map[string][]CameraGrant `json:"cameras"`
+	Dispatchers map[string][]uint16      `json:"dispatchers"`
+}
+
+func LoadPolicy(path string) (*Policy, error) {
+	content, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	policy := &Policy{}
+
+	if err := json.Unmarshal(content, policy); err != nil {
+		return nil, fmt.Errorf("parsing policy %s: %w", path, err)
+	}
+
+	return policy, nil
+}
+
+func (p *Policy) AllowsCamera(identity string, road uint16, mile uint16) bool {
+	for _, grant := range p.Cameras[identity] {
+		if grant.Road != road {
+			continue
+		}
+
+		if len(grant.Miles) == 0 || slices.Contains(grant.Miles, mile) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (p *Policy) AllowsDispatcher(identity string, roads []uint16) bool {
+	allowedRoads, ok := p.Dispatchers[identity]
+
+	if !ok {
+		return false
+	}
+
+	for _, road := range roads {
+		if !slices.Contains(allowedRoads, road) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Server TLS config which requires clients to present a certificate signed by
+// the CA in clientCAPath
+func NewTLSConfig(certPath string, keyPath string, clientCAPath string) (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(certPath, keyPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	clientCA, err := os.ReadFile(clientCAPath)
+
+	if err != nil {
+		return nil, err
+	}
+
+	clientCAs := x509.NewCertPool()
+
+	if !clientCAs.AppendCertsFromPEM(clientCA) {
+		return nil, fmt.Errorf("no certificates found in %s", clientCAPath)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		ClientCAs:    clientCAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+const tlsHandshakeTimeout = 10 * time.Second
+
+// Completes the handshake of a TLS connection and returns the common name of
+// the client certificate
+func tlsIdentity(conn *tls.Conn) (string, error) {
+	conn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	if err := conn.Handshake(); err != nil {
+		return "", err
+	}
+
+	certificates := conn.ConnectionState().PeerCertificates
+
+	if len(certificates) == 0 || certificates[0].Subject.CommonName == "" {
+		return "", errors.New("client certificate has no common name")
+	}
+
+	return certificates[0].Subject.CommonName, nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Session files start with this magic followed by the session start time in
+// unix nanoseconds and the length prefixed remote address. Every entry is a
+// direction byte, the offset from the session start in nanoseconds, the length
+// of the data and the data itself.
+const sessionMagic = "SPEEDSESSION1"
+
+const (
+	// Bytes sent by the client
+	DirectionInbound byte = '<'
+	// Bytes sent by the server
+	DirectionOutbound byte = '>'
+)
+
+type SessionEntry struct {
+	direction byte
+	offset    time.Duration
+	data      []byte
+}
+
+type Session struct {
+	path       string
+	start      time.Time
+	remoteAddr string
+	entries    []SessionEntry
+}
+
+// RecordingConn writes every byte read from and written to the connection to
+// a session file
+type RecordingConn struct {
+	net.Conn
+
+	lock  sync.Mutex
+	file  *os.File
+	start time.Time
+}
+
+// Creates a session file for the connection in dir
+func NewRecordingConn(conn net.Conn, dir string, id string) (*RecordingConn, error) {
+	start := time.Now()
+	path := filepath.Join(dir, fmt.Sprintf("%d-%s.session", start.UnixNano(), id))
+
+	file, err := os.Create(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	remoteAddr := conn.RemoteAddr().String()
+
+	header := []byte(sessionMagic)
+	header = binary.BigEndian.AppendUint64(header, uint64(start.UnixNano()))
+	header = append(header, byte(len(remoteAddr)))
+	header = append(header, remoteAddr...)
+
+	if _, err := file.Write(header); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &RecordingConn{Conn: conn, file: file, start: start}, nil
+}
+
+func (c *RecordingConn) record(direction byte, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry := []byte{direction}
+	entry = binary.BigEndian.AppendUint64(entry, uint64(time.Since(c.start)))
+	entry = binary.BigEndian.AppendUint32(entry, uint32(len(data)))
+	entry = append(entry, data...)
+
+	// Recording is best effort and must never break the connection
+	c.file.Write(entry)
+}
+
+func (c *RecordingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.record(DirectionInbound, b[:n])
+	return n, err
+}
+
+func (c *RecordingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.record(DirectionOutbound, b[:n])
+	return n, err
+}
+
+func (c *RecordingConn) Close() error {
+	err := c.Conn.Close()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if fileErr := c.file.Close(); fileErr != nil && !errors.Is(fileErr, os.ErrClosed) {
+		return fileErr
+	}
+
+	return err
+}
+
+func ReadSession(path string) (*Session, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+
+	magic := make([]byte, len(sessionMagic))
+
+	if _, err := io.ReadFull(reader, magic); err != nil || string(magic) != sessionMagic {
+		return nil, fmt.Errorf("%s is not a session file", path)
+	}
+
+	var start uint64
+
+	if err := binary.Read(reader, binary.BigEndian, &start); err != nil {
+		return nil, err
+	}
+
+	remoteAddrLength, err := reader.ReadByte()
+
+	if err != nil {
+		return nil, err
+	}
+
+	remoteAddr := make([]byte, remoteAddrLength)
+
+	if _, err := io.ReadFull(reader, remoteAddr); err != nil {
+		return nil, err
+	}
+
+	session := &Session{
+		path:       path,
+		start:      time.Unix(0, int64(start)),
+		remoteAddr: string(remoteAddr),
+	}
+
+	for {
+		direction, err := reader.ReadByte()
+
+		if errors.Is(err, io.EOF) {
+			return session, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		var offset uint64
+		var length uint32
+
+		if err := binary.Read(reader, binary.BigEndian, &offset); err != nil {
+			return nil, err
+		}
+
+		if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+			return nil, err
+		}
+
+		data := make([]byte, length)
+
+		// The server might have been killed in the middle of an entry
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return session, nil
+		}
+
+		session.entries = append(session.entries, SessionEntry{
+			direction: direction,
+			offset:    time.Duration(offset),
+			data:      data,
+		})
+	}
+}
+
+// Concatenation of the data sent in one direction
+func (s *Session) stream(direction byte) []byte {
+	stream := []byte{}
+
+	for _, entry := range s.entries {
+		if entry.direction == direction {
+			stream = append(stream, entry.data...)
+		}
+	}
+
+	return stream
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/nivekithan/go-network/problems/speed-daemon/client"
+)
+
+// Messages sent by the server in a single session
+type ServerOutput struct {
+	tickets []client.Ticket
+	errors  []string
+}
+
+func parseServerOutput(stream []byte) ServerOutput {
+	output := ServerOutput{}
+	reader := bytes.NewReader(stream)
+
+	for {
+		message, err := client.ReadMessage(reader)
+
+		if err != nil {
+			// A truncated message at the end of the stream is ignored
+			if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+				log.Printf("error parsing server output: %v", err)
+			}
+
+			return output
+		}
+
+		switch message := message.(type) {
+		case *client.Ticket:
+			output.tickets = append(output.tickets, *message)
+		case *client.ServerError:
+			output.errors = append(output.errors, message.Msg)
+		}
+	}
+}
+
+// Feeds the inbound bytes of a session to the server at the time they were
+// recorded, relative to origin
+func replaySession(conn net.Conn, session *Session, origin time.Time, start time.Time, speed float64) error {
+	sessionOffset := session.start.Sub(origin)
+
+	for _, entry := range session.entries {
+		if entry.direction != DirectionInbound {
+			continue
+		}
+
+		sendAt := start.Add(time.Duration(float64(sessionOffset+entry.offset) / speed))
+		time.Sleep(time.Until(sendAt))
+
+		if _, err := conn.Write(entry.data); err != nil {
+			return fmt.Errorf("replaying %s: %w", session.path, err)
+		}
+	}
+
+	return nil
+}
+
+// Sends recorded sessions to a server and compares the tickets and errors it
+// produces with the recorded ones. The server should start empty, like the
+// one which was recorded.
+// Usage: speed-daemon replay [-addr <host:port>] [-speed <factor>] [-settle <duration>] [-strict] <session files>...
+func runReplay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+
+	addr := flags.String("addr", "localhost:8000", "address of the server to replay the sessions against")
+	speed := flags.Float64("speed", 1, "replay speed. 2 replays twice as fast as recorded")
+	settle := flags.Duration("settle", 2*time.Second, "how long to wait for tickets after the last message was sent")
+	strict := flags.Bool("strict", false, "fail if a ticket for the same plate and day was issued for different observations")
+
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		return errors.New("replay: no session files")
+	}
+
+	if *speed <= 0 {
+		return fmt.Errorf("replay: invalid speed %v", *speed)
+	}
+
+	sessions := []*Session{}
+
+	for _, path := range flags.Args() {
+		session, err := ReadSession(path)
+
+		if err != nil {
+			return err
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	origin := sessions[0].start
+
+	for _, session := range sessions {
+		if session.start.Before(origin) {
+			origin = session.start
+		}
+	}
+
+	conns := make([]net.Conn, len(sessions))
+	outputs := make([][]byte, len(sessions))
+
+	for i := range sessions {
+		conn, err := net.Dial("tcp", *addr)
+
+		if err != nil {
+			return err
+		}
+
+		defer conn.Close()
+
+		conns[i] = conn
+	}
+
+	log.Printf("Replaying %d sessions against %s", len(sessions), *addr)
+
+	readers := sync.WaitGroup{}
+	writers := sync.WaitGroup{}
+	start := time.Now()
+
+	for i, session := range sessions {
+		readers.Go(func() {
+			outputs[i], _ = io.ReadAll(conns[i])
+		})
+
+		writers.Go(func() {
+			if err := replaySession(conns[i], session, origin, start, *speed); err != nil {
+				log.Println(err)
+			}
+		})
+	}
+
+	writers.Wait()
+	time.Sleep(*settle)
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+
+	readers.Wait()
+
+	return diffSessions(sessions, outputs, *strict)
+}
+
+// Tickets are matched on plate and day. Which pair of observations ends up on
+// the ticket of a day depends on the order in which observations arrive, so
+// differing details only fail the replay in strict mode.
+type TicketKey struct {
+	plate string
+	day   uint32
+}
+
+func newTicketKey(ticket client.Ticket) TicketKey {
+	return TicketKey{plate: ticket.Plate, day: ticket.Timestamp1 / 86400}
+}
+
+func diffSessions(sessions []*Session, outputs [][]byte, strict bool) error {
+	recordedTickets := map[TicketKey][]client.Ticket{}
+	replayedTickets := map[TicketKey][]client.Ticket{}
+	recordedCount := 0
+	replayedCount := 0
+	errorMismatches := 0
+
+	for i, session := range sessions {
+		recorded := parseServerOutput(session.stream(DirectionOutbound))
+		replayed := parseServerOutput(outputs[i])
+
+		for _, ticket := range recorded.tickets {
+			key := newTicketKey(ticket)
+			recordedTickets[key] = append(recordedTickets[key], ticket)
+			recordedCount++
+		}
+
+		for _, ticket := range replayed.tickets {
+			key := newTicketKey(ticket)
+			replayedTickets[key] = append(replayedTickets[key], ticket)
+			replayedCount++
+		}
+
+		// Errors belong to a connection, unlike tickets which can be delivered
+		// to any dispatcher of the road
+		if !slices.Equal(recorded.errors, replayed.errors) {
+			errorMismatches++
+			fmt.Printf("session %s: recorded errors %q, replayed errors %q\n", session.path, recorded.errors, replayed.errors)
+		}
+	}
+
+	missing := 0
+	unexpected := 0
+	changed := 0
+
+	for key, recorded := range recordedTickets {
+		replayed := replayedTickets[key]
+
+		for _, ticket := range recorded[min(len(replayed), len(recorded)):] {
+			missing++
+			fmt.Printf("- %+v\n", ticket)
+		}
+
+		for i := range min(len(replayed), len(recorded)) {
+			if recorded[i] != replayed[i] {
+				changed++
+				fmt.Printf("~ %+v\n  %+v\n", recorded[i], replayed[i])
+			}
+		}
+	}
+
+	for key, replayed := range replayedTickets {
+		recorded := recordedTickets[key]
+
+		for _, ticket := range replayed[min(len(replayed), len(recorded)):] {
+			unexpected++
+			fmt.Printf("+ %+v\n", ticket)
+		}
+	}
+
+	fmt.Printf("recorded tickets:   %d\n", recordedCount)
+	fmt.Printf("replayed tickets:   %d\n", replayedCount)
+	fmt.Printf("missing tickets:    %d\n", missing)
+	fmt.Printf("unexpected tickets: %d\n", unexpected)
+	fmt.Printf("changed tickets:    %d\n", changed)
+	fmt.Printf("error mismatches:   %d\n", errorMismatches)
+
+	if missing > 0 || unexpected > 0 || errorMismatches > 0 || (strict && changed > 0) {
+		return errors.New("replay differs from the recording")
+	}
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nivekithan/go-network/problems/speed-daemon/db"
+)
+
+// Repository is everything the speed daemon stores. Its methods mirror the
+// queries in sql/queries.sql, so *db.Queries implements it on SQLite and
+// MemoryRepository implements it in process memory. Single rows which do not
+// exist are reported as sql.ErrNoRows by both.
+type Repository interface {
+	InsertRoad(ctx context.Context, arg db.InsertRoadParams) (int64, error)
+	GetRoad(ctx context.Context, id int64) (db.Road, error)
+	ListRoads(ctx context.Context) ([]db.Road, error)
+	LowerRoadSpeedLimit(ctx context.Context, arg db.LowerRoadSpeedLimitParams) error
+
+	InsertCameraLimit(ctx context.Context, arg db.InsertCameraLimitParams) error
+	CountLimitConflicts(ctx context.Context) (int64, error)
+	GetLimitConflicts(ctx context.Context, limit int64) ([]db.CameraLimit, error)
+
+	InsertPlateObservation(ctx context.Context, arg db.InsertPlateObservationParams) (int64, error)
+	GetPreviousObservation(ctx context.Context, arg db.GetPreviousObservationParams) (db.PlateObservation, error)
+	GetNextObservation(ctx context.Context, arg db.GetNextObservationParams) (db.PlateObservation, error)
+	GetObservationById(ctx context.Context, id int64) (db.PlateObservation, error)
+	GetObservationsForPlate(ctx context.Context, plateNumber string) ([]db.PlateObservation, error)
+	GetLatestPlateObservationTimestamp(ctx context.Context, arg db.GetLatestPlateObservationTimestampParams) (int64, error)
+	DeleteExpiredObservations(ctx context.Context, retention int64) (int64, error)
+
+	ConflictingTickets(ctx context.Context, arg db.ConflictingTicketsParams) (db.Ticket, error)
+	StoreTicket(ctx context.Context, arg db.StoreTicketParams) (int64, error)
+	GetUnProcessedTickets(ctx context.Context) ([]db.Ticket, error)
+	CountPendingTickets(ctx context.Context) (int64, error)
+	GetUnProcessedTicketsForRoad(ctx context.Context, roadID int64) ([]db.Ticket, error)
+	GetTicket(ctx context.Context, id int64) (db.Ticket, error)
+	ListTickets(ctx context.Context) ([]db.Ticket, error)
+	ListTicketsInTimeRange(ctx context.Context, arg db.ListTicketsInTimeRangeParams) ([]db.Ticket, error)
+	GetTicketsForPlate(ctx context.Context, plateNumber string) ([]db.Ticket, error)
+	MarkTicketAsProcessed(ctx context.Context, id int64) error
+	RequeueTicket(ctx context.Context, id int64) (int64, error)
+	VoidTicket(ctx context.Context, id int64) (int64, error)
+
+	ConflictingTicketClaim(ctx context.Context, arg db.ConflictingTicketClaimParams) (db.TicketClaim, error)
+	InsertTicketClaim(ctx context.Context, arg db.InsertTicketClaimParams) error
+	DeleteTicketClaim(ctx context.Context, arg db.DeleteTicketClaimParams) (int64, error)
+
+	FindDispatchersForRoad(ctx context.Context, roadID int64) ([]string, error)
+	AddDispatcherForRoad(ctx context.Context, arg db.AddDispatcherForRoadParams) error
+	RemoveDispatcher(ctx context.Context, dispatcherID string) error
+	RemoveAllDispatchers(ctx context.Context) error
+
+	InsertTicketEvent(ctx context.Context, arg db.InsertTicketEventParams) error
+	GetTicketEvents(ctx context.Context, ticketID int64) ([]db.TicketEvent, error)
+}
+
+var _ Repository = (*db.Queries)(nil)
+
+const (
+	StorageSQLite = "sqlite"
+	StorageMemory = "memory"
+)
+
+// Opens the repository of storage. dbPath is only used by sqlite
+func openRepository(ctx context.Context, storage string, dbPath string) (Repository, error) {
+	switch storage {
+	case StorageSQLite:
+		queries, err := openDatabase(ctx, dbPath)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return queries, nil
+	case StorageMemory:
+		if dbPath != "" {
+			return nil, errors.New("-db requires -storage sqlite")
+		}
+
+		return NewMemoryRepository(), nil
+	}
+
+	return nil, fmt.Errorf("unknown storage %s", storage)
+}
@@ -0,0 +1,713 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/nivekithan/go-network/problems/speed-daemon/db"
+)
+
+// Every repository runs the same tests. newRepository creates an empty one
+var testRepositories = []struct {
+	name          string
+	newRepository func(t testing.TB) Repository
+}{
+	{
+		name: StorageMemory,
+		newRepository: func(t testing.TB) Repository {
+			return NewMemoryRepository()
+		},
+	},
+	{
+		name: StorageSQLite,
+		newRepository: func(t testing.TB) Repository {
+			queries, err := openDatabase(context.Background(), filepath.Join(t.TempDir(), "speed-daemon.db"))
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			return queries
+		},
+	},
+}
+
+// Stores a pending ticket of plate on road 1 for the days from start to end
+func storeTestTicket(ctx context.Context, repo Repository, plate string, timestamp int64, start int64, end int64) (int64, error) {
+	return repo.StoreTicket(ctx, db.StoreTicketParams{
+		PlateNumber:   plate,
+		RoadID:        1,
+		Mile1:         0,
+		Timestamp1:    timestamp,
+		Mile2:         10,
+		Timestamp2:    timestamp + 300,
+		Speed:         12000,
+		DayStartRange: start,
+		DayEndRange:   end,
+	})
+}
+
+func observe(ctx context.Context, repo Repository, plate string, road int64, timestamp int64) (int64, error) {
+	return repo.InsertPlateObservation(ctx, db.InsertPlateObservationParams{
+		PlateNumber: plate,
+		RoadID:      road,
+		Timestamp:   timestamp,
+		Location:    timestamp / 10,
+	})
+}
+
+// Calls every function in order and stops at the first error
+func setUp(steps ...func() error) error {
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func ticketIds(tickets []db.Ticket, err error) ([]int64, error) {
+	ids := []int64{}
+
+	for _, ticket := range tickets {
+		ids = append(ids, ticket.ID)
+	}
+
+	return ids, err
+}
+
+func TestRepositories(t *testing.T) {
+	tests := []struct {
+		name string
+
+		// Fills the repository and returns the result of the call under
+		// test
+		run     func(ctx context.Context, repo Repository) (any, error)
+		want    any
+		wantErr error
+	}{
+		{
+			name: "road",
+			run: func(ctx context.Context, repo Repository) (any, error) {
+				if _, err := repo.InsertRoad(ctx, db.InsertRoadParams{ID: 7, SpeedLimit: 60}); err != nil {
+					return nil, err
+				}
+
+				return repo.GetRoad(ctx, 7)
+			},
+			want: db.Road{ID: 7, SpeedLimit: 60},
+		},
+		{
+			name: "missing road",
+			run: func(ctx context.Context, repo Repository) (any, error) {
+				return repo.GetRoad(ctx, 7)
+			},
+			wantErr: sql.ErrNoRows,
+		},
+		{
+			name: "road inserted twice",
+			run: func(ctx context.Context, repo Repository) (any, error) {
+				if _, err := repo.InsertRoad(ctx, db.InsertRoadParams{ID: 7, SpeedLimit: 60}); err != nil {
+					return nil, err
+				}
+
+				inserted, err := repo.InsertRoad(ctx, db.InsertRoadParams{ID: 7, SpeedLimit: 80})
+
+				if err != nil {
+					return nil, err
+				}
+
+				road, err := repo.GetRoad(ctx, 7)
+
+				return []any{inserted, road}, err
+			},
+			want: []any{int64(0), db.Road{ID: 7, SpeedLimit: 60}},
+		},
+		{
+			name: "roads in id order with lowered limits",
+			run: func(ctx context.Context, repo Repository) (any, error) {
+				for _, road := range []db.InsertRoadParams{{ID: 3, SpeedLimit: 50}, {ID: 1, SpeedLimit: 60}, {ID: 2, SpeedLimit: 70}} {
+					if _, err := repo.InsertRoad(ctx, road); err != nil {
+						return nil, err
+					}
+				}
+
+				for _, lowered := range []db.LowerRoadSpeedLimitParams{{ID: 1, SpeedLimit: 40}, {ID: 2, SpeedLimit: 90}} {
+					if err := repo.LowerRoadSpeedLimit(ctx, lowered); err != nil {
+						return nil, err
+					}
+				}
+
+				return repo.ListRoads(ctx)
+			},
+			want: []db.Road{{ID: 1, SpeedLimit: 40}, {ID: 2, SpeedLimit: 70}, {ID: 3, SpeedLimit: 50}},
+		},
+		{
+			name: "limit conflicts newest first",
+			run: func(ctx context.Context, repo Repository) (any, error) {
+				for i, conflict := range []int64{1, 0, 1, 1} {
+					err := repo.InsertCameraLimit(ctx, db.InsertCameraLimitParams{
+						RoadID:         1,
+						Mile:           int64(i),
+						SpeedLimit:     60 + conflict,
+						RoadSpeedLimit: 60,
+						ClientID:       "camera",
+						Conflict:       conflict,
+						CreatedAt:      int64(i),
+					})
+
+					if err != nil {
+						return nil, err
+					}
+				}
+
+				count, err := repo.CountLimitConflicts(ctx)
+
+				if err != nil {
+					return nil, err
+				}
+
+				conflicts, err := repo.GetLimitConflicts(ctx, 2)
+
+				miles := []int64{}
+
+				for _, conflict := range conflicts {
+					miles = append(miles, conflict.Mile)
+				}
+
+				return []any{count, miles}, err
+			},
+			want: []any{int64(3), []int64{3, 2}},
+		},
+		{
+			name: "previous and next observation",
+			run: func(ctx context.Context, repo Repository) (any, error) {
+				err := setUp(
+					func() error { _, err := observe(ctx, repo, "PLATE", 1, 300); return err },
+					func() error { _, err := observe(ctx, repo, "PLATE", 1, 100); return err },
+					func() error { _, err := observe(ctx, repo, "PLATE", 2, 200); return err },
+					func() error { _, err := observe(ctx, repo, "OTHER", 1, 200); return err },
+					func() error { _, err := observe(ctx, repo, "PLATE", 1, 500); return err },
+				)
+
+				if err != nil {
+					return nil, err
+				}
+
+				previous, err := repo.GetPreviousObservation(ctx, db.GetPreviousObservationParams{PlateNumber: "PLATE", RoadID: 1, Timestamp: 300})
+
+				if err != nil {
+					return nil, err
+				}
+
+				next, err := repo.GetNextObservation(ctx, db.GetNextObservationParams{PlateNumber: "PLATE", RoadID: 1, Timestamp: 300})
+
+				return []int64{previous.ID, previous.Timestamp, next.ID, next.Timestamp}, err
+			},
+			want: []int64{2, 100, 5, 500},
+		},
+		{
+			name: "no previous observation",
+			run: func(ctx context.Context, repo Repository) (any, error) {
+				if _, err := observe(ctx, repo, "PLATE", 1, 300); err != nil {
+					return nil, err
+				}
+
+				return repo.GetPreviousObservation(ctx, db.GetPreviousObservationParams{PlateNumber: "PLATE", RoadID: 1, Timestamp: 300})
+			},
+			wantErr: sql.ErrNoRows,
+		},
+		{
+			name: "observation by id",
+			run: func(ctx context.Context, repo Repository) (any, error) {
+				id, err := observe(ctx, repo, "PLATE", 2, 300)
+
+				if err != nil {
+					return nil, err
+				}
+
+				return repo.GetObservationById(ctx, id)
+			},
+			want: db.PlateObservation{ID: 1, PlateNumber: "PLATE", Timestamp: 300, Location: 30, RoadID: 2},
+		},
+		{
+			name: "observations of a plate by road and timestamp",
+			run: func(ctx context.Context, repo Repository) (any, error) {
+				err := setUp(
+					func() error { _, err := observe(ctx, repo, "PLATE", 2, 100); return err },
+					func() error { _, err := observe(ctx, repo, "PLATE", 1, 300); return err },
+					func() error { _, err := observe(ctx, repo, "OTHER", 1, 200); return err },
+					func() error { _, err := observe(ctx, repo, "PLATE", 1, 200); return err },
+				)
+
+				if err != nil {
+					return nil, err
+				}
+
+				observations, err := repo.GetObservationsForPlate(ctx, "PLATE")
+				ids := []int64{}
+
+				for _, observation := range observations {
+					ids = append(ids, observation.ID)
+				}
+
+				return ids, err
+			},
+			want: []int64{4, 2, 1},
+		},
+		{
+			name: "latest observation timestamp",
+			run: func(ctx context.Context, repo Repository) (any, error) {
+				err := setUp(
+					func() error { _, err := observe(ctx, repo, "PLATE", 1, 300); return err },
+					func() error { _, err := observe(ctx, repo, "PLATE", 1, 100); return err },
+					func() error { _, err := observe(ctx, repo, "PLATE", 2, 900); return err },
+				)
+
+				if err != nil {
+					return nil, err
+				}
+
+				latest, err := repo.GetLatestPlateObservationTimestamp(ctx, db.GetLatestPlateObservationTimestampParams{PlateNumber: "PLATE", RoadID: 1})
+
+				if err != nil {
+					return nil, err
+				}
+
+				unseen, err := repo.GetLatestPlateObservationTimestamp(ctx, db.GetLatestPlateObservationTimestampParams{PlateNumber: "OTHER", RoadID: 1})
+
+				return []int64{latest, unseen}, err
+			},
+			want: []int64{300, 0},
+		},
+		{
+			name: "expired observations per plate and road",
+			run: func(ctx context.Context, repo Repository) (any, error) {
+				err := setUp(
+					func() error { _, err := observe(ctx, repo, "PLATE", 1, 100); return err },
+					func() error { _, err := observe(ctx, repo, "PLATE", 1, 1000); return err },
+					func() error { _, err := observe(ctx, repo, "PLATE", 1, 1200); return err },
+					func() error { _, err := observe(ctx, repo, "PLATE", 2, 100); return err },
+					func() error { _, err := observe(ctx, repo, "OTHER", 1, 150); return err },
+				)
+
+				if err != nil {
+					return nil, err
+				}
+
+				deleted, err := repo.DeleteExpiredObservations(ctx, 500)
+
+				if err != nil {
+					return nil, err
+				}
+
+				observations, err := repo.GetObservationsForPlate(ctx, "PLATE")
+				ids := []int64{}
+
+				for _, observation := range observations {
+					ids = append(ids, observation.ID)
+				}
+
+				return []any{deleted, ids}, err
+			},
+			want: []any{int64(1), []int64{2, 3, 4}},
+		},
+		{
+			name: "ticket",
+			run: func(ctx context.Context, repo Repository) (any, error) {
+				id, err := repo.StoreTicket(ctx, db.StoreTicketParams{
+					PlateNumber:    "PLATE",
+					RoadID:         1,
+					Mile1:          2,
+					Timestamp1:     3,
+					Mile2:          4,
+					Timestamp2:     5,
+					Speed:          6,
+					DayStartRange:  7,
+					DayEndRange:    8,
+					ObservationID1: 9,
+					ObservationID2: 10,
+					CreatedAt:      11,
+					Severity:       "major",
+				})
+
+				if err != nil {
+					return nil, err
+				}
+
+				return repo.GetTicket(ctx, id)
+			},
+			want: db.Ticket{
+				ID:             1,
+				PlateNumber:    "PLATE",
+				RoadID:         1,
+				Mile1:          2,
+				Timestamp1:     3,
+				Mile2:          4,
+				Timestamp2:     5,
+				Speed:          6,
+				DayStartRange:  7,
+				DayEndRange:    8,
+				ObservationID1: 9,
+				ObservationID2: 10,
+				CreatedAt:      11,
+				Severity:       "major",
+			},
+		},
+		{
+			name: "missing ticket",
+			run: func(ctx context.Context, repo Repository) (any, error) {
+				return repo.GetTicket(ctx, 1)
+			},
+			wantErr: sql.ErrNoRows,
+		},
+		{
+			name: "conflicting tickets",
+			run: func(ctx context.Context, repo Repository) (any, error) {
+				err := setUp(
+					func() error { _, err := storeTestTicket(ctx, repo, "PLATE", 0, 3, 4); return err },
+					func() error { _, err := storeTestTicket(ctx, repo, "OTHER", 0, 5, 5); return err },
+					func() error { _, err := storeTestTicket(ctx, repo, "PLATE", 0, 6, 6); return err },
+				)
+
+				if err != nil {
+					return nil, err
+				}
+
+				conflicts := []int64{}
+
+				for _, days := range []db.ConflictingTicketsParams{
+					{PlateNumber: "PLATE", StartDate: 4, EndDate: 5},
+					{PlateNumber: "PLATE", StartDate: 5, EndDate: 6},
+					{PlateNumber: "PLATE", StartDate: 2, EndDate: 3},
+				} {
+					ticket, err := repo.ConflictingTickets(ctx, days)
+
+					if err != nil {
+						return nil, err
+					}
+
+					conflicts = append(conflicts, ticket.ID)
+				}
+
+				return conflicts, nil
+			},
+			want: []int64{1, 3, 1},
+		},
+		{
+			name: "ticket inside the range does not conflict",
+			run: func(ctx context.Context, repo Repository) (any, error) {
+				if _, err := storeTestTicket(ctx, repo, "PLATE", 0, 5, 5); err != nil {
+					return nil, err
+				}
+
+				return repo.ConflictingTickets(ctx, db.ConflictingTicketsParams{PlateNumber: "PLATE", StartDate: 4, EndDate: 6})
+			},
+			wantErr: sql.ErrNoRows,
+		},
+		{
+			name: "conflict of another plate",
+			run: func(ctx context.Context, repo Repository) (any, error) {
+				if _, err := storeTestTicket(ctx, repo, "OTHER", 0, 5, 5); err != nil {
+					return nil, err
+				}
+
+				return repo.ConflictingTickets(ctx, db.ConflictingTicketsParams{PlateNumber: "PLATE", StartDate: 5, EndDate: 5})
+			},
+			wantErr: sql.ErrNoRows,
+		},
+		{
+			name: "voided tickets do not conflict",
+			run: func(ctx context.Context, repo Repository) (any, error) {
+				err := setUp(
+					func() error { _, err := storeTestTicket(ctx, repo, "PLATE", 0, 5, 6); return err },
+					func() error { _, err := storeTestTicket(ctx, repo, "PLATE", 0, 6, 6); return err },
+					func() error { _, err := repo.VoidTicket(ctx, 1); return err },
+				)
+
+				if err != nil {
+					return nil, err
+				}
+
+				ticket, err := repo.ConflictingTickets(ctx, db.ConflictingTicketsParams{PlateNumber: "PLATE", StartDate: 6, EndDate: 6})
+
+				return ticket.ID, err
+			},
+			want: int64(2),
+		},
+		{
+			name: "requeued tickets conflict again",
+			run: func(ctx context.Context, repo Repository) (any, error) {
+				err := setUp(
+					func() error { _, err := storeTestTicket(ctx, repo, "PLATE", 0, 5, 5); return err },
+					func() error { _, err := repo.VoidTicket(ctx, 1); return err },
+					func() error { _, err := repo.RequeueTicket(ctx, 1); return err },
+				)
+
+				if err != nil {
+					return nil, err
+				}
+
+				ticket, err := repo.ConflictingTickets(ctx, db.ConflictingTicketsParams{PlateNumber: "PLATE", StartDate: 5, EndDate: 5})
+
+				return ticket.ID, err
+			},
+			want: int64(1),
+		},
+		{
+			name: "pending tickets",
+			run: func(ctx context.Context, repo Repository) (any, error) {
+				err := setUp(
+					func() error { _, err := storeTestTicket(ctx, repo, "A", 0, 0, 0); return err },
+					func() error { _, err := storeTestTicket(ctx, repo, "B", 0, 0, 0); return err },
+					func() error { _, err := storeTestTicket(ctx, repo, "C", 0, 0, 0); return err },
+					func() error { _, err := storeTestTicket(ctx, repo, "D", 0, 0, 0); return err },
+					func() error { return repo.MarkTicketAsProcessed(ctx, 2) },
+					func() error { _, err := repo.VoidTicket(ctx, 3); return err },
+				)
+
+				if err != nil {
+					return nil, err
+				}
+
+				count, err := repo.CountPendingTickets(ctx)
+
+				if err != nil {
+					return nil, err
+				}
+
+				pending, err := ticketIds(repo.GetUnProcessedTicketsForRoad(ctx, 1))
+
+				if err != nil {
+					return nil, err
+				}
+
+				otherRoad, err := ticketIds(repo.GetUnProcessedTicketsForRoad(ctx, 2))
+
+				return []any{count, pending, otherRoad}, err
+			},
+			want: []any{int64(2), []int64{1, 4}, []int64{}},
+		},
+		{
+			name: "requeued ticket is pending",
+			run: func(ctx context.Context, repo Repository) (any, error) {
+				err := setUp(
+					func() error { _, err := storeTestTicket(ctx, repo, "A", 0, 0, 0); return err },
+					func() error { return repo.MarkTicketAsProcessed(ctx, 1) },
+				)
+
+				if err != nil {
+					return nil, err
+				}
+
+				requeued, err := repo.RequeueTicket(ctx, 1)
+
+				if err != nil {
+					return nil, err
+				}
+
+				missing, err := repo.RequeueTicket(ctx, 2)
+
+				if err != nil {
+					return nil, err
+				}
+
+				pending, err := ticketIds(repo.GetUnProcessedTickets(ctx))
+
+				return []any{requeued, missing, pending}, err
+			},
+			want: []any{int64(1), int64(0), []int64{1}},
+		},
+		{
+			name: "tickets by time and plate",
+			run: func(ctx context.Context, repo Repository) (any, error) {
+				err := setUp(
+					func() error { _, err := storeTestTicket(ctx, repo, "A", 300, 0, 0); return err },
+					func() error { _, err := storeTestTicket(ctx, repo, "B", 100, 0, 0); return err },
+					func() error { _, err := storeTestTicket(ctx, repo, "A", 200, 0, 0); return err },
+					func() error { _, err := storeTestTicket(ctx, repo, "B", 100, 1, 1); return err },
+					func() error { _, err := storeTestTicket(ctx, repo, "A", 900, 0, 0); return err },
+				)
+
+				if err != nil {
+					return nil, err
+				}
+
+				inRange, err := ticketIds(repo.ListTicketsInTimeRange(ctx, db.ListTicketsInTimeRangeParams{MinTimestamp: 100, MaxTimestamp: 300}))
+
+				if err != nil {
+					return nil, err
+				}
+
+				plate, err := ticketIds(repo.GetTicketsForPlate(ctx, "A"))
+
+				if err != nil {
+					return nil, err
+				}
+
+				all, err := ticketIds(repo.ListTickets(ctx))
+
+				return []any{inRange, plate, all}, err
+			},
+			want: []any{[]int64{2, 4, 3, 1}, []int64{1, 3, 5}, []int64{1, 2, 3, 4, 5}},
+		},
+		{
+			name: "dispatchers",
+			run: func(ctx context.Context, repo Repository) (any, error) {
+				for _, dispatcher := range []db.AddDispatcherForRoadParams{
+					{RoadID: 1, DispatcherID: "b"},
+					{RoadID: 1, DispatcherID: "a"},
+					{RoadID: 2, DispatcherID: "b"},
+					{RoadID: 1, DispatcherID: "c"},
+				} {
+					if err := repo.AddDispatcherForRoad(ctx, dispatcher); err != nil {
+						return nil, err
+					}
+				}
+
+				if err := repo.RemoveDispatcher(ctx, "b"); err != nil {
+					return nil, err
+				}
+
+				road1, err := repo.FindDispatchersForRoad(ctx, 1)
+
+				if err != nil {
+					return nil, err
+				}
+
+				road2, err := repo.FindDispatchersForRoad(ctx, 2)
+
+				if err != nil {
+					return nil, err
+				}
+
+				if err := repo.RemoveAllDispatchers(ctx); err != nil {
+					return nil, err
+				}
+
+				removed, err := repo.FindDispatchersForRoad(ctx, 1)
+
+				return []any{road1, road2, removed}, err
+			},
+			want: []any{[]string{"a", "c"}, []string(nil), []string(nil)},
+		},
+		{
+			name: "conflicting ticket claims",
+			run: func(ctx context.Context, repo Repository) (any, error) {
+				for _, claim := range []db.InsertTicketClaimParams{
+					{PlateNumber: "OTHER", DayStartRange: 10, DayEndRange: 10, NodeID: "a"},
+					{PlateNumber: "UN1X", DayStartRange: 10, DayEndRange: 11, NodeID: "b"},
+				} {
+					if err := repo.InsertTicketClaim(ctx, claim); err != nil {
+						return nil, err
+					}
+				}
+
+				return repo.ConflictingTicketClaim(ctx, db.ConflictingTicketClaimParams{PlateNumber: "UN1X", StartDate: 11, EndDate: 12})
+			},
+			want: db.TicketClaim{ID: 2, PlateNumber: "UN1X", DayStartRange: 10, DayEndRange: 11, NodeID: "b"},
+		},
+		{
+			name: "released ticket claims do not conflict",
+			run: func(ctx context.Context, repo Repository) (any, error) {
+				for range 2 {
+					if err := repo.InsertTicketClaim(ctx, db.InsertTicketClaimParams{PlateNumber: "UN1X", DayStartRange: 10, DayEndRange: 11, NodeID: "a"}); err != nil {
+						return nil, err
+					}
+				}
+
+				released := []int64{}
+
+				// Only one of the two claims is released each time
+				for range 3 {
+					deleted, err := repo.DeleteTicketClaim(ctx, db.DeleteTicketClaimParams{PlateNumber: "UN1X", DayStartRange: 10, DayEndRange: 11})
+
+					if err != nil {
+						return nil, err
+					}
+
+					released = append(released, deleted)
+				}
+
+				_, err := repo.ConflictingTicketClaim(ctx, db.ConflictingTicketClaimParams{PlateNumber: "UN1X", StartDate: 10, EndDate: 10})
+
+				if errors.Is(err, sql.ErrNoRows) {
+					return released, nil
+				}
+
+				return nil, fmt.Errorf("claim still conflicts: %v", err)
+			},
+			want: []int64{1, 1, 0},
+		},
+		{
+			name: "ticket events",
+			run: func(ctx context.Context, repo Repository) (any, error) {
+				for _, event := range []db.InsertTicketEventParams{
+					{TicketID: 1, Event: TicketEventCreated, CreatedAt: 10},
+					{TicketID: 2, Event: TicketEventCreated, CreatedAt: 20},
+					{TicketID: 1, Event: "delivered", CreatedAt: 30, DispatcherID: "d", Detail: "detail"},
+				} {
+					if err := repo.InsertTicketEvent(ctx, event); err != nil {
+						return nil, err
+					}
+				}
+
+				return repo.GetTicketEvents(ctx, 1)
+			},
+			want: []db.TicketEvent{
+				{ID: 1, TicketID: 1, Event: TicketEventCreated, CreatedAt: 10},
+				{ID: 3, TicketID: 1, Event: "delivered", CreatedAt: 30, DispatcherID: "d", Detail: "detail"},
+			},
+		},
+	}
+
+	for _, testRepository := range testRepositories {
+		for _, test := range tests {
+			t.Run(testRepository.name+"/"+test.name, func(t *testing.T) {
+				got, err := test.run(context.Background(), testRepository.newRepository(t))
+
+				if !errors.Is(err, test.wantErr) {
+					t.Fatalf("error = %v, want %v", err, test.wantErr)
+				}
+
+				if test.wantErr == nil && !reflect.DeepEqual(got, test.want) {
+					t.Errorf("got %#v, want %#v", got, test.want)
+				}
+			})
+		}
+	}
+}
+
+// Checks a ticket of one of a thousand plates for conflicts and stores it,
+// as every ticket does before it is delivered
+func BenchmarkRepositories(b *testing.B) {
+	for _, testRepository := range testRepositories {
+		b.Run(testRepository.name, func(b *testing.B) {
+			ctx := context.Background()
+			repo := testRepository.newRepository(b)
+
+			b.ResetTimer()
+
+			for i := range b.N {
+				plate := fmt.Sprintf("PLATE%d", i%1000)
+				day := int64(i / 1000)
+
+				_, err := repo.ConflictingTickets(ctx, db.ConflictingTicketsParams{PlateNumber: plate, StartDate: day, EndDate: day})
+
+				if !errors.Is(err, sql.ErrNoRows) {
+					b.Fatalf("conflict of %s on day %d: %v", plate, day, err)
+				}
+
+				if _, err := storeTestTicket(ctx, repo, plate, day*86400, day, day); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/nivekithan/go-network/problems/speed-daemon/db"
+)
+
+// Deletes every interval the observations whose timestamp is more than
+// retention older than the newest observation of the same plate on the same
+// road. Timestamps are the ones reported by the cameras, so retention is
+// measured in camera time, and a camera reporting a timestamp far in the
+// future only expires the history of that plate on that road. Tickets keep
+// their miles and timestamps, so their referenced observation ids may no
+// longer exist.
+func compactObservations(queries Repository, retention time.Duration, interval time.Duration) {
+	ctx := context.Background()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		removed, err := queries.DeleteExpiredObservations(ctx, int64(retention/time.Second))
+
+		if err != nil {
+			log.Printf("error: compaction: deleting observations: %v", err)
+			continue
+		}
+
+		if removed == 0 {
+			continue
+		}
+
+		observationsCompacted.Add(uint64(removed))
+		log.Printf("Compaction removed %d observations", removed)
+	}
+}
+
+// Returns the timestamp before which observations of the plate on the road
+// are expired. Expired observations are ignored whether or not compaction has
+// deleted them yet, so they never produce tickets. Nothing expires when
+// retention is 0.
+func retentionCutoff(ctx context.Context, queries Repository, plate string, roadId int64, retention time.Duration) (int64, error) {
+	if retention <= 0 {
+		return 0, nil
+	}
+
+	latestTimestamp, err := queries.GetLatestPlateObservationTimestamp(ctx, db.GetLatestPlateObservationTimestampParams{
+		PlateNumber: plate,
+		RoadID:      roadId,
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	return latestTimestamp - int64(retention/time.Second), nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// Speeds below limit + tolerance are not ticketed. The default of 0.5 mph
+// tickets every speed which rounds to more than the limit.
+const defaultTolerance = 0.5
+
+// A ticket gets the severity of the highest band whose threshold it reaches
+type SeverityBand struct {
+	Name string `json:"name"`
+
+	// Miles per hour over the limit
+	Over float64 `json:"over"`
+}
+
+// Replaces the matching global rules for a single road
+type RoadRules struct {
+	Tolerance     *float64       `json:"tolerance"`
+	SeverityBands []SeverityBand `json:"severity_bands"`
+}
+
+// Rules decide which observed speeds are ticketed.
+//
+//	{
+//	  "tolerance": 0.5,
+//	  "exempt_plates": ["AMBULANCE1"],
+//	  "severity_bands": [{"name": "minor", "over": 0}, {"name": "major", "over": 20}],
+//	  "roads": {"66": {"tolerance": 5}}
+//	}
+type Rules struct {
+	Tolerance     float64              `json:"tolerance"`
+	ExemptPlates  []string             `json:"exempt_plates"`
+	SeverityBands []SeverityBand       `json:"severity_bands"`
+	Roads         map[uint16]RoadRules `json:"roads"`
+}
+
+func DefaultRules() *Rules {
+	return &Rules{Tolerance: defaultTolerance}
+}
+
+func LoadRules(path string) (*Rules, error) {
+	content, err := os.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rules := DefaultRules()
+
+	if err := json.Unmarshal(content, rules); err != nil {
+		return nil, fmt.Errorf("parsing rules %s: %w", path, err)
+	}
+
+	sortSeverityBands(rules.SeverityBands)
+
+	for _, road := range rules.Roads {
+		sortSeverityBands(road.SeverityBands)
+	}
+
+	return rules, nil
+}
+
+func sortSeverityBands(bands []SeverityBand) {
+	slices.SortFunc(bands, func(a, b SeverityBand) int {
+		if a.Over < b.Over {
+			return -1
+		}
+
+		if a.Over > b.Over {
+			return 1
+		}
+
+		return 0
+	})
+}
+
+func (r *Rules) IsExempt(plate string) bool {
+	return slices.Contains(r.ExemptPlates, plate)
+}
+
+// Whether speed in miles per hour is ticketed on a road with limit, and the
+// severity of the ticket
+func (r *Rules) Evaluate(road uint16, limit int64, speed float64) (bool, string) {
+	tolerance := r.Tolerance
+	bands := r.SeverityBands
+
+	if override, ok := r.Roads[road]; ok {
+		if override.Tolerance != nil {
+			tolerance = *override.Tolerance
+		}
+
+		if override.SeverityBands != nil {
+			bands = override.SeverityBands
+		}
+	}
+
+	if speed < float64(limit)+tolerance {
+		return false, ""
+	}
+
+	severity := ""
+
+	for _, band := range bands {
+		if speed-float64(limit) >= band.Over {
+			severity = band.Name
+		}
+	}
+
+	return true, severity
+}
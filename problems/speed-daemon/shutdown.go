@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Guards closing plateObservationChan while cameras are still sending to it
+var plateObservationLock sync.RWMutex
+var plateObservationClosed bool
+
+var errShuttingDown = errors.New("server is shutting down")
+
+// Queues a stored observation for processing. Fails once the server has
+// started shutting down
+func submitPlateObservation(observation QueuedObservation) error {
+	plateObservationLock.RLock()
+	defer plateObservationLock.RUnlock()
+
+	if plateObservationClosed {
+		return errShuttingDown
+	}
+
+	plateObservationChan <- observation
+
+	return nil
+}
+
+// Waits for observations which are being submitted and closes
+// plateObservationChan, which stops processPlateObservation once it has
+// processed them
+func closePlateObservations() {
+	plateObservationLock.Lock()
+	defer plateObservationLock.Unlock()
+
+	plateObservationClosed = true
+	close(plateObservationChan)
+}
+
+// Background work which has to finish before the server exits
+type Processors struct {
+	observationsDone chan struct{}
+
+	stopTickets chan struct{}
+	ticketsDone chan struct{}
+}
+
+func startProcessors(queries Repository, config *Config) *Processors {
+	processors := &Processors{
+		observationsDone: make(chan struct{}),
+		stopTickets:      make(chan struct{}),
+		ticketsDone:      make(chan struct{}),
+	}
+
+	go func() {
+		defer close(processors.observationsDone)
+		processPlateObservation(queries, config.rules, config.cluster, config.retention)
+	}()
+
+	go func() {
+		defer close(processors.ticketsDone)
+		processUnProcessedTicket(queries, config.cluster, processors.stopTickets)
+	}()
+
+	return processors
+}
+
+// Stops the server once the listeners have been closed. Cameras are
+// disconnected first after reading what they already sent, then the queued
+// observations are processed and the resulting tickets are delivered to the
+// dispatchers which are still connected. Clients are closed without an Error
+// message.
+func shutdown(queries Repository, cluster *Cluster, processors *Processors, timeout time.Duration) error {
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		stopCameras()
+		closePlateObservations()
+		<-processors.observationsDone
+		log.Println("Processed queued observations")
+
+		close(processors.stopTickets)
+		<-processors.ticketsDone
+
+		if err := flushPendingTickets(queries, cluster); err != nil {
+			log.Printf("error: flushing pending tickets: %v", err)
+		}
+
+		for _, client := range listClients() {
+			client.Close()
+		}
+	}()
+
+	select {
+	case <-done:
+		log.Println("Shutdown complete")
+		return nil
+	case <-time.After(timeout):
+		return fmt.Errorf("shutdown did not complete within %v", timeout)
+	}
+}
+
+// How long cameras may keep sending once the server is shutting down. Plates
+// which were sent before the shutdown signal are still processed
+const cameraDrainTimeout = time.Second
+
+// Stops reading from every client which is not a dispatcher and waits for
+// their connections to end
+func stopCameras() {
+	clients := []*Client{}
+
+	for _, client := range listClients() {
+		if role, _, _ := client.identity(); role != RoleDispatcher {
+			client.conn.SetReadDeadline(time.Now().Add(cameraDrainTimeout))
+			clients = append(clients, client)
+		}
+	}
+
+	for _, client := range clients {
+		<-client.done
+	}
+
+	log.Printf("Disconnected %d cameras", len(clients))
+}
+
+// Delivers the tickets of every road which has a dispatcher connected, and
+// returns the tickets of roads owned by other nodes to their owner otherwise
+func flushPendingTickets(queries Repository, cluster *Cluster) error {
+	ctx := context.Background()
+	tickets, err := queries.GetUnProcessedTickets(ctx)
+
+	if err != nil {
+		return err
+	}
+
+	roadIds := []int64{}
+
+	for _, ticket := range tickets {
+		if !slices.Contains(roadIds, ticket.RoadID) {
+			roadIds = append(roadIds, ticket.RoadID)
+		}
+	}
+
+	for _, roadId := range roadIds {
+		if err := deliverPendingTickets(ctx, queries, cluster, roadId); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("Flushed pending tickets of %d roads", len(roadIds))
+
+	return nil
+}
+
+// Closes the listeners once ctx is done and waits for their accept loops to
+// return
+func serveUntilDone(ctx context.Context, queries Repository, listners []net.Listener, nodeListner net.Listener, config *Config) {
+	acceptors := sync.WaitGroup{}
+
+	serve := func(listner net.Listener, handle func(Repository, net.Conn, *Config)) {
+		acceptors.Go(func() {
+			for {
+
+				err := handleListner(queries, listner, handle, config)
+
+				if errors.Is(err, net.ErrClosed) {
+					return
+				}
+
+				if err != nil {
+					log.Println("error: handleListner", err)
+				}
+
+			}
+		})
+	}
+
+	for _, listner := range listners {
+		serve(listner, handleConnection)
+	}
+
+	if nodeListner != nil {
+		serve(nodeListner, handleNodeConnection)
+		listners = append(listners, nodeListner)
+	}
+
+	<-ctx.Done()
+	log.Println("Shutting down. No longer accepting connections")
+
+	for _, listner := range listners {
+		listner.Close()
+	}
+
+	acceptors.Wait()
+}
@@ -1,9 +1,26 @@
--- name: InsertRoad :exec
-INSERT INTO road (id, speed_limit) VALUES (@id, @speed_limit);
+-- name: InsertRoad :execrows
+INSERT INTO road (id, speed_limit) VALUES (@id, @speed_limit) ON CONFLICT (id) DO NOTHING;
 
 -- name: GetRoad :one
 SELECT * FROM road WHERE id = @id;
 
+-- name: ListRoads :many
+SELECT * FROM road ORDER BY id;
+
+-- name: LowerRoadSpeedLimit :exec
+UPDATE road SET speed_limit = @speed_limit WHERE id = @id AND speed_limit > @speed_limit;
+
+-- name: InsertCameraLimit :exec
+INSERT INTO camera_limit
+    (road_id, mile, speed_limit, road_speed_limit, client_id, conflict, created_at) VALUES
+    (@road_id, @mile, @speed_limit, @road_speed_limit, @client_id, @conflict, @created_at);
+
+-- name: CountLimitConflicts :one
+SELECT COUNT(*) FROM camera_limit WHERE conflict = 1;
+
+-- name: GetLimitConflicts :many
+SELECT * FROM camera_limit WHERE conflict = 1 ORDER BY id DESC LIMIT @limit;
+
 -- name: InsertPlateObservation :one
 INSERT INTO plate_observation
     (plate_number, road_id, timestamp, location) VALUES
@@ -27,33 +44,111 @@ ORDER BY timestamp ASC LIMIT 1;
 -- name: GetObservationById :one
 SELECT * FROM plate_observation WHERE id = @id;
 
+-- name: GetObservationsForPlate :many
+SELECT * FROM plate_observation WHERE plate_number = @plate_number ORDER BY road_id, timestamp;
+
+-- name: GetLatestPlateObservationTimestamp :one
+SELECT CAST(COALESCE(MAX(timestamp), 0) AS INTEGER) AS latest_timestamp FROM plate_observation WHERE
+    plate_number = @plate_number AND
+    road_id = @road_id;
+
+-- name: DeleteExpiredObservations :execrows
+DELETE FROM plate_observation WHERE timestamp < (
+    SELECT MAX(latest.timestamp) FROM plate_observation AS latest WHERE
+        latest.plate_number = plate_observation.plate_number AND
+        latest.road_id = plate_observation.road_id
+) - CAST(@retention AS INTEGER);
+
 
 -- name: ConflictingTickets :one
 SELECT * FROM ticket WHERE
+    plate_number = @plate_number AND
+    (
+    (@start_date >= day_start_range AND @start_date <= day_end_range ) OR
+    (@end_date  >= day_start_range AND @end_date <= day_end_range)
+    ) AND voided = 0 LIMIT 1;
+
+-- name: ConflictingTicketClaim :one
+SELECT * FROM ticket_claim WHERE
     plate_number = @plate_number AND
     (
     (@start_date >= day_start_range AND @start_date <= day_end_range ) OR
     (@end_date  >= day_start_range AND @end_date <= day_end_range)
     ) LIMIT 1;
 
--- name: FindDispatcherForRoad :one
-SELECT dispatcher_id FROM dispatcher WHERE road_id = @road_id LIMIT 1;
+-- name: InsertTicketClaim :exec
+INSERT INTO ticket_claim (plate_number, day_start_range, day_end_range, node_id)
+VALUES (@plate_number, @day_start_range, @day_end_range, @node_id);
+
+-- name: DeleteTicketClaim :execrows
+DELETE FROM ticket_claim WHERE id = (
+    SELECT id FROM ticket_claim WHERE
+        plate_number = @plate_number AND
+        day_start_range = @day_start_range AND
+        day_end_range = @day_end_range
+    ORDER BY id LIMIT 1
+);
+
+-- name: FindDispatchersForRoad :many
+SELECT dispatcher_id FROM dispatcher WHERE road_id = @road_id ORDER BY id;
 
 
--- name: StoreTicket :exec
+-- name: StoreTicket :one
 INSERT INTO ticket
-    (plate_number, road_id, mile_1, timestamp_1, mile_2, timestamp_2, speed, day_start_range, day_end_range, is_processed)
+    (plate_number, road_id, mile_1, timestamp_1, mile_2, timestamp_2, speed, day_start_range, day_end_range, is_processed, observation_id_1, observation_id_2, created_at, severity)
 VALUES (
-    @plate_number, @road_id, @mile_1, @timestamp_1, @mile_2, @timestamp_2, @speed, @day_start_range, @day_end_range, @is_processed
-);
+    @plate_number, @road_id, @mile_1, @timestamp_1, @mile_2, @timestamp_2, @speed, @day_start_range, @day_end_range, @is_processed, @observation_id_1, @observation_id_2, @created_at, @severity
+)
+RETURNING id;
 
 -- name: GetUnProcessedTickets :many
-SELECT * FROM ticket WHERE is_processed = 0;
+SELECT * FROM ticket WHERE is_processed = 0 AND voided = 0;
+
+-- name: CountPendingTickets :one
+SELECT COUNT(*) FROM ticket WHERE is_processed = 0 AND voided = 0;
+
+-- name: GetUnProcessedTicketsForRoad :many
+SELECT * FROM ticket WHERE is_processed = 0 AND voided = 0 AND road_id = @road_id ORDER BY id;
+
+-- name: GetTicket :one
+SELECT * FROM ticket WHERE id = @id;
+
+-- name: ListTickets :many
+SELECT * FROM ticket ORDER BY id;
+
+-- name: ListTicketsInTimeRange :many
+SELECT * FROM ticket WHERE
+    timestamp_1 >= @min_timestamp AND
+    timestamp_1 <= @max_timestamp
+ORDER BY timestamp_1, id;
+
+-- name: GetTicketsForPlate :many
+SELECT * FROM ticket WHERE plate_number = @plate_number ORDER BY id;
 
 
 -- name: MarkTicketAsProcessed :exec
 UPDATE ticket SET is_processed = 1 WHERE id = @id;
 
+-- name: RequeueTicket :execrows
+UPDATE ticket SET is_processed = 0, voided = 0 WHERE id = @id;
+
+-- name: VoidTicket :execrows
+UPDATE ticket SET voided = 1 WHERE id = @id;
+
 
 -- name: AddDispatcherForRoad :exec
 INSERT INTO dispatcher (road_id, dispatcher_id) VALUES (@road_id, @dispatcher_id);
+
+-- name: RemoveDispatcher :exec
+DELETE FROM dispatcher WHERE dispatcher_id = @dispatcher_id;
+
+-- name: RemoveAllDispatchers :exec
+DELETE FROM dispatcher;
+
+-- name: InsertTicketEvent :exec
+INSERT INTO ticket_event
+    (ticket_id, event, created_at, dispatcher_id, detail)
+VALUES (@ticket_id, @event, @created_at, @dispatcher_id, @detail);
+
+-- name: GetTicketEvents :many
+SELECT * FROM ticket_event WHERE ticket_id = @ticket_id ORDER BY id;
@@ -1,4 +1,4 @@
-CREATE TABLE plate_observation (
+CREATE TABLE IF NOT EXISTS plate_observation (
     id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
     plate_number TEXT NOT NULL,
     timestamp INTEGER NOT NULL,
@@ -7,19 +7,46 @@ CREATE TABLE plate_observation (
     FOREIGN KEY (road_id) REFERENCES road(id)
 );
 
-CREATE TABLE road (
+-- previous and next observation of a plate on a road, and the newest one
+-- which retention measures the others against
+CREATE INDEX IF NOT EXISTS plate_observation_plate_road_timestamp ON plate_observation (plate_number, road_id, timestamp);
+
+-- retention used to be global. Databases created back then still have it
+DROP INDEX IF EXISTS plate_observation_timestamp;
+
+CREATE TABLE IF NOT EXISTS road (
     id INTEGER PRIMARY KEY NOT NULL,
     speed_limit INTEGER NOT NULL
 );
 
-CREATE TABLE dispatcher (
+-- speed limit declared by every camera which registered on a road
+CREATE TABLE IF NOT EXISTS camera_limit (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    road_id INTEGER NOT NULL,
+    mile INTEGER NOT NULL,
+    speed_limit INTEGER NOT NULL,
+
+    -- speed limit of the road when the camera registered
+    road_speed_limit INTEGER NOT NULL,
+    client_id TEXT NOT NULL,
+
+    -- boolean. Whether speed_limit differs from road_speed_limit
+    conflict INTEGER NOT NULL,
+
+    -- unix milliseconds
+    created_at INTEGER NOT NULL,
+
+    FOREIGN KEY (road_id) REFERENCES road(id)
+);
+
+CREATE TABLE IF NOT EXISTS dispatcher (
     id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
     road_id INTEGER NOT NULL,
     dispatcher_id TEXT NOT NULL
 );
 
 
-CREATE TABLE ticket (
+CREATE TABLE IF NOT EXISTS ticket (
     id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
     plate_number TEXT NOT NULL,
     road_id INTEGER NOT NULL,
@@ -37,6 +64,59 @@ CREATE TABLE ticket (
     -- it is getting used as boolean
     is_processed INTEGER NOT NULL,
 
+    -- boolean. Voided tickets are never delivered and do not count as
+    -- conflicts for new tickets
+    voided INTEGER NOT NULL DEFAULT 0,
+
+    -- observations which triggered the ticket
+    observation_id_1 INTEGER NOT NULL DEFAULT 0,
+    observation_id_2 INTEGER NOT NULL DEFAULT 0,
+
+    -- unix milliseconds
+    created_at INTEGER NOT NULL DEFAULT 0,
+
+    -- name of the severity band from the rules. Empty without bands
+    severity TEXT NOT NULL DEFAULT '',
+
 
     FOREIGN KEY (road_id) REFERENCES road(id)
 );
+
+-- conflicting tickets of a plate
+CREATE INDEX IF NOT EXISTS ticket_plate_day ON ticket (plate_number, day_start_range, day_end_range);
+
+-- days of tickets generated anywhere in a cluster. Only kept by the node
+-- which checks conflicts for the plate. Range is inclusive
+CREATE TABLE IF NOT EXISTS ticket_claim (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    plate_number TEXT NOT NULL,
+    day_start_range INTEGER NOT NULL,
+    day_end_range INTEGER NOT NULL,
+
+    -- id of the node which generated the ticket
+    node_id TEXT NOT NULL
+);
+
+-- conflicting claims of a plate
+CREATE INDEX IF NOT EXISTS ticket_claim_plate_day ON ticket_claim (plate_number, day_start_range, day_end_range);
+
+-- audit trail of everything which happened to a ticket
+CREATE TABLE IF NOT EXISTS ticket_event (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    ticket_id INTEGER NOT NULL,
+
+    -- one of created, delivered, delivery_failed, requeued, voided or returned
+    event TEXT NOT NULL,
+
+    -- unix milliseconds
+    created_at INTEGER NOT NULL,
+
+    -- empty unless the event is about a delivery
+    dispatcher_id TEXT NOT NULL,
+    detail TEXT NOT NULL,
+
+    FOREIGN KEY (ticket_id) REFERENCES ticket(id)
+);
+
+-- events of a ticket
+CREATE INDEX IF NOT EXISTS ticket_event_ticket ON ticket_event (ticket_id);
@@ -5,7 +5,7 @@ import (
 	"encoding/binary"
 	"io"
 	"log"
-	"net"
+	"log/slog"
 	"time"
 
 	"github.com/nivekithan/go-network/problems/speed-daemon/db"
@@ -64,7 +64,7 @@ type RegisterObservationsParams struct {
 	Location uint16
 }
 
-func (p *Plate) RegisterObservation(ctx context.Context, queries *db.Queries, params RegisterObservationsParams) (int64, error) {
+func (p *Plate) RegisterObservation(ctx context.Context, queries Repository, params RegisterObservationsParams) (int64, error) {
 	observation_id, err := queries.InsertPlateObservation(ctx, db.InsertPlateObservationParams{
 		PlateNumber: p.plate,
 		RoadID:      int64(params.RoadID),
@@ -154,31 +154,17 @@ func NewWantHeartbeat(reader io.Reader) (*WantHeartbeat, error) {
 	}, nil
 }
 
-// This function blocks
-func (heartbeat *WantHeartbeat) SendHeartBeat(conn net.Conn) {
-
-	if heartbeat.interval == 0 {
-		log.Println("heartbeat interval is zero")
-		return
-	}
-
-	interval := int(heartbeat.interval)
-
-	timer := time.NewTicker(time.Duration(interval) * (time.Second / 10))
-
-	for {
-		<-timer.C
-
-		heartbeat := &Heartbeat{}
-		if _, err := conn.Write(heartbeat.toBinary()); err != nil {
-			log.Println("error sending heartbeat:", err)
-			log.Println("Stoppping Sendheart")
-			return
-		}
+// Answer of the authority of a plate to a ClaimTicketDays message
+type DaysClaimed struct {
+	granted bool
+}
 
-		log.Println("Sent hearbeat")
+func (d *DaysClaimed) toBinary() []byte {
+	if d.granted {
+		return []byte{0x23, 1}
 	}
 
+	return []byte{0x23, 0}
 }
 
 type Heartbeat struct {
@@ -217,25 +203,84 @@ func NewIamCamera(reader io.Reader) (*IAmCamera, error) {
 	}, nil
 }
 
-func (camera *IAmCamera) Register(ctx context.Context, queries *db.Queries) error {
+// Records the limit declared by the camera. When the road already has a
+// different limit the conflict is resolved according to conflictPolicy
+func (camera *IAmCamera) Register(ctx context.Context, queries Repository, clientId string, conflictPolicy string) error {
 
 	roadId := int64(camera.road)
 
-	_, err := queries.GetRoad(ctx, roadId)
+	inserted, err := queries.InsertRoad(ctx, db.InsertRoadParams{
+		ID:         roadId,
+		SpeedLimit: int64(camera.limit),
+	})
 
 	if err != nil {
-		if err := queries.InsertRoad(ctx, db.InsertRoadParams{
-			ID:         roadId,
-			SpeedLimit: int64(camera.limit),
-		}); err != nil {
-			return err
-		}
+		return err
+	}
 
+	if inserted > 0 {
 		log.Printf("Registered new road: %d", roadId)
+	} else {
+		log.Printf("Road already registered: %d", roadId)
+	}
+
+	road, err := queries.GetRoad(ctx, roadId)
+
+	if err != nil {
+		return err
+	}
+
+	conflict := int64(0)
+
+	if road.SpeedLimit != int64(camera.limit) {
+		conflict = 1
+	}
+
+	if err := queries.InsertCameraLimit(ctx, db.InsertCameraLimitParams{
+		RoadID:         roadId,
+		Mile:           int64(camera.mile),
+		SpeedLimit:     int64(camera.limit),
+		RoadSpeedLimit: road.SpeedLimit,
+		ClientID:       clientId,
+		Conflict:       conflict,
+		CreatedAt:      time.Now().UnixMilli(),
+	}); err != nil {
+		return err
+	}
+
+	if conflict == 0 {
 		return nil
 	}
 
-	log.Printf("Road already registered: %d", roadId)
+	slog.Warn("speed limit conflict",
+		"road", camera.road,
+		"mile", camera.mile,
+		"road_limit", road.SpeedLimit,
+		"camera_limit", camera.limit,
+		"client_id", clientId,
+		"policy", conflictPolicy,
+	)
+
+	switch conflictPolicy {
+	case LimitConflictReject:
+		limitConflicts.Inc(LimitConflictOutcomeRejected)
+		return &LimitConflictError{road: camera.road, roadLimit: road.SpeedLimit, limit: camera.limit}
+
+	case LimitConflictMin:
+		if int64(camera.limit) > road.SpeedLimit {
+			limitConflicts.Inc(LimitConflictOutcomeKept)
+			return nil
+		}
+
+		limitConflicts.Inc(LimitConflictOutcomeLowered)
+
+		return queries.LowerRoadSpeedLimit(ctx, db.LowerRoadSpeedLimitParams{
+			ID:         roadId,
+			SpeedLimit: int64(camera.limit),
+		})
+	}
+
+	limitConflicts.Inc(LimitConflictOutcomeKept)
 
 	return nil
 }
@@ -268,7 +313,7 @@ func NewIamDispatcher(reader io.Reader) (*IamDispatcher, error) {
 	}, nil
 }
 
-func (d *IamDispatcher) Register(ctx context.Context, queries *db.Queries, dispatcherId string) {
+func (d *IamDispatcher) Register(ctx context.Context, queries Repository, dispatcherId string) {
 	for _, road := range d.roads {
 		roadId := int64(road)
 
@@ -277,6 +322,15 @@ func (d *IamDispatcher) Register(ctx context.Context, queries *db.Queries, dispa
 			DispatcherID: dispatcherId,
 		}); err != nil {
 			log.Printf("Failed to register dispatcher %s for road %d: %v", dispatcherId, roadId, err)
+			continue
 		}
+
+		notifyPendingTickets(roadId)
+	}
+}
+
+func (d *IamDispatcher) Unregister(ctx context.Context, queries Repository, dispatcherId string) {
+	if err := queries.RemoveDispatcher(ctx, dispatcherId); err != nil {
+		log.Printf("Failed to unregister dispatcher %s: %v", dispatcherId, err)
 	}
 }
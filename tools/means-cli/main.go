@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nivekithan/go-network/problems/means-to-end/meansclient"
+)
+
+type TimeRange struct {
+	minTime int32
+	maxTime int32
+}
+
+type Config struct {
+	addr string
+
+	// CSV of timestamp,price rows inserted before the queries run
+	importPath string
+
+	queries []TimeRange
+}
+
+func parseFlags() *Config {
+	config := &Config{}
+
+	flag.StringVar(&config.addr, "addr", "localhost:8000", "address of the means-to-end server")
+	flag.StringVar(&config.importPath, "import", "", "CSV of timestamp,price rows to insert. A header row is skipped")
+	flag.Func("query", "range to print the mean of as mintime,maxtime. Can be repeated", func(value string) error {
+		timeRange, err := parseTimeRange(value)
+
+		if err != nil {
+			return err
+		}
+
+		config.queries = append(config.queries, timeRange)
+		return nil
+	})
+
+	flag.Parse()
+
+	return config
+}
+
+func parseTimeRange(value string) (TimeRange, error) {
+	minTime, maxTime, ok := strings.Cut(value, ",")
+
+	if !ok {
+		return TimeRange{}, errors.New("expected mintime,maxtime")
+	}
+
+	parsedMin, err := strconv.ParseInt(strings.TrimSpace(minTime), 10, 32)
+
+	if err != nil {
+		return TimeRange{}, fmt.Errorf("mintime: %w", err)
+	}
+
+	parsedMax, err := strconv.ParseInt(strings.TrimSpace(maxTime), 10, 32)
+
+	if err != nil {
+		return TimeRange{}, fmt.Errorf("maxtime: %w", err)
+	}
+
+	return TimeRange{minTime: int32(parsedMin), maxTime: int32(parsedMax)}, nil
+}
+
+// Inserts every row of the CSV at path and returns how many there were
+func importPrices(client *meansclient.Client, path string) (int, error) {
+	file, err := os.Open(path)
+
+	if err != nil {
+		return 0, err
+	}
+
+	defer file.Close()
+
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+
+	imported := 0
+
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+
+		if err == io.EOF {
+			return imported, nil
+		}
+
+		if err != nil {
+			return imported, err
+		}
+
+		timestamp, timestampErr := strconv.ParseInt(record[0], 10, 32)
+		price, priceErr := strconv.ParseInt(record[1], 10, 32)
+
+		if timestampErr != nil || priceErr != nil {
+			if line == 1 {
+				continue
+			}
+
+			return imported, fmt.Errorf("%s:%d: expected int32 timestamp and price, got %q", path, line, record)
+		}
+
+		if err := client.Insert(int32(timestamp), int32(price)); err != nil {
+			return imported, err
+		}
+
+		imported++
+	}
+}
+
+func run(config *Config) error {
+	client, err := meansclient.Dial(config.addr)
+
+	if err != nil {
+		return err
+	}
+
+	defer client.Close()
+
+	if config.importPath != "" {
+		imported, err := importPrices(client, config.importPath)
+
+		if err != nil {
+			return err
+		}
+
+		log.Printf("Imported %d prices from %s", imported, config.importPath)
+	}
+
+	// Every query is sent before the first mean is read
+	for _, query := range config.queries {
+		if err := client.SendMean(query.minTime, query.maxTime); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Flush(); err != nil {
+		return err
+	}
+
+	for _, query := range config.queries {
+		mean, err := client.ReadMean()
+
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%d\t%d\t%d\n", query.minTime, query.maxTime, mean)
+	}
+
+	return nil
+}
+
+func main() {
+	if err := run(parseFlags()); err != nil {
+		log.Fatal(err)
+	}
+}
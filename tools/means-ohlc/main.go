@@ -0,0 +1,172 @@
+package main
+
+import (
+	"cmp"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/nivekithan/go-network/problems/means-to-end/archive"
+)
+
+type Config struct {
+	// Length of a bar in timestamp units
+	interval int64
+
+	// Archive files, or directories whose archives are all read
+	paths []string
+}
+
+func parseFlags() *Config {
+	config := &Config{}
+
+	flag.Int64Var(&config.interval, "interval", 60, "length of a bar in timestamp units")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] archive-or-directory...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+
+	flag.Parse()
+
+	config.paths = flag.Args()
+
+	return config
+}
+
+// Open, high, low and close of the prices of a single interval
+type Bar struct {
+	start int64
+	open  int32
+	high  int32
+	low   int32
+	close int32
+	count int
+}
+
+// Splits a series in timestamp order into bars. Bars start at multiples of
+// interval and intervals without prices have no bar
+func computeBars(points []archive.Point, interval int64) []Bar {
+	bars := []Bar{}
+
+	for _, point := range points {
+		timestamp := int64(point.Timestamp)
+		start := timestamp - ((timestamp%interval)+interval)%interval
+
+		if len(bars) == 0 || bars[len(bars)-1].start != start {
+			bars = append(bars, Bar{start: start, open: point.Price, high: point.Price, low: point.Price})
+		}
+
+		bar := &bars[len(bars)-1]
+		bar.high = max(bar.high, point.Price)
+		bar.low = min(bar.low, point.Price)
+		bar.close = point.Price
+		bar.count++
+	}
+
+	return bars
+}
+
+// Archive files named by paths, with directories replaced by the archives
+// they contain
+func archiveFiles(paths []string) ([]string, error) {
+	files := []string{}
+
+	for _, path := range paths {
+		info, err := os.Stat(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+
+		entries, err := os.ReadDir(path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range entries {
+			name := entry.Name()
+
+			if entry.IsDir() || strings.HasPrefix(name, ".") {
+				continue
+			}
+
+			if ext := filepath.Ext(name); ext == archive.Extension(archive.FormatCSV) || ext == archive.Extension(archive.FormatBinary) {
+				files = append(files, filepath.Join(path, name))
+			}
+		}
+	}
+
+	slices.Sort(files)
+
+	return files, nil
+}
+
+func run(config *Config) error {
+	if config.interval <= 0 {
+		return errors.New("-interval must be positive")
+	}
+
+	if len(config.paths) == 0 {
+		flag.Usage()
+		return errors.New("no archives given")
+	}
+
+	files, err := archiveFiles(config.paths)
+
+	if err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(os.Stdout)
+	writer.Write([]string{"series", "start", "open", "high", "low", "close", "count"})
+
+	for _, file := range files {
+		points, err := archive.ReadFile(file)
+
+		if err != nil {
+			return err
+		}
+
+		// Archives are written in timestamp order, but other tools may not
+		slices.SortStableFunc(points, func(a, b archive.Point) int {
+			return cmp.Compare(a.Timestamp, b.Timestamp)
+		})
+
+		series := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+
+		for _, bar := range computeBars(points, config.interval) {
+			writer.Write([]string{
+				series,
+				strconv.FormatInt(bar.start, 10),
+				strconv.Itoa(int(bar.open)),
+				strconv.Itoa(int(bar.high)),
+				strconv.Itoa(int(bar.low)),
+				strconv.Itoa(int(bar.close)),
+				strconv.Itoa(bar.count),
+			})
+		}
+	}
+
+	writer.Flush()
+
+	return writer.Error()
+}
+
+func main() {
+	if err := run(parseFlags()); err != nil {
+		log.Fatal(err)
+	}
+}
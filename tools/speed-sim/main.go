@@ -0,0 +1,495 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nivekithan/go-network/problems/speed-daemon/client"
+)
+
+type Config struct {
+	addr string
+
+	roads          int
+	camerasPerRoad int
+	cars           int
+	mileSpacing    int
+	limit          int
+
+	speedDist   string
+	speedMean   float64
+	speedStdDev float64
+	speedMin    float64
+	speedMax    float64
+
+	dispatchersPerRoad int
+	dispatcherDelay    time.Duration
+	timeout            time.Duration
+	settle             time.Duration
+	seed               uint64
+	platePrefix        string
+}
+
+// A single pass of a car in front of a camera
+type Observation struct {
+	car       int
+	road      int
+	camera    int
+	timestamp uint32
+}
+
+type Car struct {
+	plate string
+	road  int
+	speed float64
+
+	// Timestamps at which the car passes each camera of its road
+	timestamps []uint32
+
+	// Indexes of consecutive camera pairs at which the server should find the
+	// car speeding
+	violations [][2]int
+
+	sentAt []time.Time
+}
+
+func (c *Car) expectTicket() bool {
+	return len(c.violations) > 0
+}
+
+// Time at which the server had enough observations to ticket the car
+func (c *Car) ticketableAt() time.Time {
+	var ticketable time.Time
+
+	for _, pair := range c.violations {
+		completedAt := c.sentAt[pair[0]]
+
+		if c.sentAt[pair[1]].After(completedAt) {
+			completedAt = c.sentAt[pair[1]]
+		}
+
+		if ticketable.IsZero() || completedAt.Before(ticketable) {
+			ticketable = completedAt
+		}
+	}
+
+	return ticketable
+}
+
+type ReceivedTicket struct {
+	ticket     client.Ticket
+	receivedAt time.Time
+}
+
+func parseFlags() (*Config, error) {
+	config := &Config{}
+
+	flag.StringVar(&config.addr, "addr", "localhost:8000", "address of the speed-daemon server. A comma separated list spreads cameras and dispatchers over several nodes")
+	flag.IntVar(&config.roads, "roads", 4, "number of roads")
+	flag.IntVar(&config.camerasPerRoad, "cameras", 3, "number of cameras per road")
+	flag.IntVar(&config.cars, "cars", 100, "number of cars")
+	flag.IntVar(&config.mileSpacing, "spacing", 10, "miles between consecutive cameras")
+	flag.IntVar(&config.limit, "limit", 60, "speed limit of every road in miles per hour")
+	flag.StringVar(&config.speedDist, "dist", "normal", "speed distribution: normal or uniform")
+	flag.Float64Var(&config.speedMean, "speed-mean", 60, "mean speed for the normal distribution")
+	flag.Float64Var(&config.speedStdDev, "speed-stddev", 10, "standard deviation for the normal distribution")
+	flag.Float64Var(&config.speedMin, "speed-min", 30, "minimum speed for the uniform distribution")
+	flag.Float64Var(&config.speedMax, "speed-max", 90, "maximum speed for the uniform distribution")
+	flag.IntVar(&config.dispatchersPerRoad, "dispatchers", 1, "number of dispatchers per road")
+	flag.DurationVar(&config.dispatcherDelay, "dispatcher-delay", 0, "connect dispatchers this long after the cameras start sending")
+	flag.DurationVar(&config.timeout, "timeout", 30*time.Second, "how long to wait for all expected tickets")
+	flag.DurationVar(&config.settle, "settle", 2*time.Second, "how long to wait for duplicate tickets after all expected tickets arrived")
+	flag.Uint64Var(&config.seed, "seed", uint64(time.Now().UnixNano()), "random seed")
+	flag.StringVar(&config.platePrefix, "plate-prefix", "", "prefix of every plate. Defaults to a random prefix so that runs against the same server do not conflict")
+
+	flag.Parse()
+
+	if config.roads < 1 || config.roads > math.MaxUint16 {
+		return nil, fmt.Errorf("invalid number of roads: %d", config.roads)
+	}
+
+	if config.camerasPerRoad < 2 {
+		return nil, fmt.Errorf("at least two cameras per road are needed: %d", config.camerasPerRoad)
+	}
+
+	if config.mileSpacing < 1 {
+		return nil, fmt.Errorf("invalid spacing between cameras: %d", config.mileSpacing)
+	}
+
+	if (config.camerasPerRoad-1)*config.mileSpacing > math.MaxUint16 {
+		return nil, fmt.Errorf("cameras do not fit on a road: %d cameras spaced %d miles apart", config.camerasPerRoad, config.mileSpacing)
+	}
+
+	if config.speedDist != "normal" && config.speedDist != "uniform" {
+		return nil, fmt.Errorf("unknown speed distribution: %s", config.speedDist)
+	}
+
+	// Speeds above maxSpeed would wrap around in the tickets instead of
+	// being ticketed
+	if config.speedDist == "uniform" && config.speedMax > config.maxSpeed() {
+		return nil, fmt.Errorf("-speed-max %v is above %.0f mph, the fastest speed a ticket can carry with cameras %d miles apart", config.speedMax, config.maxSpeed(), config.mileSpacing)
+	}
+
+	if config.speedDist == "normal" && config.speedMean > config.maxSpeed() {
+		return nil, fmt.Errorf("-speed-mean %v is above %.0f mph, the fastest speed a ticket can carry with cameras %d miles apart", config.speedMean, config.maxSpeed(), config.mileSpacing)
+	}
+
+	if config.minSpeed() > config.maxSpeed() {
+		return nil, fmt.Errorf("cars cannot pass %d cameras spaced %d miles apart within a day at a speed a ticket can carry", config.camerasPerRoad, config.mileSpacing)
+	}
+
+	// Every car drives on its own day, so that the one ticket per day rule
+	// never hides an expected ticket
+	if config.cars < 1 || config.cars > math.MaxUint32/86400-1 {
+		return nil, fmt.Errorf("invalid number of cars: %d", config.cars)
+	}
+
+	return config, nil
+}
+
+func (config *Config) randomSpeed(rng *rand.Rand) float64 {
+	var speed float64
+
+	if config.speedDist == "uniform" {
+		speed = config.speedMin + rng.Float64()*(config.speedMax-config.speedMin)
+	} else {
+		speed = config.speedMean + rng.NormFloat64()*config.speedStdDev
+	}
+
+	// The tail of the normal distribution is cut at maxSpeed
+	return min(max(speed, config.minSpeed(), 1), config.maxSpeed())
+}
+
+// Fastest speed a ticket carries, as 100 * mph in a uint16
+const maxTicketSpeed = math.MaxUint16 / 100
+
+// Slowest speed which keeps the whole trip within a single day
+func (config *Config) minSpeed() float64 {
+	return float64((config.camerasPerRoad-1)*config.mileSpacing) / 20
+}
+
+// Fastest speed whose ticket speed fits the protocol's uint16. Timestamps
+// are rounded to the second, which can shorten the time between two cameras
+// by up to a second and raise the speed the server observes
+func (config *Config) maxSpeed() float64 {
+	mileSeconds := float64(config.mileSpacing) * 3600
+
+	return mileSeconds / (mileSeconds/maxTicketSpeed + 1)
+}
+
+// Same rule as the server: the average speed between two observations,
+// rounded to the nearest mile per hour, must exceed the limit
+func isSpeeding(mile1, mile2 int, timestamp1, timestamp2 uint32, limit int) bool {
+	distance := math.Abs(float64(mile2 - mile1))
+	hours := math.Abs(float64(int64(timestamp2)-int64(timestamp1)) / (60 * 60))
+
+	return int64(math.Round(distance/hours)) > int64(limit)
+}
+
+func generateCars(config *Config, rng *rand.Rand) []*Car {
+	cars := make([]*Car, config.cars)
+
+	for i := range cars {
+		car := &Car{
+			plate:      fmt.Sprintf("%s%05d", config.platePrefix, i),
+			road:       i % config.roads,
+			speed:      config.randomSpeed(rng),
+			timestamps: make([]uint32, config.camerasPerRoad),
+			sentAt:     make([]time.Time, config.camerasPerRoad),
+		}
+
+		start := float64((i+1)*86400) + rng.Float64()*3600
+
+		for camera := range car.timestamps {
+			mile := float64(camera * config.mileSpacing)
+			car.timestamps[camera] = uint32(math.Round(start + mile/car.speed*3600))
+		}
+
+		for camera := 1; camera < config.camerasPerRoad; camera++ {
+			if isSpeeding(
+				(camera-1)*config.mileSpacing,
+				camera*config.mileSpacing,
+				car.timestamps[camera-1],
+				car.timestamps[camera],
+				config.limit,
+			) {
+				car.violations = append(car.violations, [2]int{camera - 1, camera})
+			}
+		}
+
+		cars[i] = car
+	}
+
+	return cars
+}
+
+// The server never forgets a ticket, so plates are not derived from the seed
+// to allow replaying a seed against the same server
+func randomPlatePrefix() string {
+	prefix := make([]byte, 4)
+
+	for i := range prefix {
+		prefix[i] = byte('A' + rand.IntN(26))
+	}
+
+	return string(prefix)
+}
+
+func roadId(road int) uint16 {
+	return uint16(road + 1)
+}
+
+func run() error {
+	config, err := parseFlags()
+
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Seed: %d", config.seed)
+
+	rng := rand.New(rand.NewPCG(config.seed, config.seed))
+
+	if config.platePrefix == "" {
+		config.platePrefix = randomPlatePrefix()
+	}
+
+	cars := generateCars(config, rng)
+
+	expected := 0
+
+	for _, car := range cars {
+		if car.expectTicket() {
+			expected++
+		}
+	}
+
+	log.Printf("Simulating %d cars on %d roads with %d cameras each. Expecting %d tickets", config.cars, config.roads, config.camerasPerRoad, expected)
+
+	addrs := strings.Split(config.addr, ",")
+	connections := 0
+
+	// Round robin over the nodes
+	nextAddr := func() string {
+		connections++
+		return addrs[connections%len(addrs)]
+	}
+
+	cameras := make([][]*client.Camera, config.roads)
+
+	for road := range cameras {
+		cameras[road] = make([]*client.Camera, config.camerasPerRoad)
+
+		for i := range cameras[road] {
+			camera, err := client.DialCamera(nextAddr(), roadId(road), uint16(i*config.mileSpacing), uint16(config.limit))
+
+			if err != nil {
+				return err
+			}
+
+			defer camera.Close()
+
+			cameras[road][i] = camera
+		}
+	}
+
+	ticketChan := make(chan ReceivedTicket)
+	var dispatchersLock sync.Mutex
+	dispatchers := []*client.Dispatcher{}
+
+	connectDispatchers := func() error {
+		for road := range config.roads {
+			for range config.dispatchersPerRoad {
+				dispatcher, err := client.DialDispatcher(nextAddr(), []uint16{roadId(road)})
+
+				if err != nil {
+					return err
+				}
+
+				dispatchersLock.Lock()
+				dispatchers = append(dispatchers, dispatcher)
+				dispatchersLock.Unlock()
+
+				go func() {
+					for ticket := range dispatcher.Tickets() {
+						ticketChan <- ReceivedTicket{ticket: ticket, receivedAt: time.Now()}
+					}
+
+					if err := dispatcher.Err(); err != nil {
+						log.Printf("Dispatcher for road %d closed: %v", roadId(road), err)
+					}
+				}()
+			}
+		}
+
+		return nil
+	}
+
+	defer func() {
+		dispatchersLock.Lock()
+		defer dispatchersLock.Unlock()
+
+		for _, dispatcher := range dispatchers {
+			dispatcher.Close()
+		}
+	}()
+
+	if config.dispatcherDelay == 0 {
+		if err := connectDispatchers(); err != nil {
+			return err
+		}
+	} else {
+		time.AfterFunc(config.dispatcherDelay, func() {
+			if err := connectDispatchers(); err != nil {
+				log.Printf("error connecting dispatchers: %v", err)
+			}
+		})
+	}
+
+	observations := []Observation{}
+
+	for i, car := range cars {
+		for camera, timestamp := range car.timestamps {
+			observations = append(observations, Observation{car: i, road: car.road, camera: camera, timestamp: timestamp})
+		}
+	}
+
+	// Cameras report out of order in the real world
+	rng.Shuffle(len(observations), func(i, j int) {
+		observations[i], observations[j] = observations[j], observations[i]
+	})
+
+	start := time.Now()
+
+	for _, observation := range observations {
+		car := cars[observation.car]
+
+		if err := cameras[observation.road][observation.camera].SendPlate(car.plate, observation.timestamp); err != nil {
+			return err
+		}
+
+		car.sentAt[observation.camera] = time.Now()
+	}
+
+	sendDuration := time.Since(start)
+
+	log.Printf("Sent %d observations in %v (%.0f plates/s)", len(observations), sendDuration, float64(len(observations))/sendDuration.Seconds())
+
+	return verify(config, cars, ticketChan, expected, start)
+}
+
+func verify(config *Config, cars []*Car, ticketChan <-chan ReceivedTicket, expected int, start time.Time) error {
+	carByPlate := make(map[string]*Car, len(cars))
+
+	for _, car := range cars {
+		carByPlate[car.plate] = car
+	}
+
+	received := map[string][]ReceivedTicket{}
+	unexpected := []client.Ticket{}
+	receivedExpected := 0
+
+	timeout := time.After(config.timeout)
+	var settle <-chan time.Time
+
+	if expected == 0 {
+		settle = time.After(config.settle)
+	}
+
+loop:
+	for {
+		select {
+		case ticket := <-ticketChan:
+			car, ok := carByPlate[ticket.ticket.Plate]
+
+			if !ok || !car.expectTicket() {
+				unexpected = append(unexpected, ticket.ticket)
+				continue
+			}
+
+			received[car.plate] = append(received[car.plate], ticket)
+
+			if len(received[car.plate]) == 1 {
+				receivedExpected++
+			}
+
+			if receivedExpected == expected && settle == nil {
+				settle = time.After(config.settle)
+			}
+
+		case <-settle:
+			break loop
+
+		case <-timeout:
+			break loop
+		}
+	}
+
+	totalDuration := time.Since(start)
+
+	missing := []string{}
+	duplicates := []string{}
+	latencies := []time.Duration{}
+
+	for _, car := range cars {
+		if !car.expectTicket() {
+			continue
+		}
+
+		tickets := received[car.plate]
+
+		if len(tickets) == 0 {
+			missing = append(missing, car.plate)
+			continue
+		}
+
+		if len(tickets) > 1 {
+			duplicates = append(duplicates, car.plate)
+		}
+
+		latencies = append(latencies, max(tickets[0].receivedAt.Sub(car.ticketableAt()), 0))
+	}
+
+	fmt.Printf("tickets expected:   %d\n", expected)
+	fmt.Printf("tickets received:   %d\n", receivedExpected)
+	fmt.Printf("missing tickets:    %d %v\n", len(missing), missing)
+	fmt.Printf("duplicate tickets:  %d %v\n", len(duplicates), duplicates)
+	fmt.Printf("unexpected tickets: %d %+v\n", len(unexpected), unexpected)
+	fmt.Printf("throughput:         %.1f tickets/s\n", float64(receivedExpected)/totalDuration.Seconds())
+
+	if len(latencies) > 0 {
+		slices.Sort(latencies)
+
+		fmt.Printf("latency p50:        %v\n", percentile(latencies, 0.50))
+		fmt.Printf("latency p95:        %v\n", percentile(latencies, 0.95))
+		fmt.Printf("latency p99:        %v\n", percentile(latencies, 0.99))
+		fmt.Printf("latency max:        %v\n", latencies[len(latencies)-1])
+	}
+
+	if len(missing) > 0 || len(duplicates) > 0 || len(unexpected) > 0 {
+		return fmt.Errorf("ticket verification failed")
+	}
+
+	return nil
+}
+
+// Expects sorted durations
+func percentile(durations []time.Duration, p float64) time.Duration {
+	index := int(math.Ceil(p*float64(len(durations)))) - 1
+	return durations[max(index, 0)]
+}
+
+func main() {
+	if err := run(); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+}